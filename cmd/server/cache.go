@@ -0,0 +1,530 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// Default limits of the profile cache, overridable with command line flags
+const (
+	defaultMaxProfiles = 16
+	defaultMaxMemoryMB = 4096
+)
+
+// fileState identifies the contents of a profile file when it was loaded
+type fileState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"sha256"` // Hex SHA-256 of the contents
+}
+
+// sameStat reports whether size and modification time match, in which case the
+// contents are assumed unchanged without hashing them again
+func (fs fileState) sameStat(other fileState) bool {
+	return fs.Size == other.Size && fs.ModTime.Equal(other.ModTime)
+}
+
+// statFile returns the size and modification time of a file, leaving Hash empty.
+// It does not read the file, so it is cheap enough to run on every lookup.
+func statFile(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// hashFile returns the hex SHA-256 of a file's contents
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readFileState returns the size, modification time and content hash of a file. The
+// contents are only hashed when size or modification time differ from known, whose
+// hash is reused otherwise.
+func readFileState(path string, known fileState) (fileState, error) {
+	state, err := statFile(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	if state.sameStat(known) {
+		state.Hash = known.Hash
+		return state, nil
+	}
+	if state.Hash, err = hashFile(path); err != nil {
+		return fileState{}, err
+	}
+	return state, nil
+}
+
+// loadProfileFile loads a profile along with the state of its file, hashing the file
+// unless it matches known (see readFileState). The state is taken first, so a file
+// rewritten during the load is seen as changed on the next lookup.
+func loadProfileFile(path string, known fileState) (*sleepy.ProfileData, string, fileState, error) {
+	state, err := readFileState(path, known)
+	if err != nil {
+		return nil, "", fileState{}, fmt.Errorf("failed to read profile: %w", err)
+	}
+	profile, format, err := sleepy.LoadProfile(path)
+	if err != nil {
+		return nil, format, fileState{}, err
+	}
+	return profile, format, state, nil
+}
+
+// handlePattern matches the handles the cache assigns; aliases may not look like one
+var handlePattern = regexp.MustCompile(`^p[0-9]+$`)
+
+// aliasPattern is what an alias may consist of
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// normalizePath returns the absolute, cleaned form of a profile path and the key it is
+// cached under. Windows paths are case-insensitive, so their keys are lower-cased
+// (filepath.Clean already turns forward slashes into backslashes there).
+func normalizePath(path string) (absPath, key string) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = filepath.Clean(path)
+	}
+	key = absPath
+	if runtime.GOOS == "windows" {
+		key = strings.ToLower(key)
+	}
+	return absPath, key
+}
+
+// samePath reports whether two paths name the same file: they normalize to the same key,
+// or both exist and are the same file (e.g. reached through a link)
+func samePath(a, b string) bool {
+	_, keyA := normalizePath(a)
+	_, keyB := normalizePath(b)
+	if keyA == keyB {
+		return true
+	}
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// validateAlias checks that alias can name a profile without being mistaken for a handle
+func validateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return fmt.Errorf("invalid alias %q: use letters, digits, '.', '_' and '-', starting with a letter or digit", alias)
+	}
+	if handlePattern.MatchString(alias) {
+		return fmt.Errorf("invalid alias %q: names like p1 are reserved for handles", alias)
+	}
+	return nil
+}
+
+// cachedProfile is a loaded profile with its bookkeeping
+type cachedProfile struct {
+	Key        string // Normalized path the profile is cached under
+	Path       string // Absolute path of the profile file
+	Handle     string // Short name assigned at load time, e.g. "p1"
+	Alias      string // Optional user-chosen name, e.g. "baseline"
+	Profile    *sleepy.ProfileData
+	Size       int64 // Approximate memory use in bytes
+	File       fileState
+	LoadedAt   time.Time
+	LastAccess time.Time
+
+	element *list.Element
+}
+
+// Ref identifies the profile in tool output
+func (e *cachedProfile) Ref() profileRef {
+	return profileRef{Handle: e.Handle, Alias: e.Alias, Path: e.Path}
+}
+
+// Label names the profile for output, e.g. "p1 (baseline): /captures/run1.sleepy"
+func (e *cachedProfile) Label() string {
+	return e.Ref().Label()
+}
+
+// profileRef identifies a loaded profile in tool output
+type profileRef struct {
+	Handle string `json:"handle"`
+	Alias  string `json:"alias,omitempty"`
+	Path   string `json:"path"`
+}
+
+// Label renders the reference as "handle (alias): path", or just the path without a handle
+func (r profileRef) Label() string {
+	switch {
+	case r.Handle == "":
+		return r.Path
+	case r.Alias != "":
+		return fmt.Sprintf("%s (%s): %s", r.Handle, r.Alias, r.Path)
+	}
+	return fmt.Sprintf("%s: %s", r.Handle, r.Path)
+}
+
+// profileStore is a thread-safe cache of loaded profiles keyed by normalized file path.
+// Profiles can be referred to by handle, alias or path (see lookupLocked). When the cache
+// holds more than maxEntries profiles or more than maxBytes of (approximate) profile memory,
+// the least recently used profiles are evicted. A zero limit disables that check.
+// Profiles whose file changes on disk are reloaded on their next lookup, or only
+// reported when autoReload is off.
+type profileStore struct {
+	mu         sync.Mutex
+	entries    map[string]*cachedProfile
+	names      map[string]string         // Handle or alias → key
+	reloads    map[string]*pendingReload // Key → reload of a changed file in progress
+	lru        *list.List                // Most recently used at the front
+	size       int64
+	nextHandle int
+	maxEntries int
+	maxBytes   int64
+	autoReload bool
+}
+
+// pendingReload is an automatic reload of a changed file that concurrent lookups of the
+// same profile wait for instead of loading the file again
+type pendingReload struct {
+	done    chan struct{}
+	profile *sleepy.ProfileData
+	state   fileState
+	evicted []string
+	err     error
+}
+
+func newProfileStore(maxEntries int, maxBytes int64, autoReload bool) *profileStore {
+	return &profileStore{
+		entries:    make(map[string]*cachedProfile),
+		names:      make(map[string]string),
+		reloads:    make(map[string]*pendingReload),
+		lru:        list.New(),
+		nextHandle: 1,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		autoReload: autoReload,
+	}
+}
+
+// Get returns the profile ref refers to and marks it as recently used. If the profile's
+// file changed on disk since it was loaded, the profile is reloaded first (or kept, without
+// auto-reload). notices tell the user what happened and are empty if the file is unchanged.
+func (c *profileStore) Get(ref string) (profile *sleepy.ProfileData, notices []toolNotice, ok bool) {
+	c.mu.Lock()
+	entry := c.lookupLocked(ref)
+	if entry == nil {
+		c.mu.Unlock()
+		return nil, nil, false
+	}
+	entry.LastAccess = time.Now()
+	c.lru.MoveToFront(entry.element)
+	profile, path, label, loaded := entry.Profile, entry.Path, entry.Label(), entry.File
+	c.mu.Unlock()
+
+	// Loading can take a while, so the file is checked and reloaded without holding the lock.
+	// The contents are only hashed once size or modification time changed.
+	current, err := statFile(path)
+	switch {
+	case err != nil:
+		return profile, []toolNotice{newNotice(noticeWarning, "%s can no longer be read (%v); using the cached copy.", label, err)}, true
+	case current.sameStat(loaded):
+		return profile, nil, true
+	}
+
+	current.Hash, err = hashFile(path)
+	switch {
+	case err != nil:
+		return profile, []toolNotice{newNotice(noticeWarning, "%s can no longer be read (%v); using the cached copy.", label, err)}, true
+	case current.Hash == loaded.Hash:
+		// Touched but not rewritten: remember the new timestamp so the file is not hashed again
+		c.updateFileState(path, profile, current)
+		return profile, nil, true
+	case !c.autoReload:
+		return profile, []toolNotice{newNotice(noticeWarning, "%s changed on disk since it was loaded (modified %s); results are from the old copy. Use reload_profile to load the new one.",
+			label, current.ModTime.Format(time.DateTime))}, true
+	}
+
+	reload := c.reloadChanged(path, profile, current)
+	if reload.err != nil {
+		return profile, []toolNotice{newNotice(noticeWarning, "%s changed on disk but could not be reloaded (%v); using the cached copy.", label, reload.err)}, true
+	}
+	notices = append(notices, newNotice(noticeReloaded, "%s changed on disk (modified %s) and was reloaded.", label, reload.state.ModTime.Format(time.DateTime)))
+	if len(reload.evicted) > 0 {
+		notices = append(notices, newNotice(noticeEvicted, "Unloaded to stay within the cache limits: %s.", strings.Join(reload.evicted, ", ")))
+	}
+	return reload.profile, notices, true
+}
+
+// reloadChanged loads path's changed file again and caches it in place of profile. If
+// another lookup is already reloading the file, it waits for that reload instead, and if
+// one already did, its result is used. A profile
+// that was unloaded or replaced meanwhile is not cached again; the reloaded copy is still
+// returned to the caller.
+func (c *profileStore) reloadChanged(path string, profile *sleepy.ProfileData, current fileState) *pendingReload {
+	_, key := normalizePath(path)
+
+	c.mu.Lock()
+	if reload, exists := c.reloads[key]; exists {
+		c.mu.Unlock()
+		<-reload.done
+		return reload
+	}
+	if entry, exists := c.entries[key]; exists && entry.Profile != profile && entry.File.Hash == current.Hash {
+		// Another lookup finished reloading the same contents since profile was looked up
+		c.mu.Unlock()
+		return &pendingReload{profile: entry.Profile, state: entry.File}
+	}
+	reload := &pendingReload{done: make(chan struct{})}
+	c.reloads[key] = reload
+	c.mu.Unlock()
+
+	reload.profile, _, reload.state, reload.err = loadProfileFile(path, current)
+	if reload.err == nil {
+		reload.evicted, _ = c.replace(path, profile, reload.profile, reload.state)
+	}
+
+	c.mu.Lock()
+	delete(c.reloads, key)
+	c.mu.Unlock()
+	close(reload.done)
+	return reload
+}
+
+// Ref identifies the profile ref refers to for output. A ref that is not loaded
+// is returned as a path.
+func (c *profileStore) Ref(ref string) profileRef {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry := c.lookupLocked(ref); entry != nil {
+		return entry.Ref()
+	}
+	return profileRef{Path: ref}
+}
+
+// Label names the profile ref refers to for output, or returns ref itself if it is not loaded
+func (c *profileStore) Label(ref string) string {
+	return c.Ref(ref).Label()
+}
+
+// Reload loads the profile ref refers to from its file again, whether or not the file changed.
+// It returns the new profile, the states of the file at the previous and this load, and the
+// labels of the profiles evicted to make room. The file is only hashed again if its size or
+// modification time changed.
+func (c *profileStore) Reload(ref string) (profile *sleepy.ProfileData, previous, current fileState, evicted []string, err error) {
+	c.mu.Lock()
+	entry := c.lookupLocked(ref)
+	var path string
+	var loaded *sleepy.ProfileData
+	if entry != nil {
+		path, previous, loaded = entry.Path, entry.File, entry.Profile
+	}
+	c.mu.Unlock()
+	if entry == nil {
+		return nil, previous, current, nil, fmt.Errorf("profile not loaded")
+	}
+
+	profile, _, current, err = loadProfileFile(path, previous)
+	if err != nil {
+		return nil, previous, current, nil, err
+	}
+	evicted, ok := c.replace(path, loaded, profile, current)
+	if !ok {
+		return nil, previous, current, nil, fmt.Errorf("profile was unloaded or replaced while reloading")
+	}
+	return profile, previous, current, evicted, nil
+}
+
+// updateFileState records a new state for path's file, unless the profile has been replaced meanwhile
+func (c *profileStore) updateFileState(path string, profile *sleepy.ProfileData, state fileState) {
+	_, key := normalizePath(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, exists := c.entries[key]; exists && entry.Profile == profile {
+		entry.File = state
+	}
+}
+
+// replace caches reloaded, loaded from path, in place of profile and returns the labels of
+// the profiles evicted to make room. Nothing is cached, and false is returned, if the profile
+// was unloaded or replaced while reloaded was being loaded.
+func (c *profileStore) replace(path string, profile, reloaded *sleepy.ProfileData, state fileState) ([]string, bool) {
+	size := reloaded.ApproximateSize()
+	_, key := normalizePath(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, exists := c.entries[key]; !exists || entry.Profile != profile {
+		return nil, false
+	}
+	// An empty alias keeps the profile's handle and alias, so putLocked cannot fail here
+	_, evicted, _ := c.putLocked(path, reloaded, size, state, "")
+	return evicted, true
+}
+
+// CheckAlias reports whether alias can be given to the profile loaded from path: it must be
+// well-formed and not name another profile. An empty alias is always accepted.
+func (c *profileStore) CheckAlias(alias, path string) error {
+	if alias == "" {
+		return nil
+	}
+	if err := validateAlias(alias); err != nil {
+		return err
+	}
+	_, key := normalizePath(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checkAliasOwnerLocked(alias, key)
+}
+
+// Put caches the profile loaded from path, replacing any profile already loaded from it, and
+// returns the profile's handle and the labels of the profiles evicted to stay within the
+// limits. The new profile itself is never evicted, even if it alone exceeds the memory budget.
+// A replaced profile's handle is kept, and so is its alias unless a new one is given.
+// file is the state of the file the profile was loaded from.
+func (c *profileStore) Put(path string, profile *sleepy.ProfileData, file fileState, alias string) (string, []string, error) {
+	if alias != "" {
+		if err := validateAlias(alias); err != nil {
+			return "", nil, err
+		}
+	}
+	size := profile.ApproximateSize()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.putLocked(path, profile, size, file, alias)
+}
+
+// putLocked caches a profile of the given approximate size like Put
+func (c *profileStore) putLocked(path string, profile *sleepy.ProfileData, size int64, file fileState, alias string) (string, []string, error) {
+	absPath, key := normalizePath(path)
+	now := time.Now()
+
+	if err := c.checkAliasOwnerLocked(alias, key); err != nil {
+		return "", nil, err
+	}
+
+	entry := &cachedProfile{
+		Key:        key,
+		Path:       absPath,
+		Alias:      alias,
+		Profile:    profile,
+		Size:       size,
+		File:       file,
+		LoadedAt:   now,
+		LastAccess: now,
+	}
+	if old, exists := c.entries[key]; exists {
+		entry.Handle = old.Handle
+		if entry.Alias == "" {
+			entry.Alias = old.Alias
+		}
+		c.removeLocked(old)
+	} else {
+		entry.Handle = "p" + strconv.Itoa(c.nextHandle)
+		c.nextHandle++
+	}
+
+	entry.element = c.lru.PushFront(entry)
+	c.entries[key] = entry
+	c.names[entry.Handle] = key
+	if entry.Alias != "" {
+		c.names[entry.Alias] = key
+	}
+	c.size += size
+
+	var evicted []string
+	for c.lru.Len() > 1 && c.overLimitLocked() {
+		oldest := c.lru.Back().Value.(*cachedProfile)
+		c.removeLocked(oldest)
+		evicted = append(evicted, oldest.Label())
+	}
+	return entry.Handle, evicted, nil
+}
+
+// Remove unloads the profile ref refers to, returning it and whether there was one
+func (c *profileStore) Remove(ref string) (profileRef, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.lookupLocked(ref)
+	if entry == nil {
+		return profileRef{}, false
+	}
+	c.removeLocked(entry)
+	return entry.Ref(), true
+}
+
+// List returns a snapshot of the cached profiles, most recently used first
+func (c *profileStore) List() []cachedProfile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]cachedProfile, 0, c.lru.Len())
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		entry := *e.Value.(*cachedProfile)
+		entry.element = nil
+		result = append(result, entry)
+	}
+	return result
+}
+
+// Usage returns the number of cached profiles and their approximate total size in bytes
+func (c *profileStore) Usage() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.size
+}
+
+// lookupLocked finds the profile ref refers to: a handle or alias first, otherwise a path
+// that normalizes to the path of a loaded profile
+func (c *profileStore) lookupLocked(ref string) *cachedProfile {
+	if key, exists := c.names[ref]; exists {
+		return c.entries[key]
+	}
+	_, key := normalizePath(ref)
+	return c.entries[key]
+}
+
+// checkAliasOwnerLocked fails if alias already names a profile other than the one cached under key
+func (c *profileStore) checkAliasOwnerLocked(alias, key string) error {
+	if owner, taken := c.names[alias]; alias != "" && taken && owner != key {
+		return fmt.Errorf("alias %q is already used by %s", alias, c.entries[owner].Label())
+	}
+	return nil
+}
+
+func (c *profileStore) overLimitLocked() bool {
+	return (c.maxEntries > 0 && len(c.entries) > c.maxEntries) ||
+		(c.maxBytes > 0 && c.size > c.maxBytes)
+}
+
+func (c *profileStore) removeLocked(entry *cachedProfile) {
+	c.lru.Remove(entry.element)
+	delete(c.entries, entry.Key)
+	delete(c.names, entry.Handle)
+	if entry.Alias != "" {
+		delete(c.names, entry.Alias)
+	}
+	c.size -= entry.Size
+}
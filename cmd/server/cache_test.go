@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// writeProfileFile writes a one-callstack .sleepy profile of the given duration to path
+func writeProfileFile(t *testing.T, path string, seconds float64) {
+	t.Helper()
+	pd := sleepy.NewProfileData(
+		sleepy.Stats{Filename: "app.exe", NumSamples: 1},
+		[]sleepy.Symbol{{Address: "0x10", ModuleName: "app.exe", ProcName: "main"}},
+		[]sleepy.Callstack{{Addresses: []uint64{0x10}, ThreadCounts: map[int]float64{1: seconds}}},
+		[]sleepy.Thread{{ID: 1, Name: "main"}},
+	)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := sleepy.WriteSleepyProfile(f, pd); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadIntoStore loads path into a new store and returns the store and the profile's handle
+func loadIntoStore(t *testing.T, path string) (*profileStore, string) {
+	t.Helper()
+	store := newProfileStore(0, 0, true)
+	profile, _, state, err := loadProfileFile(path, fileState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, _, err := store.Put(path, profile, state, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, handle
+}
+
+// rewriteProfileFile changes the profile on disk, making sure its modification time moves
+func rewriteProfileFile(t *testing.T, path string, seconds float64) {
+	t.Helper()
+	writeProfileFile(t, path, seconds)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProfileStoreGetReloadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.sleepy")
+	writeProfileFile(t, path, 1)
+	store, handle := loadIntoStore(t, path)
+
+	tests := []struct {
+		name     string
+		change   func()
+		reloaded bool
+		duration float64
+	}{
+		{"unchanged", func() {}, false, 1},
+		{"touched", func() {
+			later := time.Now().Add(2 * time.Minute)
+			os.Chtimes(path, later, later)
+		}, false, 1},
+		{"rewritten", func() { rewriteProfileFile(t, path, 2) }, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			profile, notices, ok := store.Get(handle)
+			if !ok {
+				t.Fatalf("Get(%q) found no profile", handle)
+			}
+			if reloaded := len(notices) > 0 && notices[0].Kind == noticeReloaded; reloaded != tt.reloaded {
+				t.Errorf("reloaded = %v, want %v (notices %v)", reloaded, tt.reloaded, notices)
+			}
+			if d := profile.Callstacks[0].GetDuration(); d != tt.duration {
+				t.Errorf("duration = %v, want %v", d, tt.duration)
+			}
+		})
+	}
+}
+
+func TestProfileStoreReloadDoesNotRestoreUnloadedProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.sleepy")
+	writeProfileFile(t, path, 1)
+	store, handle := loadIntoStore(t, path)
+	old, _, _ := store.Get(handle)
+
+	rewriteProfileFile(t, path, 2)
+	current, err := readFileState(path, fileState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The profile is unloaded after a lookup saw the change but before its reload finished
+	store.Remove(handle)
+	reload := store.reloadChanged(path, old, current)
+	if reload.err != nil {
+		t.Fatalf("reloadChanged() error = %v", reload.err)
+	}
+	if count, _ := store.Usage(); count != 0 {
+		t.Errorf("store holds %d profiles after the reload, want 0", count)
+	}
+}
+
+func TestProfileStoreConcurrentGetsShareReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.sleepy")
+	writeProfileFile(t, path, 1)
+	store, handle := loadIntoStore(t, path)
+	rewriteProfileFile(t, path, 2)
+
+	const lookups = 8
+	profiles := make([]*sleepy.ProfileData, lookups)
+	var wg sync.WaitGroup
+	for i := range profiles {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			profiles[i], _, _ = store.Get(handle)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, profile := range profiles {
+		if profile != profiles[0] {
+			t.Fatalf("lookup %d got a different copy of the reloaded profile", i)
+		}
+	}
+	if cached, _, _ := store.Get(handle); cached != profiles[0] {
+		t.Errorf("cache holds a different copy than the lookups returned")
+	}
+}
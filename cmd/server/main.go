@@ -1,444 +1,1287 @@
-package main
-
-import (
-	"context"
-	"fmt"
-	"log"
-	"strings"
-
-	"github.com/mark3labs/mcp-go/mcp"
-	"github.com/mark3labs/mcp-go/server"
-
-	"verysleepy-mcp/internal/analyzer"
-	"verysleepy-mcp/internal/sleepy"
-)
-
-// Profile cache
-var profileCache = make(map[string]*sleepy.ProfileData)
-
-func main() {
-	// Create MCP server
-	s := server.NewMCPServer(
-		"verysleepy-profiler",
-		"1.0.0",
-		server.WithLogging(),
-	)
-
-	// Tool 1: Load Profile
-	loadProfileTool := mcp.NewTool("load_profile",
-		mcp.WithDescription("Load a Very Sleepy .sleepy profile file for analysis"),
-		mcp.WithString("file_path",
-			mcp.Required(),
-			mcp.Description("Absolute path to the .sleepy profile file"),
-		),
-	)
-
-	s.AddTool(loadProfileTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		filePath, err := request.RequireString("file_path")
-		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
-		}
-
-		profile, err := sleepy.ReadSleepyProfile(filePath)
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("Failed to load profile: %v", err)), nil
-		}
-
-		profileCache[filePath] = profile
-
-		result := fmt.Sprintf(`Profile loaded successfully!
-
-File: %s
-Duration: %s
-Date: %s
-Samples: %d
-Callstacks: %d
-Symbols: %d
-Threads: %d
-
-Use other tools to analyze this profile.
-`,
-			filePath,
-			profile.Stats.Duration,
-			profile.Stats.Date,
-			profile.Stats.NumSamples,
-			len(profile.Callstacks),
-			len(profile.Symbols),
-			len(profile.Threads),
-		)
-
-		return mcp.NewToolResultText(result), nil
-	})
-
-	// Tool 2: Find Hotspots
-	findHotspotsTool := mcp.NewTool("find_hotspots",
-		mcp.WithDescription("Find the top CPU hotspots (functions consuming the most time) in the profile. This is the most important tool for identifying performance bottlenecks."),
-		mcp.WithString("file_path",
-			mcp.Required(),
-			mcp.Description("Path to the loaded .sleepy profile file"),
-		),
-		mcp.WithNumber("top_n",
-			mcp.Description("Number of top hotspots to return (default: 10)"),
-		),
-	)
-
-	s.AddTool(findHotspotsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		filePath, err := request.RequireString("file_path")
-		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
-		}
-
-		topN := 10
-		if n := request.GetFloat("top_n", 10.0); n != 10.0 {
-			topN = int(n)
-		}
-
-		profile, ok := profileCache[filePath]
-		if !ok {
-			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
-		}
-
-		hotspots := analyzer.FindHotspots(profile, topN)
-
-		var sb strings.Builder
-		sb.WriteString("🔥 TOP CPU HOTSPOTS (Functions Consuming Most Time)\n")
-		sb.WriteString("═══════════════════════════════════════════════════\n\n")
-
-		if len(hotspots) == 0 {
-			sb.WriteString("No hotspots found.\n")
-		} else {
-			for i, hs := range hotspots {
-				sb.WriteString(analyzer.FormatHotspot(hs, i+1))
-				sb.WriteString("\n")
-			}
-		}
-
-		return mcp.NewToolResultText(sb.String()), nil
-	})
-
-	// Tool 3: Find Bottom Functions
-	findBottomFunctionsTool := mcp.NewTool("find_bottom_functions",
-		mcp.WithDescription("Find leaf functions (functions at the bottom of callstacks - where actual CPU work happens). These are often the real performance bottlenecks to optimize."),
-		mcp.WithString("file_path",
-			mcp.Required(),
-			mcp.Description("Path to the loaded .sleepy profile file"),
-		),
-		mcp.WithNumber("top_n",
-			mcp.Description("Number of top functions to return (default: 10)"),
-		),
-	)
-
-	s.AddTool(findBottomFunctionsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		filePath, err := request.RequireString("file_path")
-		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
-		}
-
-		topN := 10
-		if n := request.GetFloat("top_n", 10.0); n != 10.0 {
-			topN = int(n)
-		}
-
-		profile, ok := profileCache[filePath]
-		if !ok {
-			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
-		}
-
-		bottomFuncs := analyzer.FindBottomFunctions(profile, topN)
-
-		var sb strings.Builder
-		sb.WriteString("🎯 LEAF FUNCTIONS (Where Actual CPU Work Happens)\n")
-		sb.WriteString("═══════════════════════════════════════════════════\n\n")
-		sb.WriteString("These are the functions at the bottom of callstacks - the actual CPU-intensive operations.\n")
-		sb.WriteString("Optimizing these will have direct performance impact.\n\n")
-
-		if len(bottomFuncs) == 0 {
-			sb.WriteString("No leaf functions found.\n")
-		} else {
-			for i, hs := range bottomFuncs {
-				sb.WriteString(fmt.Sprintf("#%d: %s!%s\n", i+1, hs.Module, hs.Function))
-				sb.WriteString(fmt.Sprintf("    Time: %.6f seconds (%.2f%%)\n", hs.TotalTime, hs.Percentage))
-				sb.WriteString(fmt.Sprintf("    Samples: %d\n", hs.SampleCount))
-				if hs.SourceFile != "" && hs.SourceFile != "[unknown]" {
-					sb.WriteString(fmt.Sprintf("    Source: %s:%d\n", hs.SourceFile, hs.LineNumber))
-				}
-				sb.WriteString("\n")
-			}
-		}
-
-		return mcp.NewToolResultText(sb.String()), nil
-	})
-
-	// Tool 4: Analyze Modules
-	analyzeModulesTool := mcp.NewTool("analyze_modules",
-		mcp.WithDescription("Analyze time spent in each module/library. Useful for identifying which components or libraries are consuming resources."),
-		mcp.WithString("file_path",
-			mcp.Required(),
-			mcp.Description("Path to the loaded .sleepy profile file"),
-		),
-	)
-
-	s.AddTool(analyzeModulesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		filePath, err := request.RequireString("file_path")
-		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
-		}
-
-		profile, ok := profileCache[filePath]
-		if !ok {
-			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
-		}
-
-		moduleHotspots := analyzer.FindModuleHotspots(profile)
-
-		totalTime := 0.0
-		for _, time := range moduleHotspots {
-			totalTime += time
-		}
-
-		type ModuleTime struct {
-			Module     string
-			Time       float64
-			Percentage float64
-		}
-
-		modules := make([]ModuleTime, 0, len(moduleHotspots))
-		for module, time := range moduleHotspots {
-			pct := 0.0
-			if totalTime > 0 {
-				pct = (time / totalTime) * 100.0
-			}
-			modules = append(modules, ModuleTime{
-				Module:     module,
-				Time:       time,
-				Percentage: pct,
-			})
-		}
-
-		// Sort by time descending
-		for i := 0; i < len(modules); i++ {
-			for j := i + 1; j < len(modules); j++ {
-				if modules[j].Time > modules[i].Time {
-					modules[i], modules[j] = modules[j], modules[i]
-				}
-			}
-		}
-
-		var sb strings.Builder
-		sb.WriteString("📦 MODULE TIME ANALYSIS\n")
-		sb.WriteString("═══════════════════════════════════════════════════\n\n")
-
-		for i, m := range modules {
-			sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, m.Module))
-			sb.WriteString(fmt.Sprintf("   Time: %.6f seconds (%.2f%%)\n", m.Time, m.Percentage))
-
-			barLength := int(m.Percentage / 2)
-			if barLength > 50 {
-				barLength = 50
-			}
-			sb.WriteString("   ")
-			sb.WriteString(strings.Repeat("█", barLength))
-			sb.WriteString("\n\n")
-		}
-
-		return mcp.NewToolResultText(sb.String()), nil
-	})
-
-	// Tool 5: Detect Performance Issues
-	detectIssuesTool := mcp.NewTool("detect_performance_issues",
-		mcp.WithDescription("Automatically detect potential performance issues using heuristics. This is a great starting point for performance analysis."),
-		mcp.WithString("file_path",
-			mcp.Required(),
-			mcp.Description("Path to the loaded .sleepy profile file"),
-		),
-	)
-
-	s.AddTool(detectIssuesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		filePath, err := request.RequireString("file_path")
-		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
-		}
-
-		profile, ok := profileCache[filePath]
-		if !ok {
-			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
-		}
-
-		issues := analyzer.DetectPerformanceIssues(profile)
-
-		var sb strings.Builder
-		sb.WriteString("⚠️  AUTOMATED PERFORMANCE ISSUE DETECTION\n")
-		sb.WriteString("═══════════════════════════════════════════════════\n\n")
-
-		if len(issues) == 0 {
-			sb.WriteString("✅ No significant performance issues detected!\n")
-		} else {
-			critical := []analyzer.PerformanceIssue{}
-			high := []analyzer.PerformanceIssue{}
-			medium := []analyzer.PerformanceIssue{}
-			low := []analyzer.PerformanceIssue{}
-
-			for _, issue := range issues {
-				switch issue.Severity {
-				case "Critical":
-					critical = append(critical, issue)
-				case "High":
-					high = append(high, issue)
-				case "Medium":
-					medium = append(medium, issue)
-				case "Low":
-					low = append(low, issue)
-				}
-			}
-
-			if len(critical) > 0 {
-				sb.WriteString("🔴 CRITICAL ISSUES:\n\n")
-				for i, issue := range critical {
-					sb.WriteString(fmt.Sprintf("%d. [%s] %s\n", i+1, issue.Category, issue.Description))
-					if issue.Function != "" {
-						sb.WriteString(fmt.Sprintf("   Function: %s!%s\n", issue.Module, issue.Function))
-					}
-					if issue.Impact > 0 {
-						sb.WriteString(fmt.Sprintf("   Impact: %.2f%% of total time\n", issue.Impact))
-					}
-					sb.WriteString("\n")
-				}
-			}
-
-			if len(high) > 0 {
-				sb.WriteString("🟠 HIGH PRIORITY ISSUES:\n\n")
-				for i, issue := range high {
-					sb.WriteString(fmt.Sprintf("%d. [%s] %s\n", i+1, issue.Category, issue.Description))
-					if issue.Function != "" {
-						sb.WriteString(fmt.Sprintf("   Function: %s!%s\n", issue.Module, issue.Function))
-					}
-					if issue.Impact > 0 {
-						sb.WriteString(fmt.Sprintf("   Impact: %.2f%% of total time\n", issue.Impact))
-					}
-					sb.WriteString("\n")
-				}
-			}
-
-			sb.WriteString("\n📊 SUMMARY:\n")
-			sb.WriteString(fmt.Sprintf("   Critical: %d\n", len(critical)))
-			sb.WriteString(fmt.Sprintf("   High: %d\n", len(high)))
-			sb.WriteString(fmt.Sprintf("   Medium: %d\n", len(medium)))
-			sb.WriteString(fmt.Sprintf("   Low: %d\n", len(low)))
-		}
-
-		return mcp.NewToolResultText(sb.String()), nil
-	})
-
-	// Tool 6: Get Statistics
-	getStatisticsTool := mcp.NewTool("get_statistics",
-		mcp.WithDescription("Get comprehensive statistics about the profile including total time, callstack depths, unique functions/modules, etc."),
-		mcp.WithString("file_path",
-			mcp.Required(),
-			mcp.Description("Path to the loaded .sleepy profile file"),
-		),
-	)
-
-	s.AddTool(getStatisticsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		filePath, err := request.RequireString("file_path")
-		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
-		}
-
-		profile, ok := profileCache[filePath]
-		if !ok {
-			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
-		}
-
-		stats := analyzer.ComputeStatistics(profile)
-
-		var sb strings.Builder
-		sb.WriteString("📊 PROFILE STATISTICS\n")
-		sb.WriteString("═══════════════════════════════════════════════════\n\n")
-
-		sb.WriteString(fmt.Sprintf("Total Execution Time: %.6f seconds\n", stats.TotalTime))
-		sb.WriteString(fmt.Sprintf("Total Callstacks: %d\n", stats.TotalCallstacks))
-		sb.WriteString(fmt.Sprintf("Total Symbols: %d\n\n", stats.TotalSymbols))
-
-		sb.WriteString("Call Stack Depth Statistics:\n")
-		sb.WriteString(fmt.Sprintf("  Average: %.2f frames\n", stats.AverageStackDepth))
-		sb.WriteString(fmt.Sprintf("  Maximum: %d frames\n", stats.MaxStackDepth))
-		sb.WriteString(fmt.Sprintf("  Minimum: %d frames\n\n", stats.MinStackDepth))
-
-		sb.WriteString("Unique Elements:\n")
-		sb.WriteString(fmt.Sprintf("  Modules: %d\n", stats.UniqueModules))
-		sb.WriteString(fmt.Sprintf("  Functions: %d\n", stats.UniqueFunctions))
-
-		return mcp.NewToolResultText(sb.String()), nil
-	})
-
-	// Tool 7: View Callstack
-	viewCallstackTool := mcp.NewTool("view_callstack",
-		mcp.WithDescription("View a specific callstack with resolved function names and source locations. Useful for understanding execution flow."),
-		mcp.WithString("file_path",
-			mcp.Required(),
-			mcp.Description("Path to the loaded .sleepy profile file"),
-		),
-		mcp.WithNumber("callstack_index",
-			mcp.Required(),
-			mcp.Description("Index of the callstack to view (1-based)"),
-		),
-	)
-
-	s.AddTool(viewCallstackTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		filePath, err := request.RequireString("file_path")
-		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
-		}
-
-		csIdx, err := request.RequireFloat("callstack_index")
-		if err != nil {
-			return mcp.NewToolResultError(err.Error()), nil
-		}
-
-		profile, ok := profileCache[filePath]
-		if !ok {
-			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
-		}
-
-		index := int(csIdx) - 1
-
-		if index < 0 || index >= len(profile.Callstacks) {
-			return mcp.NewToolResultError(fmt.Sprintf("Invalid callstack index. Valid range: 1-%d", len(profile.Callstacks))), nil
-		}
-
-		cs := profile.Callstacks[index]
-		duration := cs.GetDuration()
-		frames := profile.ResolveCallstack(&cs)
-
-		var sb strings.Builder
-		sb.WriteString(fmt.Sprintf("📞 CALLSTACK #%d\n", int(csIdx)))
-		sb.WriteString("═══════════════════════════════════════════════════\n\n")
-		sb.WriteString(fmt.Sprintf("Duration: %.6f seconds\n", duration))
-		sb.WriteString(fmt.Sprintf("Stack Depth: %d frames\n\n", len(frames)))
-
-		sb.WriteString("Call Stack (bottom to top):\n\n")
-
-		for i, frame := range frames {
-			sb.WriteString(fmt.Sprintf("%d. ", i))
-
-			if frame.Module != "" && frame.Module != "?" {
-				sb.WriteString(fmt.Sprintf("%s!%s\n", frame.Module, frame.Function))
-			} else {
-				sb.WriteString(fmt.Sprintf("%s\n", frame.Function))
-			}
-
-			if frame.SourceFile != "" && frame.SourceFile != "[unknown]" {
-				sb.WriteString(fmt.Sprintf("   %s:%d\n", frame.SourceFile, frame.LineNumber))
-			}
-
-			sb.WriteString(fmt.Sprintf("   [0x%X]\n\n", frame.Address))
-		}
-
-		return mcp.NewToolResultText(sb.String()), nil
-	})
-
-	// Start the server
-	if err := server.ServeStdio(s); err != nil {
-		log.Fatalf("Server error: %v", err)
-	}
-}
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"verysleepy-mcp/internal/analyzer"
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// Profile cache, shared by all tool handlers
+var profileCache *profileStore
+
+// Formats accepted by the export_profile tool
+const (
+	exportFormatFolded      = "folded"
+	exportFormatPprof       = "pprof"
+	exportFormatCallgrind   = "callgrind"
+	exportFormatSpeedscope  = "speedscope"
+	exportFormatChromeTrace = "chrome_trace"
+	exportFormatSleepy      = "sleepy"
+)
+
+func main() {
+	maxProfiles := flag.Int("max-profiles", defaultMaxProfiles, "Maximum number of loaded profiles to keep (0 = unlimited)")
+	maxMemoryMB := flag.Int("max-memory-mb", defaultMaxMemoryMB, "Approximate memory budget for loaded profiles in MB (0 = unlimited)")
+	autoReload := flag.Bool("auto-reload", true, "Reload profiles whose file changed on disk when they are next used (false = only warn)")
+	flag.Parse()
+
+	profileCache = newProfileStore(*maxProfiles, int64(*maxMemoryMB)*1024*1024, *autoReload)
+
+	// Create MCP server
+	s := server.NewMCPServer(
+		"verysleepy-profiler",
+		"1.0.0",
+		server.WithLogging(),
+		server.WithToolHandlerMiddleware(validateOutputFormat),
+	)
+
+	// Tool 1: Load Profile
+	loadProfileTool := mcp.NewTool("load_profile",
+		mcp.WithDescription("Load a CPU profile for analysis: a Very Sleepy .sleepy file, a Go pprof profile (profile.proto, gzipped or raw), Linux `perf script` text output or a Valgrind callgrind.out file. The format is detected from the file contents."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Absolute path to the profile file"),
+		),
+		mcp.WithString("alias",
+			mcp.Description("Optional name to refer to the profile by, e.g. 'baseline' or 'after-fix'"),
+		),
+		withOutput[loadProfileResult](),
+	)
+
+	s.AddTool(loadProfileTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		alias := request.GetString("alias", "")
+		if err := profileCache.CheckAlias(alias, filePath); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		profile, format, state, err := loadProfileFile(filePath, fileState{})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to load profile: %v", err)), nil
+		}
+
+		handle, evicted, err := profileCache.Put(filePath, profile, state, alias)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return respond(request, nil, loadProfileResult{
+			Profile:    profileCache.Ref(handle),
+			Format:     format,
+			Stats:      profile.Stats,
+			Callstacks: len(profile.Callstacks),
+			Symbols:    len(profile.Symbols),
+			Threads:    len(profile.Threads),
+			Evicted:    orEmpty(evicted),
+		})
+	})
+
+	// Tool 2: Find Hotspots
+	findHotspotsTool := mcp.NewTool("find_hotspots",
+		mcp.WithDescription("Find the top CPU hotspots (functions consuming the most time) in the profile. This is the most important tool for identifying performance bottlenecks."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("Number of top hotspots to return (default: 10)"),
+		),
+		mcp.WithString("sort_by",
+			mcp.Description("Rank by 'self' time (spent in the function itself) or 'inclusive' time (including callees). Default: self"),
+			mcp.Enum(analyzer.SortBySelf, analyzer.SortByInclusive),
+		),
+		withFilterParams(),
+		withOutput[hotspotsResult](),
+	)
+
+	s.AddTool(findHotspotsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		topN := 10
+		if n := request.GetFloat("top_n", 10.0); n != 10.0 {
+			topN = int(n)
+		}
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		sortBy := request.GetString("sort_by", analyzer.SortBySelf)
+		if sortBy != analyzer.SortBySelf && sortBy != analyzer.SortByInclusive {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid sort_by %q. Use 'self' or 'inclusive'", sortBy)), nil
+		}
+
+		return respond(request, notices, hotspotsResult{
+			Profile:  profileCache.Ref(filePath),
+			SortBy:   sortBy,
+			Hotspots: orEmpty(analyzer.FindHotspots(profile, topN, sortBy)),
+		})
+	})
+
+	// Tool 3: Find Bottom Functions
+	findBottomFunctionsTool := mcp.NewTool("find_bottom_functions",
+		mcp.WithDescription("Find leaf functions (functions at the bottom of callstacks - where actual CPU work happens). These are often the real performance bottlenecks to optimize."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("Number of top functions to return (default: 10)"),
+		),
+		withFilterParams(),
+		withOutput[bottomFunctionsResult](),
+	)
+
+	s.AddTool(findBottomFunctionsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		topN := 10
+		if n := request.GetFloat("top_n", 10.0); n != 10.0 {
+			topN = int(n)
+		}
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return respond(request, notices, bottomFunctionsResult{
+			Profile:   profileCache.Ref(filePath),
+			Functions: orEmpty(analyzer.FindBottomFunctions(profile, topN)),
+		})
+	})
+
+	// Tool 4: Analyze Modules
+	analyzeModulesTool := mcp.NewTool("analyze_modules",
+		mcp.WithDescription("Analyze time spent in each module/library. Useful for identifying which components or libraries are consuming resources."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		withFilterParams(),
+		withOutput[modulesResult](),
+	)
+
+	s.AddTool(analyzeModulesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		moduleHotspots := analyzer.FindModuleHotspots(profile)
+
+		totalTime := 0.0
+		for _, time := range moduleHotspots {
+			totalTime += time
+		}
+
+		modules := make([]moduleTime, 0, len(moduleHotspots))
+		for module, time := range moduleHotspots {
+			pct := 0.0
+			if totalTime > 0 {
+				pct = (time / totalTime) * 100.0
+			}
+			modules = append(modules, moduleTime{
+				Module:     module,
+				Time:       time,
+				Percentage: pct,
+			})
+		}
+
+		// Sort by time descending
+		for i := 0; i < len(modules); i++ {
+			for j := i + 1; j < len(modules); j++ {
+				if modules[j].Time > modules[i].Time {
+					modules[i], modules[j] = modules[j], modules[i]
+				}
+			}
+		}
+
+		return respond(request, notices, modulesResult{
+			Profile:   profileCache.Ref(filePath),
+			TotalTime: totalTime,
+			Modules:   modules,
+		})
+	})
+
+	// Tool 5: Detect Performance Issues
+	detectIssuesTool := mcp.NewTool("detect_performance_issues",
+		mcp.WithDescription("Automatically detect potential performance issues using heuristics. This is a great starting point for performance analysis."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		withFilterParams(),
+		withOutput[issuesResult](),
+	)
+
+	s.AddTool(detectIssuesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		issues := analyzer.DetectPerformanceIssues(profile)
+
+		return respond(request, notices, newIssuesResult(profileCache.Ref(filePath), issues))
+	})
+
+	// Tool 6: Get Statistics
+	getStatisticsTool := mcp.NewTool("get_statistics",
+		mcp.WithDescription("Get comprehensive statistics about the profile including total time, callstack depths, unique functions/modules, etc."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		withFilterParams(),
+		withOutput[statisticsResult](),
+	)
+
+	s.AddTool(getStatisticsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return respond(request, notices, statisticsResult{
+			Profile:    profileCache.Ref(filePath),
+			Statistics: analyzer.ComputeStatistics(profile),
+		})
+	})
+
+	// Tool 7: View Callstack
+	viewCallstackTool := mcp.NewTool("view_callstack",
+		mcp.WithDescription("View a specific callstack with resolved function names and source locations. Useful for understanding execution flow."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		mcp.WithNumber("callstack_index",
+			mcp.Required(),
+			mcp.Description("Index of the callstack to view (1-based), counted among the callstacks left by the filters"),
+		),
+		withFilterParams(),
+		withOutput[callstackResult](),
+	)
+
+	s.AddTool(viewCallstackTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		csIdx, err := request.RequireFloat("callstack_index")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		index := int(csIdx) - 1
+
+		if index < 0 || index >= len(profile.Callstacks) {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid callstack index. Valid range: 1-%d", len(profile.Callstacks))), nil
+		}
+
+		cs := profile.Callstacks[index]
+		frames := profile.ResolveCallstack(&cs)
+
+		return respond(request, notices, callstackResult{
+			Profile:  profileCache.Ref(filePath),
+			Index:    index + 1,
+			Duration: cs.GetDuration(),
+			Depth:    len(frames),
+			Frames:   orEmpty(frames),
+		})
+	})
+
+	// Tool 8: Function Details
+	functionDetailsTool := mcp.NewTool("function_details",
+		mcp.WithDescription("Show who calls a function and what it calls (caller/callee \"butterfly\" view), with the time flowing along each edge. Useful for understanding why a hot function is hot."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		mcp.WithString("function",
+			mcp.Required(),
+			mcp.Description("Function signature (Module!Function) or a regular expression matched against Module!Function"),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("Maximum number of callers and callees to show per function (default: 10)"),
+		),
+		mcp.WithNumber("max_matches",
+			mcp.Description("Maximum number of matching functions to show (default: 5)"),
+		),
+		withFilterParams(),
+		withOutput[functionDetailsResult](),
+	)
+
+	s.AddTool(functionDetailsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		pattern, err := request.RequireString("function")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		topN := int(request.GetFloat("top_n", 10.0))
+		maxMatches := int(request.GetFloat("max_matches", 5.0))
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		details, err := analyzer.GetFunctionDetails(profile, pattern)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return respond(request, notices, newFunctionDetailsResult(profileCache.Ref(filePath), pattern, details, topN, maxMatches))
+	})
+
+	// Tool 9: Call Tree
+	callTreeTool := mcp.NewTool("call_tree",
+		mcp.WithDescription("Show the top-down call tree (entry points first, expanding into callees) with inclusive and self time per node. Small branches are pruned to keep large profiles readable."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		mcp.WithNumber("min_percent",
+			mcp.Description("Hide nodes below this percentage of total time (default: 1.0)"),
+		),
+		mcp.WithNumber("max_depth",
+			mcp.Description("Maximum tree depth to show (default: 0 = unlimited)"),
+		),
+		mcp.WithNumber("max_children",
+			mcp.Description("Maximum number of children shown per node (default: 10)"),
+		),
+		withFilterParams(),
+		withOutput[callTreeResult](),
+	)
+
+	s.AddTool(callTreeTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		opts := analyzer.CallTreeOptions{
+			MinPercent:  request.GetFloat("min_percent", 1.0),
+			MaxDepth:    int(request.GetFloat("max_depth", 0.0)),
+			MaxChildren: int(request.GetFloat("max_children", 10.0)),
+		}
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		tree := analyzer.AnalyzeCallChains(profile, 0)
+
+		return respond(request, notices, newCallTreeResult(profileCache.Ref(filePath), callTreeTopDown, tree, opts))
+	})
+
+	// Tool 10: Bottom-Up Tree
+	bottomUpTreeTool := mcp.NewTool("bottom_up_tree",
+		mcp.WithDescription("Show the inverted (bottom-up) call tree: leaf functions where CPU time is spent at the root, expanding to the callers that led to them. Small branches are pruned to keep large profiles readable."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		mcp.WithNumber("min_percent",
+			mcp.Description("Hide nodes below this percentage of total time (default: 1.0)"),
+		),
+		mcp.WithNumber("max_depth",
+			mcp.Description("Maximum tree depth to show (default: 0 = unlimited)"),
+		),
+		mcp.WithNumber("max_children",
+			mcp.Description("Maximum number of children shown per node (default: 10)"),
+		),
+		withFilterParams(),
+		withOutput[callTreeResult](),
+	)
+
+	s.AddTool(bottomUpTreeTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		opts := analyzer.CallTreeOptions{
+			MinPercent:  request.GetFloat("min_percent", 1.0),
+			MaxDepth:    int(request.GetFloat("max_depth", 0.0)),
+			MaxChildren: int(request.GetFloat("max_children", 10.0)),
+		}
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		tree := analyzer.AnalyzeBottomUpCallChains(profile, 0)
+
+		return respond(request, notices, newCallTreeResult(profileCache.Ref(filePath), callTreeBottomUp, tree, opts))
+	})
+
+	// Tool 11: Callstack Patterns
+	callstackPatternsTool := mcp.NewTool("callstack_patterns",
+		mcp.WithDescription("Find recurring callstack patterns: clusters of leaf frames (anchor 'leaf') or common entry paths (anchor 'root'), ranked by total time."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		mcp.WithNumber("depth",
+			mcp.Description("Number of frames per pattern (default: 3)"),
+		),
+		mcp.WithString("anchor",
+			mcp.Description("Take frames from the 'leaf' or 'root' end of each callstack (default: leaf)"),
+			mcp.Enum(analyzer.AnchorLeaf, analyzer.AnchorRoot),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("Number of patterns to return (default: 10)"),
+		),
+		mcp.WithNumber("min_occurrences",
+			mcp.Description("Only report patterns seen in at least this many callstacks (default: 1)"),
+		),
+		mcp.WithNumber("min_percent",
+			mcp.Description("Only report patterns accounting for at least this percentage of total time (default: 0)"),
+		),
+		withFilterParams(),
+		withOutput[patternsResult](),
+	)
+
+	s.AddTool(callstackPatternsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		opts := analyzer.PatternOptions{
+			Depth:          int(request.GetFloat("depth", 3.0)),
+			Anchor:         request.GetString("anchor", analyzer.AnchorLeaf),
+			TopN:           int(request.GetFloat("top_n", 10.0)),
+			MinOccurrences: int(request.GetFloat("min_occurrences", 1.0)),
+			MinPercent:     request.GetFloat("min_percent", 0.0),
+		}
+		if opts.Anchor != analyzer.AnchorLeaf && opts.Anchor != analyzer.AnchorRoot {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid anchor %q. Use 'leaf' or 'root'", opts.Anchor)), nil
+		}
+		if opts.Depth < 1 {
+			return mcp.NewToolResultError("depth must be at least 1"), nil
+		}
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return respond(request, notices, patternsResult{
+			Profile:  profileCache.Ref(filePath),
+			Anchor:   opts.Anchor,
+			Depth:    opts.Depth,
+			Patterns: orEmpty(analyzer.FindCommonCallstackPatterns(profile, opts)),
+		})
+	})
+
+	// Tool 12: Analyze Threads
+	analyzeThreadsTool := mcp.NewTool("analyze_threads",
+		mcp.WithDescription("Break the profile down per thread: each thread's total time, share of the profile, and the functions with the most self time on it. Useful for finding which thread is saturated."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("Number of top self-time functions to show per thread (default: 5)"),
+		),
+		withFilterParams(),
+		withOutput[threadsResult](),
+	)
+
+	s.AddTool(analyzeThreadsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		topN := int(request.GetFloat("top_n", 5.0))
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		threads := analyzer.AnalyzeThreads(profile, topN)
+		for i := range threads {
+			threads[i].TopFunctions = orEmpty(threads[i].TopFunctions)
+		}
+
+		return respond(request, notices, threadsResult{
+			Profile: profileCache.Ref(filePath),
+			Threads: orEmpty(threads),
+		})
+	})
+
+	// Tool 13: Diff Profiles
+	diffProfilesTool := mcp.NewTool("diff_profiles",
+		mcp.WithDescription("Compare two loaded profiles (e.g. before and after an optimization). Reports per-function and per-module changes in self and inclusive time, normalized to each profile's total time, and flags functions that are new or gone."),
+		mcp.WithString("base_file_path",
+			mcp.Required(),
+			mcp.Description("Handle, alias or path of the loaded baseline profile"),
+		),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle, alias or path of the loaded profile to compare against the baseline"),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("Number of functions and modules to show (default: 15)"),
+		),
+		mcp.WithString("sort_by",
+			mcp.Description("Compare 'self' or 'inclusive' time (default: self)"),
+			mcp.Enum(analyzer.SortBySelf, analyzer.SortByInclusive),
+		),
+		mcp.WithString("rank_by",
+			mcp.Description("Rank by 'absolute' change in percentage points or 'relative' change versus the baseline (default: absolute)"),
+			mcp.Enum(analyzer.RankByAbsolute, analyzer.RankByRelative),
+		),
+		withFilterParams(),
+		withOutput[diffResult](),
+	)
+
+	s.AddTool(diffProfilesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		baseFilePath, err := request.RequireString("base_file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		topN := int(request.GetFloat("top_n", 15.0))
+
+		sortBy := request.GetString("sort_by", analyzer.SortBySelf)
+		if sortBy != analyzer.SortBySelf && sortBy != analyzer.SortByInclusive {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid sort_by %q. Use 'self' or 'inclusive'", sortBy)), nil
+		}
+		rankBy := request.GetString("rank_by", analyzer.RankByAbsolute)
+		if rankBy != analyzer.RankByAbsolute && rankBy != analyzer.RankByRelative {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid rank_by %q. Use 'absolute' or 'relative'", rankBy)), nil
+		}
+
+		base, baseNotices, ok := profileCache.Get(baseFilePath)
+		if !ok {
+			return mcp.NewToolResultError("Baseline profile not loaded. Use load_profile tool first"), nil
+		}
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		base, err = applyFilters(base, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		diff := analyzer.DiffProfiles(base, profile)
+		analyzer.SortFunctionDeltas(diff.Functions, sortBy, rankBy)
+		analyzer.SortModuleDeltas(diff.Modules, sortBy, rankBy)
+
+		return respond(request, append(baseNotices, notices...),
+			newDiffResult(profileCache.Ref(baseFilePath), profileCache.Ref(filePath), diff, sortBy, rankBy, topN))
+	})
+
+	// Tool 14: Export Profile
+	exportProfileTool := mcp.NewTool("export_profile",
+		mcp.WithDescription("Export a loaded profile to another format for external tools. 'folded' writes collapsed stacks (root;caller;leaf weight) for flamegraph.pl, inferno and speedscope. 'pprof' writes a gzipped profile.proto for go tool pprof (web UI, -diff_base) and pprof-compatible visualizers. 'callgrind' writes a callgrind.out file with per-line and caller→callee costs for KCachegrind/QCachegrind. 'speedscope' writes speedscope JSON with one sampled profile per thread. 'chrome_trace' writes Chrome Trace Event JSON for chrome://tracing and ui.perfetto.dev, with each thread's stacks laid out as synthetic slices proportional to their time. 'sleepy' writes a .sleepy archive that load_profile and the Very Sleepy GUI can open, e.g. to save a filtered or converted profile."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		mcp.WithString("output_path",
+			mcp.Required(),
+			mcp.Description("Path of the file to write"),
+		),
+		mcp.WithString("format",
+			mcp.Required(),
+			mcp.Description("Export format"),
+			mcp.Enum(exportFormatFolded, exportFormatPprof, exportFormatCallgrind, exportFormatSpeedscope, exportFormatChromeTrace, exportFormatSleepy),
+		),
+		mcp.WithString("weight",
+			mcp.Description("Stack weight for folded output: 'seconds' (duration) or 'samples' (default: seconds)"),
+			mcp.Enum(sleepy.WeightSeconds, sleepy.WeightSamples),
+		),
+		withFilterParams(),
+		withOutput[exportResult](),
+	)
+
+	s.AddTool(exportProfileTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		outputPath, err := request.RequireString("output_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		format, err := request.RequireString("format")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		weight := request.GetString("weight", sleepy.WeightSeconds)
+		if weight != sleepy.WeightSeconds && weight != sleepy.WeightSamples {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid weight %q. Use 'seconds' or 'samples'", weight)), nil
+		}
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		var write func(w io.Writer) error
+		switch format {
+		case exportFormatFolded:
+			write = func(w io.Writer) error { return sleepy.WriteFolded(w, profile, weight) }
+		case exportFormatPprof:
+			write = func(w io.Writer) error { return sleepy.WritePprofProfile(w, profile) }
+		case exportFormatCallgrind:
+			write = func(w io.Writer) error { return sleepy.WriteCallgrind(w, profile) }
+		case exportFormatSpeedscope:
+			write = func(w io.Writer) error { return sleepy.WriteSpeedscope(w, profile) }
+		case exportFormatChromeTrace:
+			write = func(w io.Writer) error { return sleepy.WriteChromeTrace(w, profile) }
+		case exportFormatSleepy:
+			write = func(w io.Writer) error { return sleepy.WriteSleepyProfile(w, profile) }
+		default:
+			return mcp.NewToolResultError(fmt.Sprintf("Unsupported export format %q", format)), nil
+		}
+
+		size, err := writeExportFile(outputPath, write)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to export profile: %v", err)), nil
+		}
+
+		return respond(request, notices, exportResult{
+			Profile:    profileCache.Ref(filePath),
+			Format:     format,
+			OutputPath: outputPath,
+			Size:       size,
+			Callstacks: len(profile.Callstacks),
+		})
+	})
+
+	// Tool 15: Flame Graph
+	flameGraphTool := mcp.NewTool("flame_graph",
+		mcp.WithDescription("Render the profile as a self-contained interactive flame graph SVG (hover for details, click to zoom, regex search) and write it to a file. Open the SVG in any web browser."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		mcp.WithString("output_path",
+			mcp.Required(),
+			mcp.Description("Path of the .svg file to write"),
+		),
+		mcp.WithString("title",
+			mcp.Description("Title shown above the graph (default: Flame Graph)"),
+		),
+		mcp.WithBoolean("icicle",
+			mcp.Description("Draw entry points at the top (icicle graph) instead of the bottom (default: false)"),
+		),
+		mcp.WithNumber("width",
+			mcp.Description("Image width in pixels (default: 1200)"),
+		),
+		withFilterParams(),
+		withOutput[flameGraphResult](),
+	)
+
+	s.AddTool(flameGraphTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		outputPath, err := request.RequireString("output_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		opts := analyzer.FlameGraphOptions{
+			Title:    request.GetString("title", "Flame Graph"),
+			Subtitle: profileCache.Label(filePath),
+			Width:    int(request.GetFloat("width", 1200.0)),
+			Icicle:   request.GetBool("icicle", false),
+		}
+
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		size, err := writeExportFile(outputPath, func(w io.Writer) error {
+			return analyzer.WriteProfileFlameGraph(w, profile, opts)
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to write flame graph: %v", err)), nil
+		}
+
+		return respond(request, notices, flameGraphResult{
+			Profile:    profileCache.Ref(filePath),
+			OutputPath: outputPath,
+			Size:       size,
+			Callstacks: len(profile.Callstacks),
+		})
+	})
+
+	// Tool 16: Differential Flame Graph
+	diffFlameGraphTool := mcp.NewTool("diff_flame_graph",
+		mcp.WithDescription("Render a red/blue differential flame graph SVG comparing two loaded profiles. Frames are laid out from one profile and colored by how much their share of total time changed: red grew, blue shrank. Shows regressions spread across many small frames."),
+		mcp.WithString("base_file_path",
+			mcp.Required(),
+			mcp.Description("Handle, alias or path of the loaded baseline profile"),
+		),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle, alias or path of the loaded profile to compare against the baseline"),
+		),
+		mcp.WithString("output_path",
+			mcp.Required(),
+			mcp.Description("Path of the .svg file to write"),
+		),
+		mcp.WithString("title",
+			mcp.Description("Title shown above the graph (default: Differential Flame Graph)"),
+		),
+		mcp.WithString("diff_by",
+			mcp.Description("Color by change in 'self' or 'inclusive' time (default: self)"),
+			mcp.Enum(analyzer.SortBySelf, analyzer.SortByInclusive),
+		),
+		mcp.WithBoolean("layout_from_base",
+			mcp.Description("Lay out frames from the baseline instead of the current profile, to show code that was removed (default: false)"),
+		),
+		mcp.WithBoolean("icicle",
+			mcp.Description("Draw entry points at the top (icicle graph) instead of the bottom (default: false)"),
+		),
+		mcp.WithNumber("width",
+			mcp.Description("Image width in pixels (default: 1200)"),
+		),
+		withFilterParams(),
+		withOutput[diffFlameGraphResult](),
+	)
+
+	s.AddTool(diffFlameGraphTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		baseFilePath, err := request.RequireString("base_file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		outputPath, err := request.RequireString("output_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		opts := analyzer.DiffFlameGraphOptions{
+			FlameGraphOptions: analyzer.FlameGraphOptions{
+				Title:    request.GetString("title", "Differential Flame Graph"),
+				Subtitle: fmt.Sprintf("%s → %s", profileCache.Label(baseFilePath), profileCache.Label(filePath)),
+				Width:    int(request.GetFloat("width", 1200.0)),
+				Icicle:   request.GetBool("icicle", false),
+			},
+			DiffBy:         request.GetString("diff_by", analyzer.SortBySelf),
+			LayoutFromBase: request.GetBool("layout_from_base", false),
+		}
+		if opts.DiffBy != analyzer.SortBySelf && opts.DiffBy != analyzer.SortByInclusive {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid diff_by %q. Use 'self' or 'inclusive'", opts.DiffBy)), nil
+		}
+
+		base, baseNotices, ok := profileCache.Get(baseFilePath)
+		if !ok {
+			return mcp.NewToolResultError("Baseline profile not loaded. Use load_profile tool first"), nil
+		}
+		profile, notices, ok := profileCache.Get(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded. Use load_profile tool first"), nil
+		}
+
+		base, err = applyFilters(base, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		profile, err = applyFilters(profile, request)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		size, err := writeExportFile(outputPath, func(w io.Writer) error {
+			return analyzer.WriteDiffFlameGraph(w, base, profile, opts)
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to write flame graph: %v", err)), nil
+		}
+
+		return respond(request, append(baseNotices, notices...), diffFlameGraphResult{
+			Base:       profileCache.Ref(baseFilePath),
+			Current:    profileCache.Ref(filePath),
+			DiffBy:     opts.DiffBy,
+			OutputPath: outputPath,
+			Size:       size,
+		})
+	})
+
+	// Tool 17: Merge Profiles
+	mergeProfilesTool := mcp.NewTool("merge_profiles",
+		mcp.WithDescription("Merge several loaded captures of the same scenario into one aggregate profile to reduce noise. Symbols are unified by Module!Function and source line (not address, which differs between runs), identical stacks are summed, and threads are matched by name. The merged profile is written as a .sleepy file and loaded under that path, ready for the other tools."),
+		mcp.WithArray("file_paths",
+			mcp.Required(),
+			mcp.Description("Handles, aliases or paths of the loaded profiles to merge (at least two)"),
+			mcp.WithStringItems(),
+		),
+		mcp.WithString("output_path",
+			mcp.Required(),
+			mcp.Description("Path of the .sleepy file to write the merged profile to; must not be the file of a profile being merged"),
+		),
+		mcp.WithString("alias",
+			mcp.Description("Optional name to refer to the merged profile by"),
+		),
+		withFilterParams(),
+		withOutput[mergeResult](),
+	)
+
+	s.AddTool(mergeProfilesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePaths, err := request.RequireStringSlice("file_paths")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if len(filePaths) < 2 {
+			return mcp.NewToolResultError("At least two profiles are needed to merge"), nil
+		}
+
+		outputPath, err := request.RequireString("output_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		alias := request.GetString("alias", "")
+		if err := profileCache.CheckAlias(alias, outputPath); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		profiles := make([]*sleepy.ProfileData, len(filePaths))
+		names := make([]string, len(filePaths))
+		var notices []toolNotice
+		for i, filePath := range filePaths {
+			profile, profileNotices, ok := profileCache.Get(filePath)
+			if !ok {
+				return mcp.NewToolResultError(fmt.Sprintf("Profile %s not loaded. Use load_profile tool first", filePath)), nil
+			}
+			notices = append(notices, profileNotices...)
+
+			// Writing over a source would destroy the capture and replace it in the cache
+			ref := profileCache.Ref(filePath)
+			if samePath(ref.Path, outputPath) {
+				return mcp.NewToolResultError(fmt.Sprintf("output_path %s is the file of %s, one of the profiles being merged. Choose another path", outputPath, ref.Label())), nil
+			}
+			names[i] = ref.Label()
+			profiles[i], err = applyFilters(profile, request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+		}
+
+		merged, err := analyzer.MergeProfiles(names, profiles)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to merge profiles: %v", err)), nil
+		}
+
+		size, err := writeExportFile(outputPath, func(w io.Writer) error {
+			return sleepy.WriteSleepyProfile(w, merged.Profile)
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to write merged profile: %v", err)), nil
+		}
+
+		state, err := readFileState(outputPath, fileState{})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to read back merged profile: %v", err)), nil
+		}
+		handle, evicted, err := profileCache.Put(outputPath, merged.Profile, state, alias)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		return respond(request, notices, mergeResult{
+			Profile:          profileCache.Ref(handle),
+			OutputPath:       outputPath,
+			Size:             size,
+			Callstacks:       len(merged.Profile.Callstacks),
+			CommonCallstacks: merged.CommonStackCount(),
+			Symbols:          len(merged.Profile.Symbols),
+			Threads:          len(merged.Profile.Threads),
+			Sources:          merged.Sources,
+			Evicted:          orEmpty(evicted),
+		})
+	})
+
+	// Tool 18: List Profiles
+	listProfilesTool := mcp.NewTool("list_profiles",
+		mcp.WithDescription("List the loaded profiles with their approximate memory use and when they were loaded and last used. Least recently used profiles are unloaded automatically when the cache is full."),
+		withOutput[listProfilesResult](),
+	)
+
+	s.AddTool(listProfilesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		entries := profileCache.List()
+		count, size := profileCache.Usage()
+
+		result := listProfilesResult{
+			Count:        count,
+			MaxProfiles:  profileCache.maxEntries,
+			MemoryBytes:  size,
+			MemoryBudget: profileCache.maxBytes,
+			Profiles:     make([]listedProfile, 0, len(entries)),
+		}
+		for _, entry := range entries {
+			result.Profiles = append(result.Profiles, listedProfile{
+				Profile:     entry.Ref(),
+				MemoryBytes: entry.Size,
+				Callstacks:  len(entry.Profile.Callstacks),
+				Symbols:     len(entry.Profile.Symbols),
+				File:        entry.File,
+				LoadedAt:    entry.LoadedAt,
+				LastUsed:    entry.LastAccess,
+			})
+		}
+
+		return respond(request, nil, result)
+	})
+
+	// Tool 19: Unload Profile
+	unloadProfileTool := mcp.NewTool("unload_profile",
+		mcp.WithDescription("Unload a profile to free its memory"),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		withOutput[unloadResult](),
+	)
+
+	s.AddTool(unloadProfileTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		ref, ok := profileCache.Remove(filePath)
+		if !ok {
+			return mcp.NewToolResultError("Profile not loaded"), nil
+		}
+
+		count, size := profileCache.Usage()
+		return respond(request, nil, unloadResult{Profile: ref, Remaining: count, MemoryBytes: size})
+	})
+
+	// Tool 20: Reload Profile
+	reloadProfileTool := mcp.NewTool("reload_profile",
+		mcp.WithDescription("Reload a loaded profile from its file, e.g. after capturing again to the same path. Other tools already reload changed files automatically unless the server runs with -auto-reload=false."),
+		mcp.WithString("file_path",
+			mcp.Required(),
+			mcp.Description("Handle (e.g. p1), alias or path of the loaded profile"),
+		),
+		withOutput[reloadResult](),
+	)
+
+	s.AddTool(reloadProfileTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		filePath, err := request.RequireString("file_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		profile, previous, current, evicted, err := profileCache.Reload(filePath)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to reload profile: %v", err)), nil
+		}
+
+		return respond(request, nil, reloadResult{
+			Profile:    profileCache.Ref(filePath),
+			Changed:    current.Hash != previous.Hash,
+			Previous:   previous,
+			Current:    current,
+			Stats:      profile.Stats,
+			Callstacks: len(profile.Callstacks),
+			Symbols:    len(profile.Symbols),
+			Evicted:    orEmpty(evicted),
+		})
+	})
+
+	// Start the server
+	if err := server.ServeStdio(s); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
+
+// withFilterParams adds the focus/ignore/hide/prune_from/thread parameters shared by all analysis tools
+func withFilterParams() mcp.ToolOption {
+	return func(tool *mcp.Tool) {
+		for _, opt := range []mcp.ToolOption{
+			mcp.WithString("focus",
+				mcp.Description("Only analyze callstacks containing a frame matching this regex (matched against Module!Function)"),
+			),
+			mcp.WithString("ignore",
+				mcp.Description("Drop callstacks containing a frame matching this regex"),
+			),
+			mcp.WithString("hide",
+				mcp.Description("Remove frames matching this regex from callstacks, keeping their time"),
+			),
+			mcp.WithString("prune_from",
+				mcp.Description("Cut callstacks at the outermost frame matching this regex, dropping everything it calls"),
+			),
+			mcp.WithString("thread",
+				mcp.Description("Only analyze time from this thread: a numeric thread ID or a regex matched against thread names"),
+			),
+		} {
+			opt(tool)
+		}
+	}
+}
+
+// applyFilters narrows a profile according to the request's filter parameters
+func applyFilters(profile *sleepy.ProfileData, request mcp.CallToolRequest) (*sleepy.ProfileData, error) {
+	return analyzer.FilterProfile(profile, analyzer.FilterOptions{
+		Focus:     request.GetString("focus", ""),
+		Ignore:    request.GetString("ignore", ""),
+		Hide:      request.GetString("hide", ""),
+		PruneFrom: request.GetString("prune_from", ""),
+		Thread:    request.GetString("thread", ""),
+	})
+}
+
+// deltaStatusLabel marks functions and modules that only exist in one of two diffed profiles
+func deltaStatusLabel(status string) string {
+	switch status {
+	case analyzer.DeltaNew:
+		return " [NEW]"
+	case analyzer.DeltaGone:
+		return " [GONE]"
+	}
+	return ""
+}
+
+// formatDelta renders a percentage point change with its relative change where defined
+func formatDelta(status string, delta, relative float64) string {
+	marker := "🔺"
+	if delta < 0 {
+		marker = "🔻"
+	} else if delta == 0 {
+		marker = "="
+	}
+
+	if status == analyzer.DeltaChanged && relative != 0 {
+		return fmt.Sprintf("%s %+.2f pp, %+.1f%%", marker, delta, relative)
+	}
+	return fmt.Sprintf("%s %+.2f pp", marker, delta)
+}
+
+// formatEvictions lists profiles the cache unloaded to make room, if any
+func formatEvictions(evicted []string) string {
+	if len(evicted) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\nUnloaded to stay within the cache limits (least recently used):\n")
+	for _, key := range evicted {
+		sb.WriteString(fmt.Sprintf("- %s\n", key))
+	}
+	return sb.String()
+}
+
+// shortHash abbreviates a hex content hash for display
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "12.3 MB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, suffix := float64(n)/unit, "KB"
+	for _, next := range []string{"MB", "GB", "TB"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
+
+// writeExportFile fills path using write, returning the number of bytes written. The data
+// goes to a temporary file in the same directory that replaces path only once write
+// succeeded, so a failed export leaves an existing file untouched.
+func writeExportFile(path string, write func(w io.Writer) error) (int64, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create output file: %w", err)
+	}
+	tmpPath := f.Name()
+
+	// Temporary files are private; give the output the mode of the file it replaces
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	cw := &countingWriter{w: f}
+	if err := write(cw); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to set output file mode: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to close output file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to replace output file: %w", err)
+	}
+	return cw.n, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Formats accepted by every tool's output_format parameter
+const (
+	outputFormatText     = "text"
+	outputFormatJSON     = "json"
+	outputFormatMarkdown = "markdown"
+)
+
+// outputSchemaVersion is bumped whenever a field of the JSON output is renamed,
+// removed or changes meaning. New fields may be added without a bump.
+const outputSchemaVersion = 1
+
+// Kinds of notices attached to a tool result
+const (
+	noticeWarning  = "warning"  // Results may be stale or incomplete
+	noticeReloaded = "reloaded" // A profile changed on disk and was reloaded
+	noticeEvicted  = "evicted"  // Profiles were unloaded to stay within the cache limits
+)
+
+// toolNotice is a message about how a result was produced, e.g. a profile that was reloaded
+type toolNotice struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+func newNotice(kind, format string, args ...any) toolNotice {
+	return toolNotice{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// toolOutput is the JSON envelope of every tool result
+type toolOutput[T any] struct {
+	SchemaVersion int          `json:"schema_version"`
+	Tool          string       `json:"tool"`
+	Notices       []toolNotice `json:"notices,omitempty"`
+	Result        T            `json:"result"`
+}
+
+// toolResult is a tool's result with its human-readable renderings
+type toolResult interface {
+	Text() string
+	Markdown() string
+}
+
+// withOutput adds the output_format parameter and declares the tool's structured
+// output as a toolOutput envelope around T
+func withOutput[T toolResult]() mcp.ToolOption {
+	return func(tool *mcp.Tool) {
+		for _, opt := range []mcp.ToolOption{
+			mcp.WithString("output_format",
+				mcp.Description("Render the result as human-readable 'text', 'markdown', or 'json' with a stable schema (default: text). Structured content is always included."),
+				mcp.Enum(outputFormatText, outputFormatJSON, outputFormatMarkdown),
+			),
+			mcp.WithOutputSchema[toolOutput[T]](),
+		} {
+			opt(tool)
+		}
+	}
+}
+
+// validateOutputFormat rejects unknown output_format values before the tool runs
+func validateOutputFormat(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		switch format := request.GetString("output_format", outputFormatText); format {
+		case outputFormatText, outputFormatJSON, outputFormatMarkdown:
+			return next(ctx, request)
+		default:
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid output_format %q. Use 'text', 'json' or 'markdown'", format)), nil
+		}
+	}
+}
+
+// respond builds a tool result in the requested output format. The structured content
+// always holds the JSON envelope; the text content is the envelope as indented JSON, or
+// the notices followed by the text or markdown rendering of result.
+func respond[T toolResult](request mcp.CallToolRequest, notices []toolNotice, result T) (*mcp.CallToolResult, error) {
+	output := toolOutput[T]{
+		SchemaVersion: outputSchemaVersion,
+		Tool:          request.Params.Name,
+		Notices:       notices,
+		Result:        result,
+	}
+
+	switch request.GetString("output_format", outputFormatText) {
+	case outputFormatJSON:
+		data, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to encode result: %v", err)), nil
+		}
+		return mcp.NewToolResultStructured(output, string(data)), nil
+	case outputFormatMarkdown:
+		return mcp.NewToolResultStructured(output, formatMarkdownNotices(notices)+result.Markdown()), nil
+	default:
+		return mcp.NewToolResultStructured(output, formatTextNotices(notices)+result.Text()), nil
+	}
+}
+
+// formatTextNotices renders notices as a block above a text result
+func formatTextNotices(notices []toolNotice) string {
+	if len(notices) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, n := range notices {
+		sb.WriteString(fmt.Sprintf("%s %s\n", noticeIcon(n.Kind), n.Message))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// formatMarkdownNotices renders each notice as a quote above a markdown result
+func formatMarkdownNotices(notices []toolNotice) string {
+	var sb strings.Builder
+	for _, n := range notices {
+		sb.WriteString(fmt.Sprintf("> %s %s\n\n", noticeIcon(n.Kind), n.Message))
+	}
+	return sb.String()
+}
+
+func noticeIcon(kind string) string {
+	switch kind {
+	case noticeReloaded:
+		return "🔄"
+	case noticeEvicted:
+		return "🗑️ "
+	}
+	return "⚠️ "
+}
+
+// orEmpty returns an empty slice for nil, so JSON output has [] rather than null
+func orEmpty[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// markdownEscape escapes characters that would break a markdown table cell or emphasis
+func markdownEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "*", `\*`, "_", `\_`, "`", "\\`", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,1130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"verysleepy-mcp/internal/analyzer"
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// Results of the tools, as serialized in the "result" field of the JSON output.
+// Each result has a text and a markdown rendering.
+
+// loadProfileResult is the result of load_profile
+type loadProfileResult struct {
+	Profile    profileRef   `json:"profile"`
+	Format     string       `json:"format"`
+	Stats      sleepy.Stats `json:"stats"`
+	Callstacks int          `json:"callstacks"`
+	Symbols    int          `json:"symbols"`
+	Threads    int          `json:"threads"`
+	Evicted    []string     `json:"evicted"` // Profiles unloaded to make room
+}
+
+func (r loadProfileResult) Text() string {
+	result := fmt.Sprintf(`Profile loaded successfully!
+
+Profile: %s
+Format: %s
+Duration: %s
+Date: %s
+Samples: %d
+Callstacks: %d
+Symbols: %d
+Threads: %d
+
+Use %q as file_path with the other tools to analyze this profile.
+`,
+		r.Profile.Label(),
+		r.Format,
+		r.Stats.Duration,
+		r.Stats.Date,
+		r.Stats.NumSamples,
+		r.Callstacks,
+		r.Symbols,
+		r.Threads,
+		r.Profile.Handle,
+	)
+	return result + formatEvictions(r.Evicted)
+}
+
+func (r loadProfileResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("## Profile loaded\n\n")
+	sb.WriteString("| | |\n|---|---|\n")
+	sb.WriteString(fmt.Sprintf("| Profile | %s |\n", markdownEscape(r.Profile.Label())))
+	sb.WriteString(fmt.Sprintf("| Format | %s |\n", r.Format))
+	sb.WriteString(fmt.Sprintf("| Duration | %s |\n", markdownEscape(r.Stats.Duration)))
+	sb.WriteString(fmt.Sprintf("| Date | %s |\n", markdownEscape(r.Stats.Date)))
+	sb.WriteString(fmt.Sprintf("| Samples | %d |\n", r.Stats.NumSamples))
+	sb.WriteString(fmt.Sprintf("| Callstacks | %d |\n", r.Callstacks))
+	sb.WriteString(fmt.Sprintf("| Symbols | %d |\n", r.Symbols))
+	sb.WriteString(fmt.Sprintf("| Threads | %d |\n\n", r.Threads))
+	sb.WriteString(fmt.Sprintf("Use `%s` as `file_path` with the other tools to analyze this profile.\n", r.Profile.Handle))
+	sb.WriteString(markdownEvictions(r.Evicted))
+	return sb.String()
+}
+
+// hotspotsResult is the result of find_hotspots
+type hotspotsResult struct {
+	Profile  profileRef         `json:"profile"`
+	SortBy   string             `json:"sort_by"`
+	Hotspots []analyzer.Hotspot `json:"hotspots"`
+}
+
+func (r hotspotsResult) Text() string {
+	var sb strings.Builder
+	if r.SortBy == analyzer.SortByInclusive {
+		sb.WriteString("🔥 TOP CPU HOTSPOTS (Sorted by Inclusive Time)\n")
+	} else {
+		sb.WriteString("🔥 TOP CPU HOTSPOTS (Sorted by Self Time)\n")
+	}
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+
+	if len(r.Hotspots) == 0 {
+		sb.WriteString("No hotspots found.\n")
+	} else {
+		for i, hs := range r.Hotspots {
+			sb.WriteString(analyzer.FormatHotspot(hs, i+1))
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+func (r hotspotsResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("## Top CPU hotspots (by %s time)\n\n", r.SortBy))
+	if len(r.Hotspots) == 0 {
+		sb.WriteString("No hotspots found.\n")
+		return sb.String()
+	}
+	sb.WriteString("| # | Function | Self (s) | Self % | Inclusive (s) | Inclusive % | Samples | Source |\n")
+	sb.WriteString("|--:|---|--:|--:|--:|--:|--:|---|\n")
+	for i, hs := range r.Hotspots {
+		sb.WriteString(fmt.Sprintf("| %d | %s | %.6f | %.2f | %.6f | %.2f | %d | %s |\n",
+			i+1, markdownFunction(hs.Module, hs.Function), hs.SelfTime, hs.SelfPercentage,
+			hs.InclusiveTime, hs.InclusivePercentage, hs.InclusiveSamples, markdownSource(hs.SourceFile, hs.LineNumber)))
+	}
+	return sb.String()
+}
+
+// bottomFunctionsResult is the result of find_bottom_functions
+type bottomFunctionsResult struct {
+	Profile   profileRef         `json:"profile"`
+	Functions []analyzer.Hotspot `json:"functions"`
+}
+
+func (r bottomFunctionsResult) Text() string {
+	var sb strings.Builder
+	sb.WriteString("🎯 LEAF FUNCTIONS (Where Actual CPU Work Happens)\n")
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+	sb.WriteString("These are the functions at the bottom of callstacks - the actual CPU-intensive operations.\n")
+	sb.WriteString("Optimizing these will have direct performance impact.\n\n")
+
+	if len(r.Functions) == 0 {
+		sb.WriteString("No leaf functions found.\n")
+	} else {
+		for i, hs := range r.Functions {
+			sb.WriteString(fmt.Sprintf("#%d: %s!%s\n", i+1, hs.Module, hs.Function))
+			sb.WriteString(fmt.Sprintf("    Self Time: %.6f seconds (%.2f%%)\n", hs.SelfTime, hs.SelfPercentage))
+			sb.WriteString(fmt.Sprintf("    Samples: %d\n", hs.SelfSamples))
+			if hs.SourceFile != "" && hs.SourceFile != "[unknown]" {
+				sb.WriteString(fmt.Sprintf("    Source: %s:%d\n", hs.SourceFile, hs.LineNumber))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+func (r bottomFunctionsResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("## Leaf functions (where CPU work happens)\n\n")
+	if len(r.Functions) == 0 {
+		sb.WriteString("No leaf functions found.\n")
+		return sb.String()
+	}
+	sb.WriteString("| # | Function | Self (s) | Self % | Samples | Source |\n")
+	sb.WriteString("|--:|---|--:|--:|--:|---|\n")
+	for i, hs := range r.Functions {
+		sb.WriteString(fmt.Sprintf("| %d | %s | %.6f | %.2f | %d | %s |\n",
+			i+1, markdownFunction(hs.Module, hs.Function), hs.SelfTime, hs.SelfPercentage, hs.SelfSamples, markdownSource(hs.SourceFile, hs.LineNumber)))
+	}
+	return sb.String()
+}
+
+// moduleTime is one module's self time in analyze_modules
+type moduleTime struct {
+	Module     string  `json:"module"`
+	Time       float64 `json:"time"`
+	Percentage float64 `json:"percentage"`
+}
+
+// modulesResult is the result of analyze_modules
+type modulesResult struct {
+	Profile   profileRef   `json:"profile"`
+	TotalTime float64      `json:"total_time"`
+	Modules   []moduleTime `json:"modules"` // Sorted by time (descending)
+}
+
+func (r modulesResult) Text() string {
+	var sb strings.Builder
+	sb.WriteString("📦 MODULE TIME ANALYSIS\n")
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+
+	for i, m := range r.Modules {
+		sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, m.Module))
+		sb.WriteString(fmt.Sprintf("   Time: %.6f seconds (%.2f%%)\n", m.Time, m.Percentage))
+		sb.WriteString("   ")
+		sb.WriteString(percentageBar(m.Percentage))
+		sb.WriteString("\n\n")
+	}
+	return sb.String()
+}
+
+func (r modulesResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("## Module time analysis\n\n")
+	sb.WriteString(fmt.Sprintf("Total time: %.6f seconds\n\n", r.TotalTime))
+	sb.WriteString("| # | Module | Time (s) | % |\n")
+	sb.WriteString("|--:|---|--:|--:|\n")
+	for i, m := range r.Modules {
+		sb.WriteString(fmt.Sprintf("| %d | %s | %.6f | %.2f |\n", i+1, markdownEscape(m.Module), m.Time, m.Percentage))
+	}
+	return sb.String()
+}
+
+// issueSummary counts detected issues by severity
+type issueSummary struct {
+	Critical int `json:"critical"`
+	High     int `json:"high"`
+	Medium   int `json:"medium"`
+	Low      int `json:"low"`
+}
+
+// issuesResult is the result of detect_performance_issues
+type issuesResult struct {
+	Profile profileRef                  `json:"profile"`
+	Issues  []analyzer.PerformanceIssue `json:"issues"`
+	Summary issueSummary                `json:"summary"`
+}
+
+func newIssuesResult(profile profileRef, issues []analyzer.PerformanceIssue) issuesResult {
+	r := issuesResult{Profile: profile, Issues: orEmpty(issues)}
+	for _, issue := range issues {
+		switch issue.Severity {
+		case "Critical":
+			r.Summary.Critical++
+		case "High":
+			r.Summary.High++
+		case "Medium":
+			r.Summary.Medium++
+		case "Low":
+			r.Summary.Low++
+		}
+	}
+	return r
+}
+
+// bySeverity returns the issues of one severity
+func (r issuesResult) bySeverity(severity string) []analyzer.PerformanceIssue {
+	var issues []analyzer.PerformanceIssue
+	for _, issue := range r.Issues {
+		if issue.Severity == severity {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+func (r issuesResult) Text() string {
+	var sb strings.Builder
+	sb.WriteString("⚠️  AUTOMATED PERFORMANCE ISSUE DETECTION\n")
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+
+	if len(r.Issues) == 0 {
+		sb.WriteString("✅ No significant performance issues detected!\n")
+		return sb.String()
+	}
+
+	for _, group := range []struct{ severity, heading string }{
+		{"Critical", "🔴 CRITICAL ISSUES:\n\n"},
+		{"High", "🟠 HIGH PRIORITY ISSUES:\n\n"},
+	} {
+		issues := r.bySeverity(group.severity)
+		if len(issues) == 0 {
+			continue
+		}
+		sb.WriteString(group.heading)
+		for i, issue := range issues {
+			sb.WriteString(fmt.Sprintf("%d. [%s] %s\n", i+1, issue.Category, issue.Description))
+			if issue.Function != "" {
+				sb.WriteString(fmt.Sprintf("   Function: %s!%s\n", issue.Module, issue.Function))
+			}
+			if issue.Impact > 0 {
+				sb.WriteString(fmt.Sprintf("   Impact: %.2f%% of total time\n", issue.Impact))
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	sb.WriteString("\n📊 SUMMARY:\n")
+	sb.WriteString(fmt.Sprintf("   Critical: %d\n", r.Summary.Critical))
+	sb.WriteString(fmt.Sprintf("   High: %d\n", r.Summary.High))
+	sb.WriteString(fmt.Sprintf("   Medium: %d\n", r.Summary.Medium))
+	sb.WriteString(fmt.Sprintf("   Low: %d\n", r.Summary.Low))
+	return sb.String()
+}
+
+func (r issuesResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("## Performance issues\n\n")
+	if len(r.Issues) == 0 {
+		sb.WriteString("No significant performance issues detected.\n")
+		return sb.String()
+	}
+	sb.WriteString(fmt.Sprintf("Critical: %d, High: %d, Medium: %d, Low: %d\n\n",
+		r.Summary.Critical, r.Summary.High, r.Summary.Medium, r.Summary.Low))
+	sb.WriteString("| Severity | Category | Description | Function | Impact % |\n")
+	sb.WriteString("|---|---|---|---|--:|\n")
+	for _, issue := range r.Issues {
+		function := ""
+		if issue.Function != "" {
+			function = markdownFunction(issue.Module, issue.Function)
+		}
+		sb.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %.2f |\n",
+			issue.Severity, markdownEscape(issue.Category), markdownEscape(issue.Description), function, issue.Impact))
+	}
+	return sb.String()
+}
+
+// statisticsResult is the result of get_statistics
+type statisticsResult struct {
+	Profile    profileRef                 `json:"profile"`
+	Statistics analyzer.ProfileStatistics `json:"statistics"`
+}
+
+func (r statisticsResult) Text() string {
+	stats := r.Statistics
+	var sb strings.Builder
+	sb.WriteString("📊 PROFILE STATISTICS\n")
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+
+	sb.WriteString(fmt.Sprintf("Total Execution Time: %.6f seconds\n", stats.TotalTime))
+	sb.WriteString(fmt.Sprintf("Total Callstacks: %d\n", stats.TotalCallstacks))
+	sb.WriteString(fmt.Sprintf("Total Symbols: %d\n\n", stats.TotalSymbols))
+
+	sb.WriteString("Call Stack Depth Statistics:\n")
+	sb.WriteString(fmt.Sprintf("  Average: %.2f frames\n", stats.AverageStackDepth))
+	sb.WriteString(fmt.Sprintf("  Maximum: %d frames\n", stats.MaxStackDepth))
+	sb.WriteString(fmt.Sprintf("  Minimum: %d frames\n\n", stats.MinStackDepth))
+
+	sb.WriteString("Unique Elements:\n")
+	sb.WriteString(fmt.Sprintf("  Modules: %d\n", stats.UniqueModules))
+	sb.WriteString(fmt.Sprintf("  Functions: %d\n", stats.UniqueFunctions))
+	return sb.String()
+}
+
+func (r statisticsResult) Markdown() string {
+	stats := r.Statistics
+	var sb strings.Builder
+	sb.WriteString("## Profile statistics\n\n")
+	sb.WriteString("| | |\n|---|--:|\n")
+	sb.WriteString(fmt.Sprintf("| Total execution time (s) | %.6f |\n", stats.TotalTime))
+	sb.WriteString(fmt.Sprintf("| Callstacks | %d |\n", stats.TotalCallstacks))
+	sb.WriteString(fmt.Sprintf("| Symbols | %d |\n", stats.TotalSymbols))
+	sb.WriteString(fmt.Sprintf("| Average stack depth | %.2f |\n", stats.AverageStackDepth))
+	sb.WriteString(fmt.Sprintf("| Maximum stack depth | %d |\n", stats.MaxStackDepth))
+	sb.WriteString(fmt.Sprintf("| Minimum stack depth | %d |\n", stats.MinStackDepth))
+	sb.WriteString(fmt.Sprintf("| Unique modules | %d |\n", stats.UniqueModules))
+	sb.WriteString(fmt.Sprintf("| Unique functions | %d |\n", stats.UniqueFunctions))
+	return sb.String()
+}
+
+// callstackResult is the result of view_callstack
+type callstackResult struct {
+	Profile  profileRef             `json:"profile"`
+	Index    int                    `json:"index"` // 1-based
+	Duration float64                `json:"duration"`
+	Depth    int                    `json:"depth"`
+	Frames   []sleepy.ResolvedFrame `json:"frames"` // Leaf first
+}
+
+func (r callstackResult) Text() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📞 CALLSTACK #%d\n", r.Index))
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+	sb.WriteString(fmt.Sprintf("Duration: %.6f seconds\n", r.Duration))
+	sb.WriteString(fmt.Sprintf("Stack Depth: %d frames\n\n", r.Depth))
+
+	sb.WriteString("Call Stack (bottom to top):\n\n")
+
+	for i, frame := range r.Frames {
+		sb.WriteString(fmt.Sprintf("%d. ", i))
+
+		if frame.Module != "" && frame.Module != "?" {
+			sb.WriteString(fmt.Sprintf("%s!%s\n", frame.Module, frame.Function))
+		} else {
+			sb.WriteString(fmt.Sprintf("%s\n", frame.Function))
+		}
+
+		if frame.SourceFile != "" && frame.SourceFile != "[unknown]" {
+			sb.WriteString(fmt.Sprintf("   %s:%d\n", frame.SourceFile, frame.LineNumber))
+		}
+
+		sb.WriteString(fmt.Sprintf("   [0x%X]\n\n", frame.Address))
+	}
+	return sb.String()
+}
+
+func (r callstackResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("## Callstack #%d\n\n", r.Index))
+	sb.WriteString(fmt.Sprintf("Duration: %.6f seconds, %d frames (leaf first)\n\n", r.Duration, r.Depth))
+	sb.WriteString("| # | Function | Source | Address |\n")
+	sb.WriteString("|--:|---|---|---|\n")
+	for i, frame := range r.Frames {
+		function := markdownEscape(frame.Function)
+		if frame.Module != "" && frame.Module != "?" {
+			function = markdownFunction(frame.Module, frame.Function)
+		}
+		sb.WriteString(fmt.Sprintf("| %d | %s | %s | `0x%X` |\n", i, function, markdownSource(frame.SourceFile, frame.LineNumber), frame.Address))
+	}
+	return sb.String()
+}
+
+// functionDetail is one matched function in function_details, with its callers
+// and callees cut to top_n
+type functionDetail struct {
+	analyzer.FunctionDetails
+	CallerCount int `json:"caller_count"` // Number of callers before cutting
+	CalleeCount int `json:"callee_count"` // Number of callees before cutting
+}
+
+// functionDetailsResult is the result of function_details
+type functionDetailsResult struct {
+	Profile      profileRef       `json:"profile"`
+	Pattern      string           `json:"pattern"`
+	TotalMatches int              `json:"total_matches"`
+	Functions    []functionDetail `json:"functions"` // Top max_matches by inclusive time
+}
+
+func newFunctionDetailsResult(profile profileRef, pattern string, details []analyzer.FunctionDetails, topN, maxMatches int) functionDetailsResult {
+	r := functionDetailsResult{Profile: profile, Pattern: pattern, TotalMatches: len(details), Functions: []functionDetail{}}
+	if maxMatches > 0 && len(details) > maxMatches {
+		details = details[:maxMatches]
+	}
+	for _, d := range details {
+		fd := functionDetail{FunctionDetails: d, CallerCount: len(d.Callers), CalleeCount: len(d.Callees)}
+		fd.Callers = orEmpty(fd.Callers)
+		fd.Callees = orEmpty(fd.Callees)
+		if topN > 0 && len(fd.Callers) > topN {
+			fd.Callers = fd.Callers[:topN]
+		}
+		if topN > 0 && len(fd.Callees) > topN {
+			fd.Callees = fd.Callees[:topN]
+		}
+		r.Functions = append(r.Functions, fd)
+	}
+	return r
+}
+
+func (r functionDetailsResult) Text() string {
+	var sb strings.Builder
+	sb.WriteString("🦋 FUNCTION CALLERS AND CALLEES\n")
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+
+	if r.TotalMatches == 0 {
+		sb.WriteString(fmt.Sprintf("No function matching %q found.\n", r.Pattern))
+		return sb.String()
+	}
+
+	if r.TotalMatches > len(r.Functions) {
+		sb.WriteString(fmt.Sprintf("%d functions match; showing the top %d by inclusive time.\n\n", r.TotalMatches, len(r.Functions)))
+	}
+
+	for _, d := range r.Functions {
+		sb.WriteString(fmt.Sprintf("%s!%s\n", d.Module, d.Function))
+		if d.SourceFile != "" && d.SourceFile != "[unknown]" {
+			sb.WriteString(fmt.Sprintf("    Source: %s:%d\n", d.SourceFile, d.LineNumber))
+		}
+		sb.WriteString(fmt.Sprintf("    Self Time: %.6f seconds (%.2f%%)\n", d.SelfTime, d.SelfPercentage))
+		sb.WriteString(fmt.Sprintf("    Inclusive Time: %.6f seconds (%.2f%%)\n\n", d.InclusiveTime, d.InclusivePercentage))
+
+		sb.WriteString("  Called by:\n")
+		if d.CallerCount == 0 {
+			sb.WriteString("    (none - root of callstack)\n")
+		}
+		writeCallEdges(&sb, d.Callers, d.CallerCount)
+
+		sb.WriteString("  Calls:\n")
+		if d.CalleeCount == 0 {
+			sb.WriteString("    (none - leaf function)\n")
+		}
+		writeCallEdges(&sb, d.Callees, d.CalleeCount)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// writeCallEdges lists the shown edges of a function, noting how many more there are
+func writeCallEdges(sb *strings.Builder, edges []analyzer.CallEdge, total int) {
+	for _, e := range edges {
+		sb.WriteString(fmt.Sprintf("    %.6f s (%6.2f%%)  %s!%s\n", e.Time, e.Percentage, e.Module, e.Function))
+	}
+	if total > len(edges) {
+		sb.WriteString(fmt.Sprintf("    ... %d more\n", total-len(edges)))
+	}
+}
+
+func (r functionDetailsResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("## Function callers and callees\n\n")
+	if r.TotalMatches == 0 {
+		sb.WriteString(fmt.Sprintf("No function matching `%s` found.\n", r.Pattern))
+		return sb.String()
+	}
+	if r.TotalMatches > len(r.Functions) {
+		sb.WriteString(fmt.Sprintf("%d functions match; showing the top %d by inclusive time.\n\n", r.TotalMatches, len(r.Functions)))
+	}
+
+	for _, d := range r.Functions {
+		sb.WriteString(fmt.Sprintf("### %s\n\n", markdownFunction(d.Module, d.Function)))
+		if d.SourceFile != "" && d.SourceFile != "[unknown]" {
+			sb.WriteString(fmt.Sprintf("- Source: %s\n", markdownSource(d.SourceFile, d.LineNumber)))
+		}
+		sb.WriteString(fmt.Sprintf("- Self time: %.6f s (%.2f%%)\n", d.SelfTime, d.SelfPercentage))
+		sb.WriteString(fmt.Sprintf("- Inclusive time: %.6f s (%.2f%%)\n\n", d.InclusiveTime, d.InclusivePercentage))
+		writeMarkdownCallEdges(&sb, "Called by", "none - root of callstack", d.Callers, d.CallerCount)
+		writeMarkdownCallEdges(&sb, "Calls", "none - leaf function", d.Callees, d.CalleeCount)
+	}
+	return sb.String()
+}
+
+// writeMarkdownCallEdges writes one side of a function's butterfly view as a table
+func writeMarkdownCallEdges(sb *strings.Builder, heading, none string, edges []analyzer.CallEdge, total int) {
+	sb.WriteString(fmt.Sprintf("**%s:**", heading))
+	if total == 0 {
+		sb.WriteString(fmt.Sprintf(" (%s)\n\n", none))
+		return
+	}
+	sb.WriteString("\n\n| Function | Time (s) | % |\n|---|--:|--:|\n")
+	for _, e := range edges {
+		sb.WriteString(fmt.Sprintf("| %s | %.6f | %.2f |\n", markdownFunction(e.Module, e.Function), e.Time, e.Percentage))
+	}
+	if total > len(edges) {
+		sb.WriteString(fmt.Sprintf("| … %d more | | |\n", total-len(edges)))
+	}
+	sb.WriteString("\n")
+}
+
+// Views of call_tree and bottom_up_tree
+const (
+	callTreeTopDown  = "top_down"
+	callTreeBottomUp = "bottom_up"
+)
+
+// callTreeResult is the result of call_tree and bottom_up_tree
+type callTreeResult struct {
+	Profile profileRef            `json:"profile"`
+	View    string                `json:"view"` // "top_down" or "bottom_up"
+	Tree    analyzer.FlatCallTree `json:"tree"`
+}
+
+func newCallTreeResult(profile profileRef, view string, tree map[string]*analyzer.CallChainNode, opts analyzer.CallTreeOptions) callTreeResult {
+	flat := analyzer.FlattenCallTree(tree, opts)
+	flat.Rows = orEmpty(flat.Rows)
+	return callTreeResult{Profile: profile, View: view, Tree: flat}
+}
+
+// empty reports whether the profile had no callstacks at all, as opposed to every node being pruned
+func (r callTreeResult) empty() bool {
+	return len(r.Tree.Rows) == 0 && r.Tree.HiddenRoots == 0
+}
+
+func (r callTreeResult) Text() string {
+	var sb strings.Builder
+	if r.View == callTreeBottomUp {
+		sb.WriteString("🔻 BOTTOM-UP CALL TREE (Leaf Functions and Their Callers)\n")
+	} else {
+		sb.WriteString("🌳 TOP-DOWN CALL TREE\n")
+	}
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+
+	if r.empty() {
+		sb.WriteString("No callstacks found.\n")
+	} else {
+		sb.WriteString(analyzer.FormatFlatCallTree(r.Tree))
+	}
+	return sb.String()
+}
+
+func (r callTreeResult) Markdown() string {
+	var sb strings.Builder
+	if r.View == callTreeBottomUp {
+		sb.WriteString("## Bottom-up call tree (leaf functions and their callers)\n\n")
+	} else {
+		sb.WriteString("## Top-down call tree\n\n")
+	}
+	if r.empty() {
+		sb.WriteString("No callstacks found.\n")
+		return sb.String()
+	}
+	sb.WriteString("```\n")
+	sb.WriteString(analyzer.FormatFlatCallTree(r.Tree))
+	sb.WriteString("```\n")
+	return sb.String()
+}
+
+// patternsResult is the result of callstack_patterns
+type patternsResult struct {
+	Profile  profileRef                  `json:"profile"`
+	Anchor   string                      `json:"anchor"`
+	Depth    int                         `json:"depth"`
+	Patterns []analyzer.CallstackPattern `json:"patterns"`
+}
+
+func (r patternsResult) Text() string {
+	var sb strings.Builder
+	if r.Anchor == analyzer.AnchorRoot {
+		sb.WriteString("🔁 COMMON CALLSTACK PATTERNS (Entry Paths)\n")
+	} else {
+		sb.WriteString("🔁 COMMON CALLSTACK PATTERNS (Leaf Clusters)\n")
+	}
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+
+	if len(r.Patterns) == 0 {
+		sb.WriteString("No patterns found matching the thresholds.\n")
+	} else {
+		for i, p := range r.Patterns {
+			sb.WriteString(fmt.Sprintf("#%d: %.6f seconds (%.2f%%), %d callstacks\n", i+1, p.TotalTime, p.Percentage, p.Occurrences))
+			for depth, frame := range p.Frames {
+				sb.WriteString(fmt.Sprintf("    %s%s\n", strings.Repeat("  ", depth), frame))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+func (r patternsResult) Markdown() string {
+	var sb strings.Builder
+	if r.Anchor == analyzer.AnchorRoot {
+		sb.WriteString("## Common callstack patterns (entry paths)\n\n")
+	} else {
+		sb.WriteString("## Common callstack patterns (leaf clusters)\n\n")
+	}
+	if len(r.Patterns) == 0 {
+		sb.WriteString("No patterns found matching the thresholds.\n")
+		return sb.String()
+	}
+	for i, p := range r.Patterns {
+		sb.WriteString(fmt.Sprintf("%d. **%.6f s (%.2f%%)**, %d callstacks\n", i+1, p.TotalTime, p.Percentage, p.Occurrences))
+		for depth, frame := range p.Frames {
+			sb.WriteString(fmt.Sprintf("   %s- %s\n", strings.Repeat("  ", depth), markdownEscape(frame)))
+		}
+	}
+	return sb.String()
+}
+
+// threadsResult is the result of analyze_threads
+type threadsResult struct {
+	Profile profileRef               `json:"profile"`
+	Threads []analyzer.ThreadSummary `json:"threads"`
+}
+
+// threadDisplayName names a thread for output
+func threadDisplayName(t analyzer.ThreadSummary) string {
+	if t.ID == sleepy.UnknownThreadID {
+		return "[unknown thread]"
+	}
+	if t.Name == "" {
+		return "[unnamed]"
+	}
+	return t.Name
+}
+
+func (r threadsResult) Text() string {
+	var sb strings.Builder
+	sb.WriteString("🧵 THREAD TIME ANALYSIS\n")
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+
+	if len(r.Threads) == 0 {
+		sb.WriteString("No threads found.\n")
+	}
+
+	for i, t := range r.Threads {
+		sb.WriteString(fmt.Sprintf("%d. Thread %d: %s\n", i+1, t.ID, threadDisplayName(t)))
+		sb.WriteString(fmt.Sprintf("   Time: %.6f seconds (%.2f%%), %d callstacks\n", t.TotalTime, t.Percentage, t.SampleCount))
+		sb.WriteString("   ")
+		sb.WriteString(percentageBar(t.Percentage))
+		sb.WriteString("\n")
+
+		if len(t.TopFunctions) > 0 {
+			sb.WriteString("   Top self-time functions (% of thread time):\n")
+			for _, hs := range t.TopFunctions {
+				sb.WriteString(fmt.Sprintf("     %.6f s (%6.2f%%)  %s!%s\n", hs.SelfTime, hs.SelfPercentage, hs.Module, hs.Function))
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func (r threadsResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("## Thread time analysis\n\n")
+	if len(r.Threads) == 0 {
+		sb.WriteString("No threads found.\n")
+		return sb.String()
+	}
+	sb.WriteString("| # | Thread | Name | Time (s) | % | Callstacks | Top self-time functions (% of thread) |\n")
+	sb.WriteString("|--:|--:|---|--:|--:|--:|---|\n")
+	for i, t := range r.Threads {
+		top := make([]string, len(t.TopFunctions))
+		for j, hs := range t.TopFunctions {
+			top[j] = fmt.Sprintf("%s (%.2f%%)", markdownFunction(hs.Module, hs.Function), hs.SelfPercentage)
+		}
+		sb.WriteString(fmt.Sprintf("| %d | %d | %s | %.6f | %.2f | %d | %s |\n",
+			i+1, t.ID, markdownEscape(threadDisplayName(t)), t.TotalTime, t.Percentage, t.SampleCount, strings.Join(top, "<br>")))
+	}
+	return sb.String()
+}
+
+// diffResult is the result of diff_profiles. Diff holds the top_n functions and modules;
+// FunctionCount and ModuleCount are the totals before cutting.
+type diffResult struct {
+	Base          profileRef           `json:"base"`
+	Current       profileRef           `json:"current"`
+	SortBy        string               `json:"sort_by"`
+	RankBy        string               `json:"rank_by"`
+	FunctionCount int                  `json:"function_count"`
+	ModuleCount   int                  `json:"module_count"`
+	Diff          analyzer.ProfileDiff `json:"diff"`
+}
+
+func newDiffResult(base, current profileRef, diff analyzer.ProfileDiff, sortBy, rankBy string, topN int) diffResult {
+	r := diffResult{
+		Base:          base,
+		Current:       current,
+		SortBy:        sortBy,
+		RankBy:        rankBy,
+		FunctionCount: len(diff.Functions),
+		ModuleCount:   len(diff.Modules),
+		Diff:          diff,
+	}
+	r.Diff.Functions = orEmpty(r.Diff.Functions)
+	r.Diff.Modules = orEmpty(r.Diff.Modules)
+	if topN > 0 && len(r.Diff.Functions) > topN {
+		r.Diff.Functions = r.Diff.Functions[:topN]
+	}
+	if topN > 0 && len(r.Diff.Modules) > topN {
+		r.Diff.Modules = r.Diff.Modules[:topN]
+	}
+	return r
+}
+
+// functionChange picks the percentages and changes of d that the diff is sorted by
+func (r diffResult) functionChange(d analyzer.FunctionDelta) (before, after, delta, relative float64) {
+	if r.SortBy == analyzer.SortByInclusive {
+		return d.BaseInclusivePercentage, d.CurrentInclusivePercentage, d.InclusiveDelta, d.InclusiveRelativeChange
+	}
+	return d.BaseSelfPercentage, d.CurrentSelfPercentage, d.SelfDelta, d.SelfRelativeChange
+}
+
+// moduleChange picks the percentages and changes of m that the diff is sorted by
+func (r diffResult) moduleChange(m analyzer.ModuleDelta) (before, after, delta, relative float64) {
+	if r.SortBy == analyzer.SortByInclusive {
+		return m.BaseInclusivePercentage, m.CurrentInclusivePercentage, m.InclusiveDelta, m.InclusiveRelativeChange
+	}
+	return m.BaseSelfPercentage, m.CurrentSelfPercentage, m.SelfDelta, m.SelfRelativeChange
+}
+
+func (r diffResult) Text() string {
+	var sb strings.Builder
+	sb.WriteString("⚖️  PROFILE DIFF\n")
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+	sb.WriteString(fmt.Sprintf("Baseline: %s (%.6f seconds)\n", r.Base.Label(), r.Diff.BaseTotalTime))
+	sb.WriteString(fmt.Sprintf("Current:  %s (%.6f seconds)\n", r.Current.Label(), r.Diff.CurrentTotalTime))
+	sb.WriteString("Percentages are relative to each profile's total time; deltas are in percentage points (pp).\n\n")
+
+	sb.WriteString(fmt.Sprintf("FUNCTIONS (%s time, ranked by %s change):\n\n", r.SortBy, r.RankBy))
+	if len(r.Diff.Functions) == 0 {
+		sb.WriteString("No functions found.\n")
+	}
+	for i, d := range r.Diff.Functions {
+		before, after, delta, relative := r.functionChange(d)
+		sb.WriteString(fmt.Sprintf("#%d: %s!%s%s\n", i+1, d.Module, d.Function, deltaStatusLabel(d.Status)))
+		sb.WriteString(fmt.Sprintf("    %.2f%% → %.2f%% (%s)\n\n", before, after, formatDelta(d.Status, delta, relative)))
+	}
+
+	sb.WriteString(fmt.Sprintf("MODULES (%s time, ranked by %s change):\n\n", r.SortBy, r.RankBy))
+	for i, m := range r.Diff.Modules {
+		before, after, delta, relative := r.moduleChange(m)
+		sb.WriteString(fmt.Sprintf("%d. %s%s\n", i+1, m.Module, deltaStatusLabel(m.Status)))
+		sb.WriteString(fmt.Sprintf("   %.2f%% → %.2f%% (%s)\n\n", before, after, formatDelta(m.Status, delta, relative)))
+	}
+	return sb.String()
+}
+
+func (r diffResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("## Profile diff\n\n")
+	sb.WriteString(fmt.Sprintf("- Baseline: %s (%.6f s)\n", markdownEscape(r.Base.Label()), r.Diff.BaseTotalTime))
+	sb.WriteString(fmt.Sprintf("- Current: %s (%.6f s)\n\n", markdownEscape(r.Current.Label()), r.Diff.CurrentTotalTime))
+	sb.WriteString("Percentages are relative to each profile's total time; deltas are in percentage points (pp).\n\n")
+
+	sb.WriteString(fmt.Sprintf("### Functions (%s time, ranked by %s change)\n\n", r.SortBy, r.RankBy))
+	if len(r.Diff.Functions) == 0 {
+		sb.WriteString("No functions found.\n\n")
+	} else {
+		sb.WriteString("| # | Function | Status | Before % | After % | Change |\n")
+		sb.WriteString("|--:|---|---|--:|--:|---|\n")
+		for i, d := range r.Diff.Functions {
+			before, after, delta, relative := r.functionChange(d)
+			sb.WriteString(fmt.Sprintf("| %d | %s | %s | %.2f | %.2f | %s |\n",
+				i+1, markdownFunction(d.Module, d.Function), d.Status, before, after, formatDelta(d.Status, delta, relative)))
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("### Modules (%s time, ranked by %s change)\n\n", r.SortBy, r.RankBy))
+	sb.WriteString("| # | Module | Status | Before % | After % | Change |\n")
+	sb.WriteString("|--:|---|---|--:|--:|---|\n")
+	for i, m := range r.Diff.Modules {
+		before, after, delta, relative := r.moduleChange(m)
+		sb.WriteString(fmt.Sprintf("| %d | %s | %s | %.2f | %.2f | %s |\n",
+			i+1, markdownEscape(m.Module), m.Status, before, after, formatDelta(m.Status, delta, relative)))
+	}
+	return sb.String()
+}
+
+// exportResult is the result of export_profile
+type exportResult struct {
+	Profile    profileRef `json:"profile"`
+	Format     string     `json:"format"`
+	OutputPath string     `json:"output_path"`
+	Size       int64      `json:"size"` // Bytes written
+	Callstacks int        `json:"callstacks"`
+}
+
+func (r exportResult) Text() string {
+	return fmt.Sprintf(`Profile exported successfully!
+
+Format: %s
+Output: %s
+Size: %d bytes
+Callstacks: %d
+`,
+		r.Format,
+		r.OutputPath,
+		r.Size,
+		r.Callstacks,
+	)
+}
+
+func (r exportResult) Markdown() string {
+	return fmt.Sprintf("## Profile exported\n\n- Format: %s\n- Output: `%s`\n- Size: %d bytes\n- Callstacks: %d\n",
+		r.Format, r.OutputPath, r.Size, r.Callstacks)
+}
+
+// flameGraphResult is the result of flame_graph
+type flameGraphResult struct {
+	Profile    profileRef `json:"profile"`
+	OutputPath string     `json:"output_path"`
+	Size       int64      `json:"size"` // Bytes written
+	Callstacks int        `json:"callstacks"`
+}
+
+func (r flameGraphResult) Text() string {
+	return fmt.Sprintf(`Flame graph written successfully!
+
+Output: %s
+Size: %d bytes
+Callstacks: %d
+
+Open the SVG in a web browser: hover a frame for details, click to zoom, use "Search" to highlight functions by regex.
+`,
+		r.OutputPath,
+		r.Size,
+		r.Callstacks,
+	)
+}
+
+func (r flameGraphResult) Markdown() string {
+	return fmt.Sprintf("## Flame graph written\n\n- Output: `%s`\n- Size: %d bytes\n- Callstacks: %d\n\n"+
+		"Open the SVG in a web browser: hover a frame for details, click to zoom, use \"Search\" to highlight functions by regex.\n",
+		r.OutputPath, r.Size, r.Callstacks)
+}
+
+// diffFlameGraphResult is the result of diff_flame_graph
+type diffFlameGraphResult struct {
+	Base       profileRef `json:"base"`
+	Current    profileRef `json:"current"`
+	DiffBy     string     `json:"diff_by"`
+	OutputPath string     `json:"output_path"`
+	Size       int64      `json:"size"` // Bytes written
+}
+
+func (r diffFlameGraphResult) Text() string {
+	return fmt.Sprintf(`Differential flame graph written successfully!
+
+Output: %s
+Size: %d bytes
+
+Red frames take a larger share of total time than in the baseline, blue frames a smaller one.
+Hover a frame to see both percentages and the change in percentage points.
+`,
+		r.OutputPath,
+		r.Size,
+	)
+}
+
+func (r diffFlameGraphResult) Markdown() string {
+	return fmt.Sprintf("## Differential flame graph written\n\n- Baseline: %s\n- Current: %s\n- Output: `%s`\n- Size: %d bytes\n\n"+
+		"Red frames take a larger share of total time than in the baseline, blue frames a smaller one.\n",
+		markdownEscape(r.Base.Label()), markdownEscape(r.Current.Label()), r.OutputPath, r.Size)
+}
+
+// mergeResult is the result of merge_profiles
+type mergeResult struct {
+	Profile          profileRef             `json:"profile"` // The merged profile, loaded from OutputPath
+	OutputPath       string                 `json:"output_path"`
+	Size             int64                  `json:"size"` // Bytes written
+	Callstacks       int                    `json:"callstacks"`
+	CommonCallstacks int                    `json:"common_callstacks"` // Callstacks present in every source
+	Symbols          int                    `json:"symbols"`
+	Threads          int                    `json:"threads"`
+	Sources          []analyzer.MergeSource `json:"sources"`
+	Evicted          []string               `json:"evicted"` // Profiles unloaded to make room
+}
+
+// sourceShare returns a source's percentage of the merged time
+func (r mergeResult) sourceShare(src analyzer.MergeSource) float64 {
+	totalTime := 0.0
+	for _, s := range r.Sources {
+		totalTime += s.TotalTime
+	}
+	if totalTime > 0 {
+		return src.TotalTime / totalTime * 100.0
+	}
+	return 0
+}
+
+func (r mergeResult) Text() string {
+	var sb strings.Builder
+	sb.WriteString("🧩 MERGED PROFILE\n")
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+	sb.WriteString(fmt.Sprintf("Output: %s (%d bytes)\n", r.OutputPath, r.Size))
+	sb.WriteString(fmt.Sprintf("Profile: %s\n", r.Profile.Label()))
+	sb.WriteString(fmt.Sprintf("Callstacks: %d (%d present in every source)\n", r.Callstacks, r.CommonCallstacks))
+	sb.WriteString(fmt.Sprintf("Symbols: %d\n", r.Symbols))
+	sb.WriteString(fmt.Sprintf("Threads: %d\n\n", r.Threads))
+
+	sb.WriteString("SOURCES:\n\n")
+	for i, src := range r.Sources {
+		sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, src.Name))
+		sb.WriteString(fmt.Sprintf("   Time: %.6f seconds (%.2f%% of merged)\n", src.TotalTime, r.sourceShare(src)))
+		sb.WriteString(fmt.Sprintf("   Callstacks: %d, Samples: %d\n\n", src.Callstacks, src.Samples))
+	}
+
+	sb.WriteString(fmt.Sprintf("Use %q as file_path with the other tools to analyze the aggregate.\n", r.Profile.Handle))
+	sb.WriteString(formatEvictions(r.Evicted))
+	return sb.String()
+}
+
+func (r mergeResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("## Merged profile\n\n")
+	sb.WriteString(fmt.Sprintf("- Output: `%s` (%d bytes)\n", r.OutputPath, r.Size))
+	sb.WriteString(fmt.Sprintf("- Profile: %s\n", markdownEscape(r.Profile.Label())))
+	sb.WriteString(fmt.Sprintf("- Callstacks: %d (%d present in every source)\n", r.Callstacks, r.CommonCallstacks))
+	sb.WriteString(fmt.Sprintf("- Symbols: %d\n", r.Symbols))
+	sb.WriteString(fmt.Sprintf("- Threads: %d\n\n", r.Threads))
+
+	sb.WriteString("| # | Source | Time (s) | % of merged | Callstacks | Samples |\n")
+	sb.WriteString("|--:|---|--:|--:|--:|--:|\n")
+	for i, src := range r.Sources {
+		sb.WriteString(fmt.Sprintf("| %d | %s | %.6f | %.2f | %d | %d |\n",
+			i+1, markdownEscape(src.Name), src.TotalTime, r.sourceShare(src), src.Callstacks, src.Samples))
+	}
+	sb.WriteString(fmt.Sprintf("\nUse `%s` as `file_path` with the other tools to analyze the aggregate.\n", r.Profile.Handle))
+	sb.WriteString(markdownEvictions(r.Evicted))
+	return sb.String()
+}
+
+// listedProfile is one loaded profile in list_profiles
+type listedProfile struct {
+	Profile     profileRef `json:"profile"`
+	MemoryBytes int64      `json:"memory_bytes"` // Approximate
+	Callstacks  int        `json:"callstacks"`
+	Symbols     int        `json:"symbols"`
+	File        fileState  `json:"file"`
+	LoadedAt    time.Time  `json:"loaded_at"`
+	LastUsed    time.Time  `json:"last_used"`
+}
+
+// listProfilesResult is the result of list_profiles
+type listProfilesResult struct {
+	Count        int             `json:"count"`
+	MaxProfiles  int             `json:"max_profiles"`  // 0 = unlimited
+	MemoryBytes  int64           `json:"memory_bytes"`  // Approximate
+	MemoryBudget int64           `json:"memory_budget"` // Bytes, 0 = unlimited
+	Profiles     []listedProfile `json:"profiles"`      // Most recently used first
+}
+
+func (r listProfilesResult) Text() string {
+	var sb strings.Builder
+	sb.WriteString("🗂️  LOADED PROFILES\n")
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+	sb.WriteString(fmt.Sprintf("Profiles: %d", r.Count))
+	if r.MaxProfiles > 0 {
+		sb.WriteString(fmt.Sprintf(" (limit: %d)", r.MaxProfiles))
+	}
+	sb.WriteString(fmt.Sprintf("\nMemory: ~%s", formatBytes(r.MemoryBytes)))
+	if r.MemoryBudget > 0 {
+		sb.WriteString(fmt.Sprintf(" (budget: %s)", formatBytes(r.MemoryBudget)))
+	}
+	sb.WriteString("\n\n")
+
+	if len(r.Profiles) == 0 {
+		sb.WriteString("No profiles loaded. Use load_profile tool first.\n")
+	}
+	now := time.Now()
+	for i, p := range r.Profiles {
+		sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, p.Profile.Label()))
+		sb.WriteString(fmt.Sprintf("   Size: ~%s, Callstacks: %d, Symbols: %d\n", formatBytes(p.MemoryBytes), p.Callstacks, p.Symbols))
+		sb.WriteString(fmt.Sprintf("   File: %s, modified %s, SHA-256 %s\n", formatBytes(p.File.Size), p.File.ModTime.Format(time.DateTime), shortHash(p.File.Hash)))
+		sb.WriteString(fmt.Sprintf("   Loaded: %s (%s ago)\n", p.LoadedAt.Format(time.DateTime), now.Sub(p.LoadedAt).Round(time.Second)))
+		sb.WriteString(fmt.Sprintf("   Last used: %s (%s ago)\n\n", p.LastUsed.Format(time.DateTime), now.Sub(p.LastUsed).Round(time.Second)))
+	}
+	return sb.String()
+}
+
+func (r listProfilesResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("## Loaded profiles\n\n")
+	sb.WriteString(fmt.Sprintf("Profiles: %d", r.Count))
+	if r.MaxProfiles > 0 {
+		sb.WriteString(fmt.Sprintf(" (limit: %d)", r.MaxProfiles))
+	}
+	sb.WriteString(fmt.Sprintf(", memory: ~%s", formatBytes(r.MemoryBytes)))
+	if r.MemoryBudget > 0 {
+		sb.WriteString(fmt.Sprintf(" (budget: %s)", formatBytes(r.MemoryBudget)))
+	}
+	sb.WriteString("\n\n")
+
+	if len(r.Profiles) == 0 {
+		sb.WriteString("No profiles loaded. Use load_profile tool first.\n")
+		return sb.String()
+	}
+	sb.WriteString("| Handle | Alias | Path | Memory | Callstacks | Symbols | Modified | SHA-256 | Loaded | Last used |\n")
+	sb.WriteString("|---|---|---|--:|--:|--:|---|---|---|---|\n")
+	for _, p := range r.Profiles {
+		sb.WriteString(fmt.Sprintf("| %s | %s | `%s` | ~%s | %d | %d | %s | `%s` | %s | %s |\n",
+			p.Profile.Handle, markdownEscape(p.Profile.Alias), p.Profile.Path, formatBytes(p.MemoryBytes), p.Callstacks, p.Symbols,
+			p.File.ModTime.Format(time.DateTime), shortHash(p.File.Hash), p.LoadedAt.Format(time.DateTime), p.LastUsed.Format(time.DateTime)))
+	}
+	return sb.String()
+}
+
+// unloadResult is the result of unload_profile
+type unloadResult struct {
+	Profile     profileRef `json:"profile"`
+	Remaining   int        `json:"remaining"`    // Profiles still loaded
+	MemoryBytes int64      `json:"memory_bytes"` // Approximate memory of the remaining profiles
+}
+
+func (r unloadResult) Text() string {
+	return fmt.Sprintf("Profile unloaded: %s\n\nStill loaded: %d profiles, ~%s\n", r.Profile.Label(), r.Remaining, formatBytes(r.MemoryBytes))
+}
+
+func (r unloadResult) Markdown() string {
+	return fmt.Sprintf("## Profile unloaded\n\n%s\n\nStill loaded: %d profiles, ~%s\n", markdownEscape(r.Profile.Label()), r.Remaining, formatBytes(r.MemoryBytes))
+}
+
+// reloadResult is the result of reload_profile
+type reloadResult struct {
+	Profile    profileRef   `json:"profile"`
+	Changed    bool         `json:"changed"` // Whether the contents differ from the previous load
+	Previous   fileState    `json:"previous"`
+	Current    fileState    `json:"current"`
+	Stats      sleepy.Stats `json:"stats"`
+	Callstacks int          `json:"callstacks"`
+	Symbols    int          `json:"symbols"`
+	Evicted    []string     `json:"evicted"` // Profiles unloaded to make room
+}
+
+func (r reloadResult) contents() string {
+	if !r.Changed {
+		return "unchanged since the previous load"
+	}
+	return fmt.Sprintf("changed (%s, modified %s → %s, modified %s)",
+		formatBytes(r.Previous.Size), r.Previous.ModTime.Format(time.DateTime),
+		formatBytes(r.Current.Size), r.Current.ModTime.Format(time.DateTime))
+}
+
+func (r reloadResult) Text() string {
+	var sb strings.Builder
+	sb.WriteString("🔄 PROFILE RELOADED\n")
+	sb.WriteString("═══════════════════════════════════════════════════\n\n")
+	sb.WriteString(fmt.Sprintf("Profile: %s\n", r.Profile.Label()))
+	sb.WriteString(fmt.Sprintf("Contents: %s\n", r.contents()))
+	sb.WriteString(fmt.Sprintf("Duration: %s\n", r.Stats.Duration))
+	sb.WriteString(fmt.Sprintf("Samples: %d\n", r.Stats.NumSamples))
+	sb.WriteString(fmt.Sprintf("Callstacks: %d\n", r.Callstacks))
+	sb.WriteString(fmt.Sprintf("Symbols: %d\n", r.Symbols))
+	sb.WriteString(formatEvictions(r.Evicted))
+	return sb.String()
+}
+
+func (r reloadResult) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("## Profile reloaded\n\n")
+	sb.WriteString(fmt.Sprintf("- Profile: %s\n", markdownEscape(r.Profile.Label())))
+	sb.WriteString(fmt.Sprintf("- Contents: %s\n", r.contents()))
+	sb.WriteString(fmt.Sprintf("- Duration: %s\n", markdownEscape(r.Stats.Duration)))
+	sb.WriteString(fmt.Sprintf("- Samples: %d\n", r.Stats.NumSamples))
+	sb.WriteString(fmt.Sprintf("- Callstacks: %d\n", r.Callstacks))
+	sb.WriteString(fmt.Sprintf("- Symbols: %d\n", r.Symbols))
+	sb.WriteString(markdownEvictions(r.Evicted))
+	return sb.String()
+}
+
+// percentageBar draws a percentage as a bar of up to 50 blocks, one per 2%
+func percentageBar(percentage float64) string {
+	barLength := int(percentage / 2)
+	if barLength > 50 {
+		barLength = 50
+	}
+	return strings.Repeat("█", barLength)
+}
+
+// markdownFunction renders Module!Function as inline code
+func markdownFunction(module, function string) string {
+	return "`" + strings.ReplaceAll(module+"!"+function, "`", "'") + "`"
+}
+
+// markdownSource renders a source location, or nothing if it is unknown
+func markdownSource(file string, line int) string {
+	if file == "" || file == "[unknown]" {
+		return ""
+	}
+	return fmt.Sprintf("`%s:%d`", file, line)
+}
+
+// markdownEvictions lists profiles the cache unloaded to make room, if any
+func markdownEvictions(evicted []string) string {
+	if len(evicted) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\nUnloaded to stay within the cache limits (least recently used):\n\n")
+	for _, label := range evicted {
+		sb.WriteString(fmt.Sprintf("- %s\n", markdownEscape(label)))
+	}
+	return sb.String()
+}
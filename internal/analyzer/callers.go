@@ -0,0 +1,181 @@
+package analyzer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// CallEdge represents time flowing between a function and one of its direct callers or callees
+type CallEdge struct {
+	Function    string  `json:"function"`
+	Module      string  `json:"module"`
+	Time        float64 `json:"time"`         // Total duration of callstacks containing this edge
+	SampleCount int     `json:"sample_count"` // Number of callstacks containing this edge
+	Percentage  float64 `json:"percentage"`   // Percentage of the target function's inclusive time
+}
+
+// FunctionDetails is a caller/callee ("butterfly") view of a single function
+type FunctionDetails struct {
+	Function            string     `json:"function"`
+	Module              string     `json:"module"`
+	SourceFile          string     `json:"source_file"`
+	LineNumber          int        `json:"line_number"`
+	SelfTime            float64    `json:"self_time"`
+	InclusiveTime       float64    `json:"inclusive_time"`
+	SelfSamples         int        `json:"self_samples"`
+	InclusiveSamples    int        `json:"inclusive_samples"`
+	SelfPercentage      float64    `json:"self_percentage"`
+	InclusivePercentage float64    `json:"inclusive_percentage"`
+	Callers             []CallEdge `json:"callers"` // Functions that call this function, sorted by time (descending)
+	Callees             []CallEdge `json:"callees"` // Functions called by this function, sorted by time (descending)
+}
+
+// GetFunctionDetails builds a caller/callee view for every function matching pattern.
+// pattern is either an exact Module!Function signature or a regular expression
+// matched against Module!Function. Results are sorted by inclusive time (descending).
+func GetFunctionDetails(profile *sleepy.ProfileData, pattern string) ([]FunctionDetails, error) {
+	ix := profile.Index()
+	matches, err := functionMatcher(ix, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	// Match each function once rather than once per frame
+	isTarget := make([]bool, len(ix.Functions))
+	for funcID := range ix.Functions {
+		isTarget[funcID] = matches(ix.Functions[funcID].Signature)
+	}
+
+	type edgeMaps struct {
+		details *FunctionDetails
+		callers map[int]*CallEdge
+		callees map[int]*CallEdge
+	}
+	targets := make(map[int]*edgeMaps)
+	lastStack := newStackMarks(len(ix.Functions))
+
+	for csIdx, stack := range ix.Stacks {
+		duration := ix.Durations[csIdx]
+
+		seenEdge := make(map[callEdgeKey]bool)
+		for i, funcID := range stack {
+			if !isTarget[funcID] {
+				continue
+			}
+
+			t, exists := targets[funcID]
+			if !exists {
+				fn := &ix.Functions[funcID]
+				t = &edgeMaps{
+					details: &FunctionDetails{
+						Function:   fn.Function,
+						Module:     fn.Module,
+						SourceFile: fn.SourceFile,
+						LineNumber: fn.LineNumber,
+					},
+					callers: make(map[int]*CallEdge),
+					callees: make(map[int]*CallEdge),
+				}
+				targets[funcID] = t
+			}
+
+			if i == 0 {
+				t.details.SelfTime += duration
+				t.details.SelfSamples++
+			}
+			if lastStack.mark(funcID, csIdx) {
+				t.details.InclusiveTime += duration
+				t.details.InclusiveSamples++
+			}
+
+			// Frames are leaf-first: the caller is one frame further from the leaf
+			if i+1 < len(stack) {
+				addCallEdge(ix, t.callers, callEdgeKey{Target: funcID, Other: stack[i+1], Caller: true}, seenEdge, duration)
+			}
+			if i > 0 {
+				addCallEdge(ix, t.callees, callEdgeKey{Target: funcID, Other: stack[i-1]}, seenEdge, duration)
+			}
+		}
+	}
+
+	results := make([]FunctionDetails, 0, len(targets))
+	for _, t := range targets {
+		d := t.details
+		if ix.TotalTime > 0 {
+			d.SelfPercentage = (d.SelfTime / ix.TotalTime) * 100.0
+			d.InclusivePercentage = (d.InclusiveTime / ix.TotalTime) * 100.0
+		}
+		d.Callers = sortedCallEdges(t.callers, d.InclusiveTime)
+		d.Callees = sortedCallEdges(t.callees, d.InclusiveTime)
+		results = append(results, *d)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].InclusiveTime > results[j].InclusiveTime
+	})
+
+	return results, nil
+}
+
+// functionMatcher returns a predicate over Module!Function signatures for pattern.
+// An exact signature match takes precedence over regular expression matching.
+func functionMatcher(ix *sleepy.ProfileIndex, pattern string) (func(string) bool, error) {
+	for i := range ix.Functions {
+		if ix.Functions[i].Signature == pattern {
+			return func(funcSig string) bool { return funcSig == pattern }, nil
+		}
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid function pattern %q: %w", pattern, err)
+	}
+	return re.MatchString, nil
+}
+
+// callEdgeKey identifies a caller or callee edge of a target function within a callstack
+type callEdgeKey struct {
+	Target int
+	Other  int
+	Caller bool // Other calls Target (otherwise Target calls Other)
+}
+
+// addCallEdge accumulates duration on an edge, counting each edge once per callstack
+func addCallEdge(ix *sleepy.ProfileIndex, edges map[int]*CallEdge, key callEdgeKey, seen map[callEdgeKey]bool, duration float64) {
+	if seen[key] {
+		return
+	}
+	seen[key] = true
+
+	e, exists := edges[key.Other]
+	if !exists {
+		fn := &ix.Functions[key.Other]
+		e = &CallEdge{
+			Function: fn.Function,
+			Module:   fn.Module,
+		}
+		edges[key.Other] = e
+	}
+	e.Time += duration
+	e.SampleCount++
+}
+
+// sortedCallEdges converts an edge map to a slice sorted by time (descending)
+func sortedCallEdges(edges map[int]*CallEdge, inclusiveTime float64) []CallEdge {
+	result := make([]CallEdge, 0, len(edges))
+	for _, e := range edges {
+		if inclusiveTime > 0 {
+			e.Percentage = (e.Time / inclusiveTime) * 100.0
+		}
+		result = append(result, *e)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time > result[j].Time
+	})
+
+	return result
+}
@@ -0,0 +1,108 @@
+package analyzer
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestGetFunctionDetails(t *testing.T) {
+	type want struct {
+		function         string
+		self, inclusive  float64
+		callers, callees []string // "Module!Function time samples"
+	}
+	tests := []struct {
+		name    string
+		pattern string
+		want    []want
+	}{
+		{
+			name:    "recursive function",
+			pattern: "app!parse",
+			want: []want{{
+				function: "app!parse", self: 0.375, inclusive: 0.625,
+				// The recursive edge is counted once for the callstack it occurs in
+				callers: []string{"app!main 0.625 3", "app!parse 0.125 1"},
+				callees: []string{"libc!malloc 0.25 1", "app!parse 0.125 1"},
+			}},
+		},
+		{
+			name:    "leaf with several callers",
+			pattern: "libc!malloc",
+			want: []want{{
+				function: "libc!malloc", self: 0.375, inclusive: 0.375,
+				callers: []string{"app!parse 0.25 1", "app!render 0.125 1"},
+				callees: []string{},
+			}},
+		},
+		{
+			name:    "root",
+			pattern: "app!main",
+			want: []want{{
+				function: "app!main", inclusive: 1,
+				callers: []string{},
+				callees: []string{"app!parse 0.625 3", "app!render 0.375 2"},
+			}},
+		},
+		{
+			name:    "regular expression",
+			pattern: "^libc!",
+			want: []want{
+				{
+					function: "libc!malloc", self: 0.375, inclusive: 0.375,
+					callers: []string{"app!parse 0.25 1", "app!render 0.125 1"},
+					callees: []string{},
+				},
+				{
+					function: "libc!memcpy", self: 0.25, inclusive: 0.25,
+					callers: []string{"app!render 0.25 1"},
+					callees: []string{},
+				},
+			},
+		},
+		{name: "no match", pattern: "^nothing$", want: []want{}},
+	}
+
+	edgeStrings := func(edges []CallEdge) []string {
+		s := make([]string, 0, len(edges))
+		for _, e := range edges {
+			s = append(s, fmt.Sprintf("%s!%s %g %d", e.Module, e.Function, e.Time, e.SampleCount))
+		}
+		return s
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := GetFunctionDetails(testProfile(), tt.pattern)
+			if err != nil {
+				t.Fatalf("GetFunctionDetails() error = %v", err)
+			}
+			if len(details) != len(tt.want) {
+				t.Fatalf("got %d functions, want %d", len(details), len(tt.want))
+			}
+			for i, w := range tt.want {
+				d := details[i]
+				if got := d.Module + "!" + d.Function; got != w.function {
+					t.Errorf("details[%d] = %s, want %s", i, got, w.function)
+					continue
+				}
+				if d.SelfTime != w.self || d.InclusiveTime != w.inclusive {
+					t.Errorf("%s self/inclusive = %v/%v, want %v/%v", w.function, d.SelfTime, d.InclusiveTime, w.self, w.inclusive)
+				}
+				if got := edgeStrings(d.Callers); !reflect.DeepEqual(got, w.callers) {
+					t.Errorf("%s callers = %v, want %v", w.function, got, w.callers)
+				}
+				if got := edgeStrings(d.Callees); !reflect.DeepEqual(got, w.callees) {
+					t.Errorf("%s callees = %v, want %v", w.function, got, w.callees)
+				}
+			}
+		})
+	}
+}
+
+func TestGetFunctionDetailsInvalidPattern(t *testing.T) {
+	if _, err := GetFunctionDetails(testProfile(), "("); err == nil {
+		t.Errorf("GetFunctionDetails() error = nil, want an error")
+	}
+}
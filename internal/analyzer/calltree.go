@@ -0,0 +1,179 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CallTreeOptions controls how a call tree is pruned for display
+type CallTreeOptions struct {
+	MinPercent  float64 // Hide nodes below this percentage of total time (0 = show all)
+	MaxDepth    int     // Maximum number of levels to render (0 = unlimited)
+	MaxChildren int     // Maximum number of children rendered per node (0 = unlimited)
+}
+
+// SortedCallChainNodes returns nodes sorted by total time (descending)
+func SortedCallChainNodes(nodes []*CallChainNode) []*CallChainNode {
+	sorted := make([]*CallChainNode, len(nodes))
+	copy(sorted, nodes)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].TotalTime != sorted[j].TotalTime {
+			return sorted[i].TotalTime > sorted[j].TotalTime
+		}
+		return sorted[i].Module+"!"+sorted[i].Function < sorted[j].Module+"!"+sorted[j].Function
+	})
+	return sorted
+}
+
+// CallTreeRoots returns the roots of a call tree sorted by total time (descending)
+func CallTreeRoots(tree map[string]*CallChainNode) []*CallChainNode {
+	roots := make([]*CallChainNode, 0, len(tree))
+	for _, node := range tree {
+		roots = append(roots, node)
+	}
+	return SortedCallChainNodes(roots)
+}
+
+// FormatCallTree renders a call tree with indentation, inclusive and self time per node.
+// Percentages are relative to the combined time of all roots.
+func FormatCallTree(tree map[string]*CallChainNode, opts CallTreeOptions) string {
+	return FormatFlatCallTree(FlattenCallTree(tree, opts))
+}
+
+// FormatFlatCallTree renders a call tree flattened by FlattenCallTree
+func FormatFlatCallTree(flat FlatCallTree) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%8s %8s  %s\n", "Incl%", "Self%", "Function"))
+
+	// A row's pruned children are summarized after its last visible descendant
+	var open []int
+	closeTo := func(depth int) {
+		for len(open) > 0 && flat.Rows[open[len(open)-1]].Depth >= depth {
+			row := &flat.Rows[open[len(open)-1]]
+			open = open[:len(open)-1]
+			writeHiddenCallTreeNodes(&sb, row.HiddenChildren, row.HiddenChildrenTime, flat.TotalTime, row.Depth+1)
+		}
+	}
+
+	for i := range flat.Rows {
+		row := &flat.Rows[i]
+		closeTo(row.Depth)
+
+		indent := strings.Repeat("  ", row.Depth)
+		sb.WriteString(fmt.Sprintf("%7.2f%% %7.2f%%  %s%s!%s  (%.6f s incl, %.6f s self)\n",
+			row.TotalPercentage, row.SelfPercentage, indent, row.Module, row.Function, row.TotalTime, row.SelfTime))
+
+		if row.HiddenByMaxDepth {
+			sb.WriteString(fmt.Sprintf("%17s  %s  ... %d callees below max depth\n", "", indent, row.HiddenChildren))
+		} else {
+			open = append(open, i)
+		}
+	}
+	closeTo(0)
+	writeHiddenCallTreeNodes(&sb, flat.HiddenRoots, flat.HiddenRootsTime, flat.TotalTime, 0)
+
+	return sb.String()
+}
+
+// writeHiddenCallTreeNodes summarizes the pruned nodes of one level, if any
+func writeHiddenCallTreeNodes(sb *strings.Builder, count int, hiddenTime, totalTime float64, level int) {
+	if count == 0 {
+		return
+	}
+	hiddenPct := 0.0
+	if totalTime > 0 {
+		hiddenPct = (hiddenTime / totalTime) * 100.0
+	}
+	sb.WriteString(fmt.Sprintf("%7.2f%% %8s  %s... %d more (pruned)\n", hiddenPct, "", strings.Repeat("  ", level), count))
+}
+
+// CallTreeRow is one node of a pruned call tree, flattened in display order
+type CallTreeRow struct {
+	Depth              int     `json:"depth"`  // 0 for roots
+	Parent             int     `json:"parent"` // Index of the parent row, -1 for roots
+	Function           string  `json:"function"`
+	Module             string  `json:"module"`
+	TotalTime          float64 `json:"total_time"`
+	SelfTime           float64 `json:"self_time"`
+	TotalPercentage    float64 `json:"total_percentage"` // Of the combined time of all roots
+	SelfPercentage     float64 `json:"self_percentage"`
+	SampleCount        int     `json:"sample_count"`
+	HiddenChildren     int     `json:"hidden_children"`      // Children pruned by the options, or below the maximum depth
+	HiddenChildrenTime float64 `json:"hidden_children_time"` // Total time of the hidden children
+	HiddenByMaxDepth   bool    `json:"hidden_by_max_depth"`  // Children are hidden because of MaxDepth
+}
+
+// FlatCallTree is a call tree pruned like FormatCallTree prunes it, as a list of rows in
+// display order: every row is followed by its visible children, largest first.
+// Rows are flat rather than nested so the tree has a non-recursive JSON schema.
+type FlatCallTree struct {
+	TotalTime       float64       `json:"total_time"`
+	Rows            []CallTreeRow `json:"rows"`
+	HiddenRoots     int           `json:"hidden_roots"`
+	HiddenRootsTime float64       `json:"hidden_roots_time"`
+}
+
+// FlattenCallTree prunes a call tree with the same rules as FormatCallTree and flattens it
+func FlattenCallTree(tree map[string]*CallChainNode, opts CallTreeOptions) FlatCallTree {
+	roots := CallTreeRoots(tree)
+
+	flat := FlatCallTree{Rows: []CallTreeRow{}}
+	for _, root := range roots {
+		flat.TotalTime += root.TotalTime
+	}
+	flat.HiddenRoots, flat.HiddenRootsTime = flattenCallTreeLevel(&flat, roots, opts, 0, -1)
+	return flat
+}
+
+// flattenCallTreeLevel appends one level of siblings and their visible descendants,
+// returning how many siblings were pruned and their total time
+func flattenCallTreeLevel(flat *FlatCallTree, nodes []*CallChainNode, opts CallTreeOptions, level, parent int) (int, float64) {
+	percentage := func(t float64) float64 {
+		if flat.TotalTime > 0 {
+			return (t / flat.TotalTime) * 100.0
+		}
+		return 0
+	}
+
+	hiddenCount := 0
+	hiddenTime := 0.0
+	for i, node := range nodes {
+		pct := percentage(node.TotalTime)
+		if pct < opts.MinPercent || (opts.MaxChildren > 0 && i >= opts.MaxChildren) {
+			hiddenCount++
+			hiddenTime += node.TotalTime
+			continue
+		}
+
+		rowIdx := len(flat.Rows)
+		flat.Rows = append(flat.Rows, CallTreeRow{
+			Depth:           level,
+			Parent:          parent,
+			Function:        node.Function,
+			Module:          node.Module,
+			TotalTime:       node.TotalTime,
+			SelfTime:        node.SelfTime,
+			TotalPercentage: pct,
+			SelfPercentage:  percentage(node.SelfTime),
+			SampleCount:     node.SampleCount,
+		})
+
+		if len(node.Children) == 0 {
+			continue
+		}
+		if opts.MaxDepth > 0 && level+1 >= opts.MaxDepth {
+			row := &flat.Rows[rowIdx]
+			row.HiddenChildren = len(node.Children)
+			row.HiddenByMaxDepth = true
+			for _, child := range node.Children {
+				row.HiddenChildrenTime += child.TotalTime
+			}
+			continue
+		}
+		count, t := flattenCallTreeLevel(flat, SortedCallChainNodes(node.Children), opts, level+1, rowIdx)
+		flat.Rows[rowIdx].HiddenChildren = count
+		flat.Rows[rowIdx].HiddenChildrenTime = t
+	}
+	return hiddenCount, hiddenTime
+}
@@ -0,0 +1,253 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// Delta statuses
+const (
+	DeltaChanged = "changed" // Present in both profiles
+	DeltaNew     = "new"     // Only present in the current profile
+	DeltaGone    = "gone"    // Only present in the base profile
+)
+
+// Rank orders accepted by SortFunctionDeltas and SortModuleDeltas
+const (
+	RankByAbsolute = "absolute" // Largest change in percentage points first
+	RankByRelative = "relative" // Largest change relative to the base first
+)
+
+// FunctionDelta compares a function between a base and a current profile.
+// Percentages are relative to each profile's own total time, so runs of different
+// lengths can be compared; deltas are in percentage points.
+type FunctionDelta struct {
+	Function                   string  `json:"function"`
+	Module                     string  `json:"module"`
+	Status                     string  `json:"status"` // DeltaChanged, DeltaNew or DeltaGone
+	BaseSelfTime               float64 `json:"base_self_time"`
+	CurrentSelfTime            float64 `json:"current_self_time"`
+	BaseInclusiveTime          float64 `json:"base_inclusive_time"`
+	CurrentInclusiveTime       float64 `json:"current_inclusive_time"`
+	BaseSelfPercentage         float64 `json:"base_self_percentage"`
+	CurrentSelfPercentage      float64 `json:"current_self_percentage"`
+	BaseInclusivePercentage    float64 `json:"base_inclusive_percentage"`
+	CurrentInclusivePercentage float64 `json:"current_inclusive_percentage"`
+	SelfDelta                  float64 `json:"self_delta"`                // CurrentSelfPercentage - BaseSelfPercentage
+	InclusiveDelta             float64 `json:"inclusive_delta"`           // CurrentInclusivePercentage - BaseInclusivePercentage
+	SelfRelativeChange         float64 `json:"self_relative_change"`      // SelfDelta as a percentage of BaseSelfPercentage (0 if no base)
+	InclusiveRelativeChange    float64 `json:"inclusive_relative_change"` // InclusiveDelta as a percentage of BaseInclusivePercentage (0 if no base)
+}
+
+// ModuleDelta compares a module between a base and a current profile
+type ModuleDelta struct {
+	Module                     string  `json:"module"`
+	Status                     string  `json:"status"`
+	BaseSelfPercentage         float64 `json:"base_self_percentage"`
+	CurrentSelfPercentage      float64 `json:"current_self_percentage"`
+	BaseInclusivePercentage    float64 `json:"base_inclusive_percentage"`
+	CurrentInclusivePercentage float64 `json:"current_inclusive_percentage"`
+	SelfDelta                  float64 `json:"self_delta"`
+	InclusiveDelta             float64 `json:"inclusive_delta"`
+	SelfRelativeChange         float64 `json:"self_relative_change"`
+	InclusiveRelativeChange    float64 `json:"inclusive_relative_change"`
+}
+
+// ProfileDiff holds per-function and per-module differences between two profiles
+type ProfileDiff struct {
+	BaseTotalTime    float64         `json:"base_total_time"`
+	CurrentTotalTime float64         `json:"current_total_time"`
+	Functions        []FunctionDelta `json:"functions"`
+	Modules          []ModuleDelta   `json:"modules"`
+}
+
+// DiffProfiles compares current against base. Functions and modules are sorted
+// by absolute change in self time; use SortFunctionDeltas / SortModuleDeltas to re-rank.
+func DiffProfiles(base, current *sleepy.ProfileData) ProfileDiff {
+	diff := ProfileDiff{}
+
+	baseHotspots := FindHotspots(base, 0, SortBySelf)
+	currentHotspots := FindHotspots(current, 0, SortBySelf)
+
+	deltas := make(map[string]*FunctionDelta)
+	for _, hs := range baseHotspots {
+		deltas[fmt.Sprintf("%s!%s", hs.Module, hs.Function)] = &FunctionDelta{
+			Function:                hs.Function,
+			Module:                  hs.Module,
+			Status:                  DeltaGone,
+			BaseSelfTime:            hs.SelfTime,
+			BaseInclusiveTime:       hs.InclusiveTime,
+			BaseSelfPercentage:      hs.SelfPercentage,
+			BaseInclusivePercentage: hs.InclusivePercentage,
+		}
+	}
+	for _, hs := range currentHotspots {
+		funcSig := fmt.Sprintf("%s!%s", hs.Module, hs.Function)
+		d, exists := deltas[funcSig]
+		if exists {
+			d.Status = DeltaChanged
+		} else {
+			d = &FunctionDelta{
+				Function: hs.Function,
+				Module:   hs.Module,
+				Status:   DeltaNew,
+			}
+			deltas[funcSig] = d
+		}
+		d.CurrentSelfTime = hs.SelfTime
+		d.CurrentInclusiveTime = hs.InclusiveTime
+		d.CurrentSelfPercentage = hs.SelfPercentage
+		d.CurrentInclusivePercentage = hs.InclusivePercentage
+	}
+
+	diff.Functions = make([]FunctionDelta, 0, len(deltas))
+	for _, d := range deltas {
+		d.SelfDelta = d.CurrentSelfPercentage - d.BaseSelfPercentage
+		d.InclusiveDelta = d.CurrentInclusivePercentage - d.BaseInclusivePercentage
+		d.SelfRelativeChange = relativeChange(d.SelfDelta, d.BaseSelfPercentage)
+		d.InclusiveRelativeChange = relativeChange(d.InclusiveDelta, d.BaseInclusivePercentage)
+		diff.Functions = append(diff.Functions, *d)
+	}
+
+	baseSelf, baseIncl, baseTotal := modulePercentages(base)
+	currentSelf, currentIncl, currentTotal := modulePercentages(current)
+	diff.BaseTotalTime = baseTotal
+	diff.CurrentTotalTime = currentTotal
+
+	modules := make(map[string]*ModuleDelta)
+	for module := range baseIncl {
+		modules[module] = &ModuleDelta{Module: module, Status: DeltaGone}
+	}
+	for module := range currentIncl {
+		if m, exists := modules[module]; exists {
+			m.Status = DeltaChanged
+		} else {
+			modules[module] = &ModuleDelta{Module: module, Status: DeltaNew}
+		}
+	}
+
+	diff.Modules = make([]ModuleDelta, 0, len(modules))
+	for module, m := range modules {
+		m.BaseSelfPercentage = baseSelf[module]
+		m.CurrentSelfPercentage = currentSelf[module]
+		m.BaseInclusivePercentage = baseIncl[module]
+		m.CurrentInclusivePercentage = currentIncl[module]
+		m.SelfDelta = m.CurrentSelfPercentage - m.BaseSelfPercentage
+		m.InclusiveDelta = m.CurrentInclusivePercentage - m.BaseInclusivePercentage
+		m.SelfRelativeChange = relativeChange(m.SelfDelta, m.BaseSelfPercentage)
+		m.InclusiveRelativeChange = relativeChange(m.InclusiveDelta, m.BaseInclusivePercentage)
+		diff.Modules = append(diff.Modules, *m)
+	}
+
+	SortFunctionDeltas(diff.Functions, SortBySelf, RankByAbsolute)
+	SortModuleDeltas(diff.Modules, SortBySelf, RankByAbsolute)
+
+	return diff
+}
+
+// modulePercentages returns per-module self and inclusive time as percentages of the
+// profile's total time, plus the total time itself
+func modulePercentages(profile *sleepy.ProfileData) (map[string]float64, map[string]float64, float64) {
+	ix := profile.Index()
+	selfTime := make(map[string]float64)
+	totalTime := ix.TotalTime
+
+	for csIdx, stack := range ix.Stacks {
+		if len(stack) == 0 {
+			continue
+		}
+		selfTime[moduleName(ix.Modules[ix.Functions[stack[0]].ModuleID])] += ix.Durations[csIdx]
+	}
+
+	inclusiveTime := FindModuleHotspots(profile)
+
+	if totalTime > 0 {
+		for module := range selfTime {
+			selfTime[module] = (selfTime[module] / totalTime) * 100.0
+		}
+		for module := range inclusiveTime {
+			inclusiveTime[module] = (inclusiveTime[module] / totalTime) * 100.0
+		}
+	}
+
+	return selfTime, inclusiveTime, totalTime
+}
+
+// moduleName maps unresolved modules to the name FindModuleHotspots uses
+func moduleName(module string) string {
+	if module == "" || module == "?" {
+		return "[unknown]"
+	}
+	return module
+}
+
+// relativeChange returns delta as a percentage of base, or 0 when there is no base
+func relativeChange(delta, base float64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return (delta / base) * 100.0
+}
+
+// deltaRankKey returns the value a delta is ranked by. Ranked relative to the base, a
+// metric that grew from nothing (a new function, or a function that only gained time
+// in this metric) ranks above any relative change, since that change is unbounded.
+// A metric that is zero in both profiles does not get that boost.
+func deltaRankKey(delta, base, relative float64, rankBy string) float64 {
+	if rankBy != RankByRelative {
+		return math.Abs(delta)
+	}
+	if base == 0 && delta > 0 {
+		return math.MaxFloat64
+	}
+	return math.Abs(relative)
+}
+
+// SortFunctionDeltas ranks function deltas (descending) by self or inclusive change,
+// measured in absolute percentage points or relative to the base. Ties, such as
+// functions that grew from nothing, are broken by the absolute change.
+func SortFunctionDeltas(deltas []FunctionDelta, sortBy, rankBy string) {
+	key := func(d FunctionDelta) (float64, float64) {
+		if sortBy == SortByInclusive {
+			return deltaRankKey(d.InclusiveDelta, d.BaseInclusivePercentage, d.InclusiveRelativeChange, rankBy), math.Abs(d.InclusiveDelta)
+		}
+		return deltaRankKey(d.SelfDelta, d.BaseSelfPercentage, d.SelfRelativeChange, rankBy), math.Abs(d.SelfDelta)
+	}
+
+	sort.Slice(deltas, func(i, j int) bool {
+		ki, ai := key(deltas[i])
+		kj, aj := key(deltas[j])
+		if ki != kj {
+			return ki > kj
+		}
+		if ai != aj {
+			return ai > aj
+		}
+		return deltas[i].Module+"!"+deltas[i].Function < deltas[j].Module+"!"+deltas[j].Function
+	})
+}
+
+// SortModuleDeltas ranks module deltas like SortFunctionDeltas
+func SortModuleDeltas(deltas []ModuleDelta, sortBy, rankBy string) {
+	key := func(d ModuleDelta) (float64, float64) {
+		if sortBy == SortByInclusive {
+			return deltaRankKey(d.InclusiveDelta, d.BaseInclusivePercentage, d.InclusiveRelativeChange, rankBy), math.Abs(d.InclusiveDelta)
+		}
+		return deltaRankKey(d.SelfDelta, d.BaseSelfPercentage, d.SelfRelativeChange, rankBy), math.Abs(d.SelfDelta)
+	}
+
+	sort.Slice(deltas, func(i, j int) bool {
+		ki, ai := key(deltas[i])
+		kj, aj := key(deltas[j])
+		if ki != kj {
+			return ki > kj
+		}
+		if ai != aj {
+			return ai > aj
+		}
+		return deltas[i].Module < deltas[j].Module
+	})
+}
@@ -0,0 +1,122 @@
+package analyzer
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSortFunctionDeltas(t *testing.T) {
+	deltas := []FunctionDelta{
+		// Regressed from 10% to 15% self
+		{Function: "regressed", Status: DeltaChanged, BaseSelfPercentage: 10, CurrentSelfPercentage: 15, SelfDelta: 5, SelfRelativeChange: 50,
+			BaseInclusivePercentage: 20, CurrentInclusivePercentage: 25, InclusiveDelta: 5, InclusiveRelativeChange: 25},
+		// New, but only as a caller: no self time
+		{Function: "newCaller", Status: DeltaNew, CurrentInclusivePercentage: 30, InclusiveDelta: 30},
+		// New with self time
+		{Function: "newLeaf", Status: DeltaNew, CurrentSelfPercentage: 2, SelfDelta: 2, CurrentInclusivePercentage: 2, InclusiveDelta: 2},
+		// Was only a caller, now also has self time
+		{Function: "gainedSelf", Status: DeltaChanged, CurrentSelfPercentage: 1, SelfDelta: 1,
+			BaseInclusivePercentage: 40, CurrentInclusivePercentage: 41, InclusiveDelta: 1, InclusiveRelativeChange: 2.5},
+		// Dropped from 8% to 2% self
+		{Function: "improved", Status: DeltaChanged, BaseSelfPercentage: 8, CurrentSelfPercentage: 2, SelfDelta: -6, SelfRelativeChange: -75,
+			BaseInclusivePercentage: 8, CurrentInclusivePercentage: 2, InclusiveDelta: -6, InclusiveRelativeChange: -75},
+	}
+
+	tests := []struct {
+		sortBy, rankBy string
+		want           []string
+	}{
+		{SortBySelf, RankByAbsolute, []string{"improved", "regressed", "newLeaf", "gainedSelf", "newCaller"}},
+		{SortBySelf, RankByRelative, []string{"newLeaf", "gainedSelf", "improved", "regressed", "newCaller"}},
+		{SortByInclusive, RankByAbsolute, []string{"newCaller", "improved", "regressed", "newLeaf", "gainedSelf"}},
+		{SortByInclusive, RankByRelative, []string{"newCaller", "newLeaf", "improved", "regressed", "gainedSelf"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sortBy+"/"+tt.rankBy, func(t *testing.T) {
+			sorted := append([]FunctionDelta(nil), deltas...)
+			SortFunctionDeltas(sorted, tt.sortBy, tt.rankBy)
+
+			var got []string
+			for _, d := range sorted {
+				got = append(got, d.Function)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortModuleDeltasRelative(t *testing.T) {
+	deltas := []ModuleDelta{
+		{Module: "grew", Status: DeltaChanged, BaseSelfPercentage: 10, SelfDelta: 5, SelfRelativeChange: 50},
+		{Module: "callerOnly", Status: DeltaNew, InclusiveDelta: 20},
+		{Module: "fromNothing", Status: DeltaChanged, SelfDelta: 0.5},
+	}
+	SortModuleDeltas(deltas, SortBySelf, RankByRelative)
+
+	var got []string
+	for _, m := range deltas {
+		got = append(got, m.Module)
+	}
+	if want := []string{"fromNothing", "grew", "callerOnly"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestDiffProfiles(t *testing.T) {
+	base := testProfile()
+	current, err := FilterProfile(base, FilterOptions{Ignore: "render"})
+	if err != nil {
+		t.Fatalf("FilterProfile() error = %v", err)
+	}
+	current = withUnknownFrame(current)
+	diff := DiffProfiles(base, current)
+
+	if diff.BaseTotalTime != 1 || diff.CurrentTotalTime != 1.125 {
+		t.Errorf("total times = %v/%v, want 1/1.125", diff.BaseTotalTime, diff.CurrentTotalTime)
+	}
+
+	// Percentages are of each profile's own total: parse goes from 0.375 of 1s to 0.375 of 1.125s
+	type want struct {
+		status               string
+		selfDelta, inclDelta float64
+	}
+	wantFunctions := map[string]want{
+		"app!main":    {DeltaChanged, 0, 0},
+		"app!parse":   {DeltaChanged, 100.0/3 - 37.5, 100 - 62.5},
+		"libc!malloc": {DeltaChanged, 100.0*0.25/1.125 - 37.5, 100.0*0.25/1.125 - 37.5},
+		"libc!memcpy": {DeltaGone, -25, -25},
+		"app!render":  {DeltaGone, 0, -37.5},
+		"?![0x999]":   {DeltaNew, 100.0 * 0.5 / 1.125, 100.0 * 0.5 / 1.125},
+	}
+	if len(diff.Functions) != len(wantFunctions) {
+		t.Errorf("got %d functions, want %d: %+v", len(diff.Functions), len(wantFunctions), diff.Functions)
+	}
+	for _, d := range diff.Functions {
+		funcSig := d.Module + "!" + d.Function
+		w := wantFunctions[funcSig]
+		if d.Status != w.status || math.Abs(d.SelfDelta-w.selfDelta) > 1e-9 || math.Abs(d.InclusiveDelta-w.inclDelta) > 1e-9 {
+			t.Errorf("%s = %s %v/%v, want %s %v/%v", funcSig, d.Status, d.SelfDelta, d.InclusiveDelta, w.status, w.selfDelta, w.inclDelta)
+		}
+	}
+	if top := diff.Functions[0]; top.Function != "[0x999]" {
+		t.Errorf("largest self change = %s!%s, want ?![0x999]", top.Module, top.Function)
+	}
+
+	wantModules := map[string]want{
+		"app":       {DeltaChanged, 100.0/3 - 37.5, 0},
+		"libc":      {DeltaChanged, 100.0*0.25/1.125 - 62.5, 100.0*0.25/1.125 - 62.5},
+		"[unknown]": {DeltaNew, 100.0 * 0.5 / 1.125, 100.0 * 0.5 / 1.125},
+	}
+	if len(diff.Modules) != len(wantModules) {
+		t.Errorf("got %d modules, want %d: %+v", len(diff.Modules), len(wantModules), diff.Modules)
+	}
+	for _, m := range diff.Modules {
+		w := wantModules[m.Module]
+		if m.Status != w.status || math.Abs(m.SelfDelta-w.selfDelta) > 1e-9 || math.Abs(m.InclusiveDelta-w.inclDelta) > 1e-9 {
+			t.Errorf("%s = %s %v/%v, want %s %v/%v", m.Module, m.Status, m.SelfDelta, m.InclusiveDelta, w.status, w.selfDelta, w.inclDelta)
+		}
+	}
+}
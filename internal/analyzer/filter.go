@@ -0,0 +1,181 @@
+package analyzer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// FilterOptions selects which callstacks and frames an analysis sees.
+// Frame filters are regular expressions matched against Module!Function; empty fields are ignored.
+type FilterOptions struct {
+	Focus     string // Keep only callstacks containing a matching frame
+	Ignore    string // Drop callstacks containing a matching frame
+	Hide      string // Remove matching frames but keep the callstack's time
+	PruneFrom string // Drop the frames called from the outermost matching frame
+	Thread    string // Keep only time from this thread: a numeric thread ID or a regex matched against thread names
+}
+
+// IsEmpty reports whether no filter is set
+func (opts FilterOptions) IsEmpty() bool {
+	return opts.Focus == "" && opts.Ignore == "" && opts.Hide == "" && opts.PruneFrom == "" && opts.Thread == ""
+}
+
+// newThreadMatcher returns a predicate over thread IDs for a numeric ID or a thread name regex
+func newThreadMatcher(profile *sleepy.ProfileData, thread string) (func(int) bool, error) {
+	if thread == "" {
+		return nil, nil
+	}
+
+	if threadID, err := strconv.Atoi(thread); err == nil {
+		return func(id int) bool { return id == threadID }, nil
+	}
+
+	re, err := regexp.Compile(thread)
+	if err != nil {
+		return nil, fmt.Errorf("invalid thread pattern %q: %w", thread, err)
+	}
+
+	matched := make(map[int]bool)
+	for _, t := range profile.Threads {
+		if re.MatchString(t.Name) {
+			matched[t.ID] = true
+		}
+	}
+	return func(id int) bool { return matched[id] }, nil
+}
+
+// frameMatcher matches addresses against a Module!Function regular expression,
+// caching the result per function since the same functions recur across callstacks
+type frameMatcher struct {
+	profile *sleepy.ProfileData
+	index   *sleepy.ProfileIndex
+	re      *regexp.Regexp
+	cache   map[int]bool
+}
+
+func newFrameMatcher(profile *sleepy.ProfileData, name, pattern string) (*frameMatcher, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s pattern %q: %w", name, pattern, err)
+	}
+
+	return &frameMatcher{
+		profile: profile,
+		index:   profile.Index(),
+		re:      re,
+		cache:   make(map[int]bool),
+	}, nil
+}
+
+func (m *frameMatcher) matches(addr uint64) bool {
+	funcID := m.index.FunctionAt(addr)
+	if funcID < 0 {
+		frame := m.profile.ResolveAddress(addr)
+		return m.re.MatchString(fmt.Sprintf("%s!%s", frame.Module, frame.Function))
+	}
+
+	if matched, exists := m.cache[funcID]; exists {
+		return matched
+	}
+	matched := m.re.MatchString(m.index.Functions[funcID].Signature)
+	m.cache[funcID] = matched
+	return matched
+}
+
+func (m *frameMatcher) matchesAny(addresses []uint64) bool {
+	for _, addr := range addresses {
+		if m.matches(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterProfile returns a derived profile containing only the callstacks and frames
+// selected by opts. The thread filter, focus and ignore are applied first, then hide, then prune_from.
+// The original profile is not modified; with no filters set it is returned as is.
+func FilterProfile(profile *sleepy.ProfileData, opts FilterOptions) (*sleepy.ProfileData, error) {
+	if opts.IsEmpty() {
+		return profile, nil
+	}
+
+	focus, err := newFrameMatcher(profile, "focus", opts.Focus)
+	if err != nil {
+		return nil, err
+	}
+	ignore, err := newFrameMatcher(profile, "ignore", opts.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	hide, err := newFrameMatcher(profile, "hide", opts.Hide)
+	if err != nil {
+		return nil, err
+	}
+	pruneFrom, err := newFrameMatcher(profile, "prune_from", opts.PruneFrom)
+	if err != nil {
+		return nil, err
+	}
+	threadMatches, err := newThreadMatcher(profile, opts.Thread)
+	if err != nil {
+		return nil, err
+	}
+
+	callstacks := make([]sleepy.Callstack, 0, len(profile.Callstacks))
+	for _, cs := range profile.Callstacks {
+		threadCounts := cs.ThreadCounts
+		if threadMatches != nil {
+			threadCounts = make(map[int]float64)
+			for threadID, d := range cs.ThreadCounts {
+				if threadMatches(threadID) {
+					threadCounts[threadID] = d
+				}
+			}
+			if len(threadCounts) == 0 {
+				continue
+			}
+		}
+
+		if focus != nil && !focus.matchesAny(cs.Addresses) {
+			continue
+		}
+		if ignore != nil && ignore.matchesAny(cs.Addresses) {
+			continue
+		}
+
+		addresses := cs.Addresses
+
+		if hide != nil {
+			kept := make([]uint64, 0, len(addresses))
+			for _, addr := range addresses {
+				if !hide.matches(addr) {
+					kept = append(kept, addr)
+				}
+			}
+			addresses = kept
+		}
+
+		// Addresses are leaf-first: scan from the root and cut everything the match calls
+		if pruneFrom != nil {
+			for i := len(addresses) - 1; i >= 0; i-- {
+				if pruneFrom.matches(addresses[i]) {
+					addresses = addresses[i:]
+					break
+				}
+			}
+		}
+
+		callstacks = append(callstacks, sleepy.Callstack{
+			Addresses:    addresses,
+			ThreadCounts: threadCounts,
+		})
+	}
+
+	return profile.WithCallstacks(callstacks), nil
+}
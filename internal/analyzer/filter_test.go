@@ -0,0 +1,139 @@
+package analyzer
+
+import "testing"
+
+func TestFilterProfile(t *testing.T) {
+	tests := []struct {
+		name string
+		opts FilterOptions
+		want map[string]float64
+	}{
+		{
+			name: "no filter",
+			opts: FilterOptions{},
+			want: stackTimes(testProfile()),
+		},
+		{
+			name: "thread ID",
+			opts: FilterOptions{Thread: "1"},
+			want: map[string]float64{
+				"1|libc!malloc;app!parse;app!main":  0.25,
+				"1|libc!memcpy;app!render;app!main": 0.125,
+				"1|app!parse;app!parse;app!main":    0.125,
+			},
+		},
+		{
+			name: "thread name",
+			opts: FilterOptions{Thread: "^work"},
+			want: map[string]float64{
+				"2|libc!memcpy;app!render;app!main": 0.125,
+				"2|app!parse;app!main":              0.25,
+				"2|libc!malloc;app!render;app!main": 0.125,
+			},
+		},
+		{
+			name: "focus",
+			opts: FilterOptions{Focus: "libc!malloc"},
+			want: map[string]float64{
+				"1|libc!malloc;app!parse;app!main":  0.25,
+				"2|libc!malloc;app!render;app!main": 0.125,
+			},
+		},
+		{
+			name: "ignore",
+			opts: FilterOptions{Ignore: "render"},
+			want: map[string]float64{
+				"1|libc!malloc;app!parse;app!main": 0.25,
+				"2|app!parse;app!main":             0.25,
+				"1|app!parse;app!parse;app!main":   0.125,
+			},
+		},
+		{
+			name: "hide",
+			opts: FilterOptions{Hide: "app!parse"},
+			want: map[string]float64{
+				"1|libc!malloc;app!main":            0.25,
+				"1|libc!memcpy;app!render;app!main": 0.125,
+				"2|libc!memcpy;app!render;app!main": 0.125,
+				"2|app!main":                        0.25,
+				"2|libc!malloc;app!render;app!main": 0.125,
+				"1|app!main":                        0.125,
+			},
+		},
+		{
+			name: "prune_from",
+			opts: FilterOptions{PruneFrom: "app!render"},
+			want: map[string]float64{
+				"1|libc!malloc;app!parse;app!main": 0.25,
+				"1|app!render;app!main":            0.125,
+				"2|app!render;app!main":            0.25,
+				"2|app!parse;app!main":             0.25,
+				"1|app!parse;app!parse;app!main":   0.125,
+			},
+		},
+		{
+			// prune_from cuts below the outermost match of a recursive function
+			name: "prune_from recursion",
+			opts: FilterOptions{PruneFrom: "app!parse"},
+			want: map[string]float64{
+				"1|app!parse;app!main":              0.375,
+				"1|libc!memcpy;app!render;app!main": 0.125,
+				"2|libc!memcpy;app!render;app!main": 0.125,
+				"2|app!parse;app!main":              0.25,
+				"2|libc!malloc;app!render;app!main": 0.125,
+			},
+		},
+		{
+			name: "thread and focus",
+			opts: FilterOptions{Thread: "2", Focus: "render"},
+			want: map[string]float64{
+				"2|libc!memcpy;app!render;app!main": 0.125,
+				"2|libc!malloc;app!render;app!main": 0.125,
+			},
+		},
+		{
+			// hide applies after focus, so the hidden frame still selects callstacks
+			name: "focus and hide",
+			opts: FilterOptions{Focus: "parse", Hide: "parse"},
+			want: map[string]float64{
+				"1|libc!malloc;app!main": 0.25,
+				"2|app!main":             0.25,
+				"1|app!main":             0.125,
+			},
+		},
+		{
+			name: "no match",
+			opts: FilterOptions{Thread: "3"},
+			want: map[string]float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := testProfile()
+			before := stackTimes(profile)
+
+			filtered, err := FilterProfile(profile, tt.opts)
+			if err != nil {
+				t.Fatalf("FilterProfile() error = %v", err)
+			}
+			assertSameTimes(t, "stack", stackTimes(filtered), tt.want)
+			assertSameTimes(t, "original stack", stackTimes(profile), before)
+		})
+	}
+}
+
+func TestFilterProfileInvalidPattern(t *testing.T) {
+	tests := []FilterOptions{
+		{Focus: "("},
+		{Ignore: "("},
+		{Hide: "("},
+		{PruneFrom: "("},
+		{Thread: "("},
+	}
+	for _, opts := range tests {
+		if _, err := FilterProfile(testProfile(), opts); err == nil {
+			t.Errorf("FilterProfile(%+v) error = nil, want an error", opts)
+		}
+	}
+}
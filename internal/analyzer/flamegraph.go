@@ -0,0 +1,452 @@
+package analyzer
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"math"
+	"sort"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// FlameGraphOptions controls how a flame graph is rendered
+type FlameGraphOptions struct {
+	Title    string  // Title shown above the graph (default: "Flame Graph")
+	Subtitle string  // Optional second line under the title
+	Width    int     // Image width in pixels (default: 1200, minimum: 200)
+	Icicle   bool    // Draw roots at the top (icicle graph) instead of the bottom
+	MinWidth float64 // Omit frames narrower than this many pixels (default: 0.1)
+}
+
+// Flame graph geometry, in pixels
+const (
+	flameFrameHeight = 16
+	flameFontSize    = 12
+	flameFontWidth   = 0.59 // Average glyph width as a fraction of the font size
+	flamePadTop      = 56
+	flamePadBottom   = 34
+	flamePadSide     = 10
+)
+
+// flameFrame is a single laid-out rectangle of a flame graph
+type flameFrame struct {
+	Name       string // Module!Function, or "all" for the synthetic root
+	Path       string // Signatures from the root to this frame, identifying it across trees
+	Depth      int
+	X          float64 // Offset from the left edge of the graph area
+	Width      float64
+	TotalTime  float64
+	SelfTime   float64
+	Percentage float64 // Inclusive time as a percentage of the whole graph
+	Fill       string
+	Info       string // Tooltip and details line
+}
+
+// flameLayout is a fully laid-out flame graph ready to be written as SVG
+type flameLayout struct {
+	Frames     []flameFrame
+	MaxDepth   int
+	TotalTime  float64
+	GraphWidth float64
+}
+
+// WriteProfileFlameGraph renders the profile's top-down call tree as a flame graph SVG
+func WriteProfileFlameGraph(w io.Writer, profile *sleepy.ProfileData, opts FlameGraphOptions) error {
+	return WriteFlameGraph(w, AnalyzeCallChains(profile, 0), opts)
+}
+
+// WriteFlameGraph renders a call tree (as built by AnalyzeCallChains) as a self-contained,
+// interactive flame graph SVG with hover details, regex search and click-to-zoom
+func WriteFlameGraph(w io.Writer, tree map[string]*CallChainNode, opts FlameGraphOptions) error {
+	opts = flameGraphDefaults(opts)
+	layout := layoutFlameGraph(tree, opts)
+
+	for i := range layout.Frames {
+		f := &layout.Frames[i]
+		f.Fill = flameColor(f.Name)
+		f.Info = fmt.Sprintf("%s (%.6f s, %.2f%%; self %.6f s)", f.Name, f.TotalTime, f.Percentage, f.SelfTime)
+	}
+
+	return writeFlameGraphSVG(w, layout, opts)
+}
+
+func flameGraphDefaults(opts FlameGraphOptions) FlameGraphOptions {
+	if opts.Title == "" {
+		opts.Title = "Flame Graph"
+	}
+	if opts.Width <= 0 {
+		opts.Width = 1200
+	} else if opts.Width < 200 {
+		opts.Width = 200
+	}
+	if opts.MinWidth <= 0 {
+		opts.MinWidth = 0.1
+	}
+	return opts
+}
+
+// layoutFlameGraph positions every node of the tree under a synthetic "all" root.
+// Siblings are ordered alphabetically, as in flamegraph.pl, so that graphs of
+// similar profiles line up.
+func layoutFlameGraph(tree map[string]*CallChainNode, opts FlameGraphOptions) flameLayout {
+	layout := flameLayout{
+		GraphWidth: float64(opts.Width - 2*flamePadSide),
+	}
+
+	roots := make([]*CallChainNode, 0, len(tree))
+	for _, node := range tree {
+		roots = append(roots, node)
+		layout.TotalTime += node.TotalTime
+	}
+
+	layout.Frames = append(layout.Frames, flameFrame{
+		Name:       "all",
+		Width:      layout.GraphWidth,
+		TotalTime:  layout.TotalTime,
+		Percentage: 100.0,
+	})
+	if layout.TotalTime <= 0 {
+		return layout
+	}
+
+	scale := layout.GraphWidth / layout.TotalTime
+
+	var place func(nodes []*CallChainNode, parentPath string, depth int, x float64)
+	place = func(nodes []*CallChainNode, parentPath string, depth int, x float64) {
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i].Module+"!"+nodes[i].Function < nodes[j].Module+"!"+nodes[j].Function
+		})
+
+		for _, node := range nodes {
+			width := node.TotalTime * scale
+			if width < opts.MinWidth {
+				x += width
+				continue
+			}
+
+			name := fmt.Sprintf("%s!%s", node.Module, node.Function)
+			path := parentPath + "\x00" + name
+			layout.Frames = append(layout.Frames, flameFrame{
+				Name:       name,
+				Path:       path,
+				Depth:      depth,
+				X:          x,
+				Width:      width,
+				TotalTime:  node.TotalTime,
+				SelfTime:   node.SelfTime,
+				Percentage: (node.TotalTime / layout.TotalTime) * 100.0,
+			})
+			if depth > layout.MaxDepth {
+				layout.MaxDepth = depth
+			}
+
+			children := make([]*CallChainNode, len(node.Children))
+			copy(children, node.Children)
+			place(children, path, depth+1, x)
+
+			x += width
+		}
+	}
+	place(roots, "", 1, 0)
+
+	return layout
+}
+
+// DiffFlameGraphOptions controls how a differential flame graph is rendered
+type DiffFlameGraphOptions struct {
+	FlameGraphOptions
+	DiffBy         string // Color by change in SortBySelf (default) or SortByInclusive time
+	LayoutFromBase bool   // Lay out the base profile instead of the current one, to show removed code
+}
+
+// WriteDiffFlameGraph renders a red/blue differential flame graph. Frames are laid out
+// from the current profile (or the base, with LayoutFromBase) and colored by how much
+// each frame's share of total time changed from base to current: red frames grew,
+// blue frames shrank, and the color saturates at the largest change in the graph.
+func WriteDiffFlameGraph(w io.Writer, base, current *sleepy.ProfileData, opts DiffFlameGraphOptions) error {
+	flameOpts := flameGraphDefaults(opts.FlameGraphOptions)
+
+	baseTree := AnalyzeCallChains(base, 0)
+	currentTree := AnalyzeCallChains(current, 0)
+
+	layoutTree := currentTree
+	if opts.LayoutFromBase {
+		layoutTree = baseTree
+	}
+	layout := layoutFlameGraph(layoutTree, flameOpts)
+
+	basePaths, baseTotal := callTreePaths(baseTree)
+	currentPaths, currentTotal := callTreePaths(currentTree)
+
+	// share returns a frame's self or inclusive time as a percentage of its profile's total
+	share := func(paths map[string]*CallChainNode, total float64, path string) float64 {
+		node, exists := paths[path]
+		if !exists || total <= 0 {
+			return 0
+		}
+		if opts.DiffBy == SortByInclusive {
+			return (node.TotalTime / total) * 100.0
+		}
+		return (node.SelfTime / total) * 100.0
+	}
+
+	deltas := make([]float64, len(layout.Frames))
+	maxDelta := 0.0
+	for i, f := range layout.Frames {
+		if f.Path == "" {
+			continue // Synthetic root is 100% in both profiles
+		}
+		deltas[i] = share(currentPaths, currentTotal, f.Path) - share(basePaths, baseTotal, f.Path)
+		if math.Abs(deltas[i]) > maxDelta {
+			maxDelta = math.Abs(deltas[i])
+		}
+	}
+
+	metric := SortBySelf
+	if opts.DiffBy == SortByInclusive {
+		metric = SortByInclusive
+	}
+	for i := range layout.Frames {
+		f := &layout.Frames[i]
+		f.Fill = diffFlameColor(deltas[i], maxDelta)
+		if f.Path == "" {
+			f.Info = fmt.Sprintf("all (base %.6f s, current %.6f s)", baseTotal, currentTotal)
+			continue
+		}
+		f.Info = fmt.Sprintf("%s (base %.2f%%, current %.2f%% %s; %+.2f pp)",
+			f.Name, share(basePaths, baseTotal, f.Path), share(currentPaths, currentTotal, f.Path), metric, deltas[i])
+	}
+
+	return writeFlameGraphSVG(w, layout, flameOpts)
+}
+
+// callTreePaths indexes every node of a call tree by the same root-to-node path
+// layoutFlameGraph assigns, and returns the tree's total time
+func callTreePaths(tree map[string]*CallChainNode) (map[string]*CallChainNode, float64) {
+	paths := make(map[string]*CallChainNode)
+	total := 0.0
+
+	var walk func(node *CallChainNode, parentPath string)
+	walk = func(node *CallChainNode, parentPath string) {
+		path := parentPath + "\x00" + fmt.Sprintf("%s!%s", node.Module, node.Function)
+		paths[path] = node
+		for _, child := range node.Children {
+			walk(child, path)
+		}
+	}
+	for _, root := range tree {
+		total += root.TotalTime
+		walk(root, "")
+	}
+
+	return paths, total
+}
+
+// diffFlameColor shades growth red and reduction blue, relative to the largest change
+func diffFlameColor(delta, maxDelta float64) string {
+	if maxDelta <= 0 || delta == 0 {
+		return "rgb(250,250,250)"
+	}
+
+	k := int(210 * math.Abs(delta) / maxDelta)
+	if delta > 0 {
+		return fmt.Sprintf("rgb(255,%d,%d)", 250-k, 250-k)
+	}
+	return fmt.Sprintf("rgb(%d,%d,255)", 250-k, 250-k)
+}
+
+// flameColor picks a stable warm color for a frame name, like flamegraph.pl's "hot" palette
+func flameColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	v := h.Sum32()
+
+	v1 := float64(v&0xFF) / 255.0
+	v2 := float64((v>>8)&0xFF) / 255.0
+	v3 := float64((v>>16)&0xFF) / 255.0
+
+	return fmt.Sprintf("rgb(%d,%d,%d)", 205+int(50*v3), int(230*v1), int(55*v2))
+}
+
+// fitFlameLabel truncates name to fit into a frame of the given width
+func fitFlameLabel(name string, width float64) string {
+	maxChars := int((width - 6) / (flameFontSize * flameFontWidth))
+	if maxChars < 3 {
+		return ""
+	}
+	runes := []rune(name)
+	if len(runes) <= maxChars {
+		return name
+	}
+	return string(runes[:maxChars-2]) + ".."
+}
+
+// writeFlameGraphSVG writes a laid-out flame graph as a standalone SVG document
+func writeFlameGraphSVG(w io.Writer, layout flameLayout, opts FlameGraphOptions) error {
+	height := (layout.MaxDepth+1)*flameFrameHeight + flamePadTop + flamePadBottom
+	frameY := func(depth int) int {
+		if opts.Icicle {
+			return flamePadTop + depth*flameFrameHeight
+		}
+		return flamePadTop + (layout.MaxDepth-depth)*flameFrameHeight
+	}
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, `<?xml version="1.0" standalone="no"?>
+<svg version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg" onload="init()">
+<style type="text/css">
+	text { font-family: Verdana, sans-serif; font-size: %dpx; fill: rgb(0,0,0); }
+	#title { font-size: 17px; text-anchor: middle; }
+	#subtitle { text-anchor: middle; fill: rgb(100,100,100); }
+	.button { cursor: pointer; }
+	.button:hover { text-decoration: underline; }
+	.f { cursor: pointer; }
+	.f:hover rect { stroke: rgb(0,0,0); stroke-width: 0.5; }
+	.f text { pointer-events: none; }
+	.parent rect { opacity: 0.5; }
+</style>
+`, opts.Width, height, opts.Width, height, flameFontSize)
+
+	fmt.Fprintf(bw, `<script type="text/ecmascript"><![CDATA[%s]]></script>
+`, fmt.Sprintf(flameGraphScript, flamePadSide, layout.GraphWidth, flameFontSize*flameFontWidth))
+
+	fmt.Fprintf(bw, `<rect x="0" y="0" width="%d" height="%d" fill="rgb(248,248,248)"/>
+<text id="title" x="%d" y="24">%s</text>
+`, opts.Width, height, opts.Width/2, html.EscapeString(opts.Title))
+	if opts.Subtitle != "" {
+		fmt.Fprintf(bw, `<text id="subtitle" x="%d" y="42">%s</text>
+`, opts.Width/2, html.EscapeString(opts.Subtitle))
+	}
+	fmt.Fprintf(bw, `<text id="unzoom" class="button" x="%d" y="24" opacity="0">Reset Zoom</text>
+<text id="search" class="button" x="%d" y="24" text-anchor="end">Search</text>
+<text id="matched" x="%d" y="%d" text-anchor="end"> </text>
+<text id="details" x="%d" y="%d"> </text>
+`, flamePadSide, opts.Width-flamePadSide, opts.Width-flamePadSide, height-12, flamePadSide, height-12)
+
+	bw.WriteString("<g id=\"frames\">\n")
+	for _, f := range layout.Frames {
+		x := float64(flamePadSide) + f.X
+		y := frameY(f.Depth)
+		fmt.Fprintf(bw, `<g class="f" data-n="%s" data-info="%s" data-x="%.4f" data-w="%.4f" data-d="%d"><title>%s</title><rect x="%.4f" y="%d" width="%.4f" height="%d" fill="%s" data-fill="%s" rx="2" ry="2"/><text x="%.4f" y="%d">%s</text></g>
+`,
+			html.EscapeString(f.Name), html.EscapeString(f.Info), f.X, f.Width, f.Depth,
+			html.EscapeString(f.Info),
+			x, y, f.Width, flameFrameHeight-1, f.Fill, f.Fill,
+			x+3, y+flameFrameHeight-4, html.EscapeString(fitFlameLabel(f.Name, f.Width)))
+	}
+	bw.WriteString("</g>\n</svg>\n")
+
+	return bw.Flush()
+}
+
+// flameGraphScript implements hover details, click-to-zoom and regex search.
+// Format arguments: graph left padding, graph width, average glyph width.
+const flameGraphScript = `
+var pad = %d, graphWidth = %g, glyphWidth = %g;
+var frames, details, unzoomBtn, matchedText, searchTerm = null;
+
+function num(g, name) { return parseFloat(g.getAttribute(name)); }
+
+function init() {
+	frames = Array.prototype.slice.call(document.querySelectorAll("g.f"));
+	details = document.getElementById("details");
+	unzoomBtn = document.getElementById("unzoom");
+	matchedText = document.getElementById("matched");
+	frames.forEach(function (g) {
+		g.addEventListener("click", function () { zoom(g); });
+		g.addEventListener("mouseover", function () { details.textContent = g.getAttribute("data-info"); });
+		g.addEventListener("mouseout", function () { details.textContent = " "; });
+	});
+	unzoomBtn.addEventListener("click", unzoom);
+	document.getElementById("search").addEventListener("click", search);
+}
+
+function fit(name, w) {
+	var max = Math.floor((w - 6) / glyphWidth);
+	if (max < 3) return "";
+	return name.length <= max ? name : name.substring(0, max - 2) + "..";
+}
+
+function place(g, x, w) {
+	var rect = g.querySelector("rect"), text = g.querySelector("text");
+	g.style.display = "";
+	rect.setAttribute("x", x);
+	rect.setAttribute("width", w);
+	text.setAttribute("x", x + 3);
+	text.textContent = fit(g.getAttribute("data-n"), w);
+}
+
+function zoom(target) {
+	var zx = num(target, "data-x"), zw = num(target, "data-w"), zd = num(target, "data-d");
+	var scale = graphWidth / zw, eps = 1e-6;
+	frames.forEach(function (g) {
+		var x = num(g, "data-x"), w = num(g, "data-w"), d = num(g, "data-d");
+		if (d < zd) {
+			if (x <= zx + eps && x + w >= zx + zw - eps) {
+				g.classList.add("parent");
+				place(g, pad, graphWidth);
+			} else {
+				g.style.display = "none";
+			}
+		} else if (x >= zx - eps && x + w <= zx + zw + eps) {
+			g.classList.remove("parent");
+			place(g, pad + (x - zx) * scale, w * scale);
+		} else {
+			g.style.display = "none";
+		}
+	});
+	unzoomBtn.setAttribute("opacity", zd > 0 ? "1" : "0");
+}
+
+function unzoom() {
+	frames.forEach(function (g) {
+		g.classList.remove("parent");
+		place(g, pad + num(g, "data-x"), num(g, "data-w"));
+	});
+	unzoomBtn.setAttribute("opacity", "0");
+}
+
+function search() {
+	var term = prompt("Search for (regular expression):", searchTerm || "");
+	if (term === null) return;
+	var re = null;
+	if (term !== "") {
+		try { re = new RegExp(term); } catch (e) { alert(e); return; }
+	}
+	searchTerm = term || null;
+
+	var spans = [];
+	frames.forEach(function (g) {
+		var rect = g.querySelector("rect");
+		if (re && re.test(g.getAttribute("data-n"))) {
+			rect.setAttribute("fill", "rgb(230,0,230)");
+			spans.push([num(g, "data-x"), num(g, "data-x") + num(g, "data-w")]);
+		} else {
+			rect.setAttribute("fill", rect.getAttribute("data-fill"));
+		}
+	});
+
+	if (!re) {
+		matchedText.textContent = " ";
+		return;
+	}
+
+	// Nested matches overlap, so measure the union of matched spans
+	spans.sort(function (a, b) { return a[0] - b[0]; });
+	var covered = 0, end = -1;
+	spans.forEach(function (s) {
+		if (s[0] >= end) {
+			covered += s[1] - s[0];
+			end = s[1];
+		} else if (s[1] > end) {
+			covered += s[1] - end;
+			end = s[1];
+		}
+	});
+	matchedText.textContent = "Matched: " + (covered / graphWidth * 100).toFixed(2) + "%%";
+}
+`
@@ -0,0 +1,170 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// testProfile builds a small two-thread profile totalling one second. main has two
+// symbols on different lines, one callstack is split across both threads and one
+// recurses through parse:
+//
+//	libc!malloc  <- app!parse  <- app!main   thread 1: 0.25
+//	libc!memcpy  <- app!render <- app!main   thread 1: 0.125, thread 2: 0.125
+//	app!parse    <- app!main                 thread 2: 0.25
+//	libc!malloc  <- app!render <- app!main   thread 2: 0.125
+//	app!parse    <- app!parse  <- app!main   thread 1: 0.125
+func testProfile() *sleepy.ProfileData {
+	return sleepy.NewProfileData(
+		sleepy.Stats{Filename: "app.exe", Duration: "1", NumSamples: 8},
+		[]sleepy.Symbol{
+			{Address: "0x10", ModuleName: "app", ProcName: "main", FilePath: "main.c", LineNumber: 1},
+			{Address: "0x11", ModuleName: "app", ProcName: "main", FilePath: "main.c", LineNumber: 2},
+			{Address: "0x20", ModuleName: "app", ProcName: "parse", FilePath: "parse.c", LineNumber: 10},
+			{Address: "0x30", ModuleName: "app", ProcName: "render", FilePath: "render.c", LineNumber: 5},
+			{Address: "0x40", ModuleName: "libc", ProcName: "malloc"},
+			{Address: "0x50", ModuleName: "libc", ProcName: "memcpy"},
+		},
+		[]sleepy.Callstack{
+			{Addresses: []uint64{0x40, 0x20, 0x10}, ThreadCounts: map[int]float64{1: 0.25}},
+			{Addresses: []uint64{0x50, 0x30, 0x11}, ThreadCounts: map[int]float64{1: 0.125, 2: 0.125}},
+			{Addresses: []uint64{0x20, 0x10}, ThreadCounts: map[int]float64{2: 0.25}},
+			{Addresses: []uint64{0x40, 0x30, 0x11}, ThreadCounts: map[int]float64{2: 0.125}},
+			{Addresses: []uint64{0x20, 0x20, 0x10}, ThreadCounts: map[int]float64{1: 0.125}},
+		},
+		[]sleepy.Thread{{ID: 1, Name: "main"}, {ID: 2, Name: "worker"}},
+	)
+}
+
+// withUnknownFrame derives a profile from base, after indexing base, that adds a callstack
+// whose leaf address has no symbol and so is missing from the shared function table
+func withUnknownFrame(base *sleepy.ProfileData) *sleepy.ProfileData {
+	base.Index()
+	callstacks := append([]sleepy.Callstack(nil), base.Callstacks...)
+	callstacks = append(callstacks, sleepy.Callstack{
+		Addresses:    []uint64{0x999, 0x20, 0x10},
+		ThreadCounts: map[int]float64{2: 0.5},
+	})
+	return base.WithCallstacks(callstacks)
+}
+
+// stackTimes maps every resolved "thread|Module!Function;..." stack (leaf first) to its time
+func stackTimes(pd *sleepy.ProfileData) map[string]float64 {
+	times := make(map[string]float64)
+	for i := range pd.Callstacks {
+		cs := &pd.Callstacks[i]
+		var frames []string
+		for _, f := range pd.ResolveCallstack(cs) {
+			frames = append(frames, fmt.Sprintf("%s!%s", f.Module, f.Function))
+		}
+		for threadID, d := range cs.ThreadCounts {
+			times[fmt.Sprintf("%d|%s", threadID, strings.Join(frames, ";"))] += d
+		}
+	}
+	return times
+}
+
+// resolvedTotals is a function's time computed by resolving every frame of every callstack
+type resolvedTotals struct {
+	Self, Inclusive               float64
+	SelfSamples, InclusiveSamples int
+}
+
+// resolvedFunctionTotals computes per-function self and inclusive time from
+// ResolveCallstack, counting a function once per callstack for inclusive time
+func resolvedFunctionTotals(pd *sleepy.ProfileData) map[string]resolvedTotals {
+	totals := make(map[string]resolvedTotals)
+	for i := range pd.Callstacks {
+		cs := &pd.Callstacks[i]
+		duration := cs.GetDuration()
+		seen := make(map[string]bool)
+		for j, frame := range pd.ResolveCallstack(cs) {
+			funcSig := frame.Module + "!" + frame.Function
+			t := totals[funcSig]
+			if j == 0 {
+				t.Self += duration
+				t.SelfSamples++
+			}
+			if !seen[funcSig] {
+				seen[funcSig] = true
+				t.Inclusive += duration
+				t.InclusiveSamples++
+			}
+			totals[funcSig] = t
+		}
+	}
+	return totals
+}
+
+// resolvedModuleTimes computes per-module inclusive time from ResolveCallstack
+func resolvedModuleTimes(pd *sleepy.ProfileData) map[string]float64 {
+	times := make(map[string]float64)
+	for i := range pd.Callstacks {
+		cs := &pd.Callstacks[i]
+		seen := make(map[string]bool)
+		for _, frame := range pd.ResolveCallstack(cs) {
+			module := moduleName(frame.Module)
+			if !seen[module] {
+				seen[module] = true
+				times[module] += cs.GetDuration()
+			}
+		}
+	}
+	return times
+}
+
+// resolvedCallEdges computes the time of every "caller>callee" edge from
+// ResolveCallstack, counting each edge once per callstack
+func resolvedCallEdges(pd *sleepy.ProfileData) map[string]float64 {
+	edges := make(map[string]float64)
+	for i := range pd.Callstacks {
+		cs := &pd.Callstacks[i]
+		frames := pd.ResolveCallstack(cs)
+		seen := make(map[string]bool)
+		for j := 0; j+1 < len(frames); j++ {
+			edge := fmt.Sprintf("%s!%s>%s!%s", frames[j+1].Module, frames[j+1].Function, frames[j].Module, frames[j].Function)
+			if !seen[edge] {
+				seen[edge] = true
+				edges[edge] += cs.GetDuration()
+			}
+		}
+	}
+	return edges
+}
+
+// assertSameTimes fails the test unless both maps hold the same keys with the same times
+func assertSameTimes(t *testing.T, what string, got, want map[string]float64) {
+	t.Helper()
+	var keys []string
+	for key := range want {
+		keys = append(keys, key)
+	}
+	for key := range got {
+		if _, exists := want[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		g, inGot := got[key]
+		w, inWant := want[key]
+		if inGot != inWant || math.Abs(g-w) > 1e-9 {
+			t.Errorf("%s %q: got %v (present %v), want %v (present %v)", what, key, g, inGot, w, inWant)
+		}
+	}
+}
+
+// signatures lists the Module!Function of each hotspot in order
+func signatures(hotspots []Hotspot) []string {
+	sigs := make([]string, len(hotspots))
+	for i, hs := range hotspots {
+		sigs[i] = hs.Module + "!" + hs.Function
+	}
+	return sigs
+}
@@ -1,267 +1,342 @@
-package analyzer
-
-import (
-	"fmt"
-	"sort"
-	"strings"
-
-	"verysleepy-mcp/internal/sleepy"
-)
-
-// Hotspot represents a performance hotspot (function that consumes significant time)
-type Hotspot struct {
-	Function      string
-	Module        string
-	SourceFile    string
-	LineNumber    int
-	TotalTime     float64 // Total time spent in this function
-	SampleCount   int     // Number of samples hitting this function
-	Percentage    float64 // Percentage of total execution time
-	CallstackRefs []int   // Indices of callstacks containing this function
-}
-
-// CallChainNode represents a node in the call chain analysis
-type CallChainNode struct {
-	Function   string
-	Module     string
-	TotalTime  float64
-	SampleCount int
-	Children   []*CallChainNode
-}
-
-// FindHotspots identifies the top performance bottlenecks in the profile
-// Returns hotspots sorted by total time (descending)
-func FindHotspots(profile *sleepy.ProfileData, topN int) []Hotspot {
-	// Map: function signature -> hotspot data
-	hotspotMap := make(map[string]*Hotspot)
-
-	totalProfileTime := 0.0
-
-	// Analyze each callstack
-	for csIdx, cs := range profile.Callstacks {
-		duration := cs.GetDuration()
-		totalProfileTime += duration
-
-		// Resolve callstack to get functions
-		frames := profile.ResolveCallstack(&cs)
-
-		// Count each function in the callstack
-		seenInThisStack := make(map[string]bool)
-		for _, frame := range frames {
-			funcSig := fmt.Sprintf("%s!%s", frame.Module, frame.Function)
-
-			// Avoid double-counting in the same stack
-			if seenInThisStack[funcSig] {
-				continue
-			}
-			seenInThisStack[funcSig] = true
-
-			if _, exists := hotspotMap[funcSig]; !exists {
-				hotspotMap[funcSig] = &Hotspot{
-					Function:      frame.Function,
-					Module:        frame.Module,
-					SourceFile:    frame.SourceFile,
-					LineNumber:    frame.LineNumber,
-					TotalTime:     0,
-					SampleCount:   0,
-					CallstackRefs: []int{},
-				}
-			}
-
-			hs := hotspotMap[funcSig]
-			hs.TotalTime += duration
-			hs.SampleCount++
-			hs.CallstackRefs = append(hs.CallstackRefs, csIdx)
-		}
-	}
-
-	// Calculate percentages and convert to slice
-	hotspots := make([]Hotspot, 0, len(hotspotMap))
-	for _, hs := range hotspotMap {
-		if totalProfileTime > 0 {
-			hs.Percentage = (hs.TotalTime / totalProfileTime) * 100.0
-		}
-		hotspots = append(hotspots, *hs)
-	}
-
-	// Sort by total time (descending)
-	sort.Slice(hotspots, func(i, j int) bool {
-		return hotspots[i].TotalTime > hotspots[j].TotalTime
-	})
-
-	// Return top N
-	if topN > 0 && topN < len(hotspots) {
-		return hotspots[:topN]
-	}
-	return hotspots
-}
-
-// FindBottomFunctions identifies leaf functions (functions at the bottom of callstacks)
-// These are often the actual CPU-intensive operations
-func FindBottomFunctions(profile *sleepy.ProfileData, topN int) []Hotspot {
-	bottomFuncMap := make(map[string]*Hotspot)
-	totalProfileTime := 0.0
-
-	for csIdx, cs := range profile.Callstacks {
-		duration := cs.GetDuration()
-		totalProfileTime += duration
-
-		frames := profile.ResolveCallstack(&cs)
-		if len(frames) == 0 {
-			continue
-		}
-
-		// Get the bottom (first) frame
-		frame := frames[0]
-		funcSig := fmt.Sprintf("%s!%s", frame.Module, frame.Function)
-
-		if _, exists := bottomFuncMap[funcSig]; !exists {
-			bottomFuncMap[funcSig] = &Hotspot{
-				Function:      frame.Function,
-				Module:        frame.Module,
-				SourceFile:    frame.SourceFile,
-				LineNumber:    frame.LineNumber,
-				TotalTime:     0,
-				SampleCount:   0,
-				CallstackRefs: []int{},
-			}
-		}
-
-		hs := bottomFuncMap[funcSig]
-		hs.TotalTime += duration
-		hs.SampleCount++
-		hs.CallstackRefs = append(hs.CallstackRefs, csIdx)
-	}
-
-	// Calculate percentages and convert to slice
-	hotspots := make([]Hotspot, 0, len(bottomFuncMap))
-	for _, hs := range bottomFuncMap {
-		if totalProfileTime > 0 {
-			hs.Percentage = (hs.TotalTime / totalProfileTime) * 100.0
-		}
-		hotspots = append(hotspots, *hs)
-	}
-
-	// Sort by total time (descending)
-	sort.Slice(hotspots, func(i, j int) bool {
-		return hotspots[i].TotalTime > hotspots[j].TotalTime
-	})
-
-	if topN > 0 && topN < len(hotspots) {
-		return hotspots[:topN]
-	}
-	return hotspots
-}
-
-// FindModuleHotspots groups hotspots by module
-func FindModuleHotspots(profile *sleepy.ProfileData) map[string]float64 {
-	moduleTime := make(map[string]float64)
-
-	for _, cs := range profile.Callstacks {
-		duration := cs.GetDuration()
-		frames := profile.ResolveCallstack(&cs)
-
-		seenModules := make(map[string]bool)
-		for _, frame := range frames {
-			module := frame.Module
-			if module == "" || module == "?" {
-				module = "[unknown]"
-			}
-
-			if seenModules[module] {
-				continue
-			}
-			seenModules[module] = true
-
-			moduleTime[module] += duration
-		}
-	}
-
-	return moduleTime
-}
-
-// AnalyzeCallChains builds a call tree showing which functions call which
-// depth: how deep to analyze (0 = unlimited)
-func AnalyzeCallChains(profile *sleepy.ProfileData, depth int) map[string]*CallChainNode {
-	rootFunctions := make(map[string]*CallChainNode)
-
-	for _, cs := range profile.Callstacks {
-		duration := cs.GetDuration()
-		frames := profile.ResolveCallstack(&cs)
-
-		if len(frames) == 0 {
-			continue
-		}
-
-		// Start from the bottom (leaf) and build up
-		var currentNode *CallChainNode
-
-		maxDepth := len(frames)
-		if depth > 0 && depth < maxDepth {
-			maxDepth = depth
-		}
-
-		for i := 0; i < maxDepth; i++ {
-			frame := frames[i]
-			funcSig := fmt.Sprintf("%s!%s", frame.Module, frame.Function)
-
-			if i == 0 {
-				// Root level
-				if _, exists := rootFunctions[funcSig]; !exists {
-					rootFunctions[funcSig] = &CallChainNode{
-						Function:    frame.Function,
-						Module:      frame.Module,
-						TotalTime:   0,
-						SampleCount: 0,
-						Children:    []*CallChainNode{},
-					}
-				}
-				currentNode = rootFunctions[funcSig]
-				currentNode.TotalTime += duration
-				currentNode.SampleCount++
-			} else {
-				// Find or create child
-				found := false
-				for _, child := range currentNode.Children {
-					childSig := fmt.Sprintf("%s!%s", child.Module, child.Function)
-					if childSig == funcSig {
-						child.TotalTime += duration
-						child.SampleCount++
-						currentNode = child
-						found = true
-						break
-					}
-				}
-
-				if !found {
-					newChild := &CallChainNode{
-						Function:    frame.Function,
-						Module:      frame.Module,
-						TotalTime:   duration,
-						SampleCount: 1,
-						Children:    []*CallChainNode{},
-					}
-					currentNode.Children = append(currentNode.Children, newChild)
-					currentNode = newChild
-				}
-			}
-		}
-	}
-
-	return rootFunctions
-}
-
-// FormatHotspot returns a human-readable string representation of a hotspot
-func FormatHotspot(hs Hotspot, rank int) string {
-	var sb strings.Builder
-
-	sb.WriteString(fmt.Sprintf("#%d: %s!%s\n", rank, hs.Module, hs.Function))
-	sb.WriteString(fmt.Sprintf("    Time: %.6f seconds (%.2f%%)\n", hs.TotalTime, hs.Percentage))
-	sb.WriteString(fmt.Sprintf("    Samples: %d\n", hs.SampleCount))
-
-	if hs.SourceFile != "" && hs.SourceFile != "[unknown]" {
-		sb.WriteString(fmt.Sprintf("    Source: %s:%d\n", hs.SourceFile, hs.LineNumber))
-	}
-
-	return sb.String()
-}
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// Hotspot represents a performance hotspot (function that consumes significant time)
+type Hotspot struct {
+	Function            string  `json:"function"`
+	Module              string  `json:"module"`
+	SourceFile          string  `json:"source_file"`
+	LineNumber          int     `json:"line_number"`
+	SelfTime            float64 `json:"self_time"`            // Time spent in this function itself (as the leaf frame)
+	InclusiveTime       float64 `json:"inclusive_time"`       // Time spent in this function and everything it calls
+	SelfSamples         int     `json:"self_samples"`         // Number of callstacks where this function is the leaf frame
+	InclusiveSamples    int     `json:"inclusive_samples"`    // Number of callstacks containing this function
+	SelfPercentage      float64 `json:"self_percentage"`      // Self time as percentage of total execution time
+	InclusivePercentage float64 `json:"inclusive_percentage"` // Inclusive time as percentage of total execution time
+	CallstackRefs       []int   `json:"-"`                    // Indices of callstacks containing this function
+}
+
+// Sort orders accepted by FindHotspots
+const (
+	SortBySelf      = "self"
+	SortByInclusive = "inclusive"
+)
+
+// CallChainNode represents a node in the call chain analysis
+type CallChainNode struct {
+	Function    string           `json:"function"`
+	Module      string           `json:"module"`
+	TotalTime   float64          `json:"total_time"` // Inclusive time of all callstacks passing through this node
+	SelfTime    float64          `json:"self_time"`  // Time of callstacks ending at this node
+	SampleCount int              `json:"sample_count"`
+	Children    []*CallChainNode `json:"children"`
+
+	childIndex map[int]*CallChainNode // Function ID -> child, for fast lookup
+}
+
+// child returns the child node for a function, creating it if needed
+func (n *CallChainNode) child(funcID int, fn *sleepy.IndexedFunction) *CallChainNode {
+	if c, exists := n.childIndex[funcID]; exists {
+		return c
+	}
+
+	c := newCallChainNode(fn)
+	if n.childIndex == nil {
+		n.childIndex = make(map[int]*CallChainNode)
+	}
+	n.childIndex[funcID] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func newCallChainNode(fn *sleepy.IndexedFunction) *CallChainNode {
+	return &CallChainNode{
+		Function: fn.Function,
+		Module:   fn.Module,
+		Children: []*CallChainNode{},
+	}
+}
+
+// FindHotspots identifies the top performance bottlenecks in the profile
+// sortBy: SortBySelf or SortByInclusive (anything else falls back to self time)
+// Returns hotspots sorted by the requested time (descending)
+func FindHotspots(profile *sleepy.ProfileData, topN int, sortBy string) []Hotspot {
+	ix := profile.Index()
+
+	// Function ID -> hotspot data, nil until the function occurs in a callstack
+	hotspotByID := make([]*Hotspot, len(ix.Functions))
+	lastStack := newStackMarks(len(ix.Functions))
+
+	// Analyze each callstack
+	for csIdx, stack := range ix.Stacks {
+		duration := ix.Durations[csIdx]
+
+		// Count each function in the callstack
+		for i, funcID := range stack {
+			hs := hotspotByID[funcID]
+			if hs == nil {
+				fn := &ix.Functions[funcID]
+				hs = &Hotspot{
+					Function:      fn.Function,
+					Module:        fn.Module,
+					SourceFile:    fn.SourceFile,
+					LineNumber:    fn.LineNumber,
+					CallstackRefs: []int{},
+				}
+				hotspotByID[funcID] = hs
+			}
+
+			// The leaf (first) frame is where the CPU actually was
+			if i == 0 {
+				hs.SelfTime += duration
+				hs.SelfSamples++
+			}
+
+			// Avoid double-counting inclusive time for recursive functions
+			if !lastStack.mark(funcID, csIdx) {
+				continue
+			}
+
+			hs.InclusiveTime += duration
+			hs.InclusiveSamples++
+			hs.CallstackRefs = append(hs.CallstackRefs, csIdx)
+		}
+	}
+
+	// Calculate percentages and convert to slice
+	hotspots := make([]Hotspot, 0, len(hotspotByID))
+	for _, hs := range hotspotByID {
+		if hs == nil {
+			continue
+		}
+		if ix.TotalTime > 0 {
+			hs.SelfPercentage = (hs.SelfTime / ix.TotalTime) * 100.0
+			hs.InclusivePercentage = (hs.InclusiveTime / ix.TotalTime) * 100.0
+		}
+		hotspots = append(hotspots, *hs)
+	}
+
+	sortHotspots(hotspots, sortBy)
+
+	// Return top N
+	if topN > 0 && topN < len(hotspots) {
+		return hotspots[:topN]
+	}
+	return hotspots
+}
+
+// sortHotspots orders hotspots by self or inclusive time (descending),
+// using the other time as a tie-breaker
+func sortHotspots(hotspots []Hotspot, sortBy string) {
+	if sortBy == SortByInclusive {
+		sort.Slice(hotspots, func(i, j int) bool {
+			if hotspots[i].InclusiveTime != hotspots[j].InclusiveTime {
+				return hotspots[i].InclusiveTime > hotspots[j].InclusiveTime
+			}
+			return hotspots[i].SelfTime > hotspots[j].SelfTime
+		})
+		return
+	}
+
+	sort.Slice(hotspots, func(i, j int) bool {
+		if hotspots[i].SelfTime != hotspots[j].SelfTime {
+			return hotspots[i].SelfTime > hotspots[j].SelfTime
+		}
+		return hotspots[i].InclusiveTime > hotspots[j].InclusiveTime
+	})
+}
+
+// stackMarks records the last callstack each ID was seen in, so that IDs
+// can be counted once per callstack without allocating a set per callstack
+type stackMarks []int
+
+func newStackMarks(n int) stackMarks {
+	return make(stackMarks, n)
+}
+
+// mark records id as seen in callstack csIdx and reports whether this is its first time there
+func (m stackMarks) mark(id, csIdx int) bool {
+	if m[id] == csIdx+1 {
+		return false
+	}
+	m[id] = csIdx + 1
+	return true
+}
+
+// seen reports whether id was marked in any callstack
+func (m stackMarks) seen(id int) bool {
+	return m[id] != 0
+}
+
+// FindBottomFunctions identifies leaf functions (functions at the bottom of callstacks)
+// These are often the actual CPU-intensive operations
+// Use AnalyzeBottomUpCallChains to also see the call paths leading to them
+func FindBottomFunctions(profile *sleepy.ProfileData, topN int) []Hotspot {
+	all := FindHotspots(profile, 0, SortBySelf)
+
+	// Only functions that were ever the leaf frame have self time
+	bottom := make([]Hotspot, 0, len(all))
+	for _, hs := range all {
+		if hs.SelfSamples == 0 {
+			continue
+		}
+		bottom = append(bottom, hs)
+	}
+
+	if topN > 0 && topN < len(bottom) {
+		return bottom[:topN]
+	}
+	return bottom
+}
+
+// AnalyzeBottomUpCallChains builds an inverted call tree: leaf functions are the roots,
+// and each level expands to the callers that led to them. Each node's TotalTime is the
+// time of callstacks whose leaf-side path matches the path from the root to that node.
+// Only root nodes carry SelfTime, since the root frame is where the CPU actually was.
+// depth: how many frames above the leaf to analyze (0 = unlimited)
+func AnalyzeBottomUpCallChains(profile *sleepy.ProfileData, depth int) map[string]*CallChainNode {
+	ix := profile.Index()
+	leafFunctions := make(map[string]*CallChainNode)
+
+	for csIdx, stack := range ix.Stacks {
+		duration := ix.Durations[csIdx]
+
+		if len(stack) == 0 {
+			continue
+		}
+
+		maxDepth := len(stack)
+		if depth > 0 && depth < maxDepth {
+			maxDepth = depth
+		}
+
+		var currentNode *CallChainNode
+		for i := 0; i < maxDepth; i++ {
+			fn := &ix.Functions[stack[i]]
+
+			if i == 0 {
+				if _, exists := leafFunctions[fn.Signature]; !exists {
+					leafFunctions[fn.Signature] = newCallChainNode(fn)
+				}
+				currentNode = leafFunctions[fn.Signature]
+				currentNode.SelfTime += duration
+			} else {
+				currentNode = currentNode.child(stack[i], fn)
+			}
+
+			currentNode.TotalTime += duration
+			currentNode.SampleCount++
+		}
+	}
+
+	return leafFunctions
+}
+
+// FindModuleHotspots groups hotspots by module
+func FindModuleHotspots(profile *sleepy.ProfileData) map[string]float64 {
+	ix := profile.Index()
+
+	// Unresolved modules ("" and "?") share one "[unknown]" entry
+	var names []string
+	slots := make(map[string]int)
+	moduleSlot := make([]int, len(ix.Modules))
+	for moduleID, module := range ix.Modules {
+		name := moduleName(module)
+		slot, exists := slots[name]
+		if !exists {
+			slot = len(names)
+			slots[name] = slot
+			names = append(names, name)
+		}
+		moduleSlot[moduleID] = slot
+	}
+
+	times := make([]float64, len(names))
+	lastStack := newStackMarks(len(names))
+	for csIdx, stack := range ix.Stacks {
+		for _, funcID := range stack {
+			slot := moduleSlot[ix.Functions[funcID].ModuleID]
+			if lastStack.mark(slot, csIdx) {
+				times[slot] += ix.Durations[csIdx]
+			}
+		}
+	}
+
+	moduleTime := make(map[string]float64)
+	for slot, t := range times {
+		if lastStack.seen(slot) {
+			moduleTime[names[slot]] = t
+		}
+	}
+
+	return moduleTime
+}
+
+// AnalyzeCallChains builds a top-down call tree showing which functions call which.
+// Roots are the outermost frames (thread entry points); each level goes one call deeper.
+// depth: how deep to analyze (0 = unlimited)
+func AnalyzeCallChains(profile *sleepy.ProfileData, depth int) map[string]*CallChainNode {
+	ix := profile.Index()
+	rootFunctions := make(map[string]*CallChainNode)
+
+	for csIdx, stack := range ix.Stacks {
+		duration := ix.Durations[csIdx]
+
+		if len(stack) == 0 {
+			continue
+		}
+
+		maxDepth := len(stack)
+		if depth > 0 && depth < maxDepth {
+			maxDepth = depth
+		}
+
+		// Frames are leaf-first, so walk them backwards from the root
+		var currentNode *CallChainNode
+		for level := 0; level < maxDepth; level++ {
+			funcID := stack[len(stack)-1-level]
+			fn := &ix.Functions[funcID]
+
+			if level == 0 {
+				if _, exists := rootFunctions[fn.Signature]; !exists {
+					rootFunctions[fn.Signature] = newCallChainNode(fn)
+				}
+				currentNode = rootFunctions[fn.Signature]
+			} else {
+				currentNode = currentNode.child(funcID, fn)
+			}
+
+			currentNode.TotalTime += duration
+			currentNode.SampleCount++
+		}
+
+		// Only a node holding the leaf frame has self time
+		if maxDepth == len(stack) {
+			currentNode.SelfTime += duration
+		}
+	}
+
+	return rootFunctions
+}
+
+// FormatHotspot returns a human-readable string representation of a hotspot
+func FormatHotspot(hs Hotspot, rank int) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("#%d: %s!%s\n", rank, hs.Module, hs.Function))
+	sb.WriteString(fmt.Sprintf("    Self Time: %.6f seconds (%.2f%%), %d samples\n", hs.SelfTime, hs.SelfPercentage, hs.SelfSamples))
+	sb.WriteString(fmt.Sprintf("    Inclusive Time: %.6f seconds (%.2f%%), %d samples\n", hs.InclusiveTime, hs.InclusivePercentage, hs.InclusiveSamples))
+
+	if hs.SourceFile != "" && hs.SourceFile != "[unknown]" {
+		sb.WriteString(fmt.Sprintf("    Source: %s:%d\n", hs.SourceFile, hs.LineNumber))
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,190 @@
+package analyzer
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+func TestFindHotspots(t *testing.T) {
+	hotspots := FindHotspots(testProfile(), 0, SortBySelf)
+
+	want := map[string]resolvedTotals{
+		"app!main":    {Self: 0, Inclusive: 1, SelfSamples: 0, InclusiveSamples: 5},
+		"app!parse":   {Self: 0.375, Inclusive: 0.625, SelfSamples: 2, InclusiveSamples: 3}, // Recursion counted once
+		"app!render":  {Self: 0, Inclusive: 0.375, SelfSamples: 0, InclusiveSamples: 2},
+		"libc!malloc": {Self: 0.375, Inclusive: 0.375, SelfSamples: 2, InclusiveSamples: 2},
+		"libc!memcpy": {Self: 0.25, Inclusive: 0.25, SelfSamples: 1, InclusiveSamples: 1},
+	}
+	if len(hotspots) != len(want) {
+		t.Fatalf("got %d hotspots, want %d: %v", len(hotspots), len(want), signatures(hotspots))
+	}
+	for _, hs := range hotspots {
+		funcSig := hs.Module + "!" + hs.Function
+		got := resolvedTotals{hs.SelfTime, hs.InclusiveTime, hs.SelfSamples, hs.InclusiveSamples}
+		if got != want[funcSig] {
+			t.Errorf("%s = %+v, want %+v", funcSig, got, want[funcSig])
+		}
+		if math.Abs(hs.InclusivePercentage-want[funcSig].Inclusive*100) > 1e-9 {
+			t.Errorf("%s InclusivePercentage = %v, want %v", funcSig, hs.InclusivePercentage, want[funcSig].Inclusive*100)
+		}
+	}
+
+	// A function's source comes from its first symbol
+	for _, hs := range hotspots {
+		if hs.Function == "main" && (hs.SourceFile != "main.c" || hs.LineNumber != 1) {
+			t.Errorf("main source = %s:%d, want main.c:1", hs.SourceFile, hs.LineNumber)
+		}
+	}
+}
+
+func TestFindHotspotsOrder(t *testing.T) {
+	tests := []struct {
+		sortBy string
+		topN   int
+		want   []string
+	}{
+		{SortBySelf, 0, []string{"app!parse", "libc!malloc", "libc!memcpy", "app!main", "app!render"}},
+		{SortByInclusive, 0, []string{"app!main", "app!parse", "libc!malloc", "app!render", "libc!memcpy"}},
+		{SortBySelf, 2, []string{"app!parse", "libc!malloc"}},
+		{"unknown", 1, []string{"app!parse"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sortBy+"/"+strconv.Itoa(tt.topN), func(t *testing.T) {
+			if got := signatures(FindHotspots(testProfile(), tt.topN, tt.sortBy)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindBottomFunctions(t *testing.T) {
+	got := signatures(FindBottomFunctions(testProfile(), 0))
+	if want := []string{"app!parse", "libc!malloc", "libc!memcpy"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FindBottomFunctions() = %v, want %v", got, want)
+	}
+}
+
+func TestFindModuleHotspots(t *testing.T) {
+	assertSameTimes(t, "module", FindModuleHotspots(testProfile()), map[string]float64{"app": 1, "libc": 0.625})
+	assertSameTimes(t, "module", FindModuleHotspots(withUnknownFrame(testProfile())),
+		map[string]float64{"app": 1.5, "libc": 0.625, "[unknown]": 0.5})
+}
+
+// Index-based aggregation must agree with resolving every frame of every callstack,
+// including on derived profiles and on ones whose addresses the shared table lacks
+func TestAnalysesMatchResolvedCallstacks(t *testing.T) {
+	mustFilter := func(profile *sleepy.ProfileData, opts FilterOptions) *sleepy.ProfileData {
+		filtered, err := FilterProfile(profile, opts)
+		if err != nil {
+			t.Fatalf("FilterProfile(%+v) error = %v", opts, err)
+		}
+		return filtered
+	}
+	merged, err := MergeProfiles([]string{"a", "b"}, []*sleepy.ProfileData{testProfile(), withUnknownFrame(testProfile())})
+	if err != nil {
+		t.Fatalf("MergeProfiles() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		profile *sleepy.ProfileData
+	}{
+		{"loaded", testProfile()},
+		{"thread filter", mustFilter(testProfile(), FilterOptions{Thread: "2"})},
+		{"hide", mustFilter(testProfile(), FilterOptions{Hide: "app!parse"})},
+		{"unknown address", withUnknownFrame(testProfile())},
+		{"filtered unknown address", mustFilter(withUnknownFrame(testProfile()), FilterOptions{PruneFrom: "parse"})},
+		{"merged", merged.Profile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := resolvedFunctionTotals(tt.profile)
+
+			hotspots := FindHotspots(tt.profile, 0, SortBySelf)
+			got := make(map[string]resolvedTotals)
+			for _, hs := range hotspots {
+				got[hs.Module+"!"+hs.Function] = resolvedTotals{hs.SelfTime, hs.InclusiveTime, hs.SelfSamples, hs.InclusiveSamples}
+			}
+			if len(got) != len(want) {
+				t.Errorf("FindHotspots() found %d functions, want %d", len(got), len(want))
+			}
+			for funcSig, w := range want {
+				g := got[funcSig]
+				if math.Abs(g.Self-w.Self) > 1e-9 || math.Abs(g.Inclusive-w.Inclusive) > 1e-9 ||
+					g.SelfSamples != w.SelfSamples || g.InclusiveSamples != w.InclusiveSamples {
+					t.Errorf("FindHotspots() %s = %+v, want %+v", funcSig, g, w)
+				}
+			}
+
+			frequencies := make(map[string]float64)
+			for _, f := range GetFunctionCallFrequencies(tt.profile) {
+				frequencies[f.Module+"!"+f.Function] = float64(f.Count)
+			}
+			wantFrequencies := make(map[string]float64)
+			for funcSig, w := range want {
+				wantFrequencies[funcSig] = float64(w.InclusiveSamples)
+			}
+			assertSameTimes(t, "GetFunctionCallFrequencies()", frequencies, wantFrequencies)
+
+			if stats := ComputeStatistics(tt.profile); stats.UniqueFunctions != len(want) {
+				t.Errorf("ComputeStatistics().UniqueFunctions = %d, want %d", stats.UniqueFunctions, len(want))
+			}
+
+			assertSameTimes(t, "FindModuleHotspots()", FindModuleHotspots(tt.profile), resolvedModuleTimes(tt.profile))
+
+			details, err := GetFunctionDetails(tt.profile, ".")
+			if err != nil {
+				t.Fatalf("GetFunctionDetails() error = %v", err)
+			}
+			callers, callees := make(map[string]float64), make(map[string]float64)
+			for _, d := range details {
+				target := d.Module + "!" + d.Function
+				for _, e := range d.Callers {
+					callers[e.Module+"!"+e.Function+">"+target] += e.Time
+				}
+				for _, e := range d.Callees {
+					callees[target+">"+e.Module+"!"+e.Function] += e.Time
+				}
+			}
+			wantEdges := resolvedCallEdges(tt.profile)
+			assertSameTimes(t, "caller edge", callers, wantEdges)
+			assertSameTimes(t, "callee edge", callees, wantEdges)
+		})
+	}
+}
+
+func TestDerivedProfileWithUnknownAddress(t *testing.T) {
+	base := testProfile()
+	baseFunctions := len(base.Index().Functions)
+	derived := withUnknownFrame(base)
+
+	hotspots := FindHotspots(derived, 1, SortBySelf)
+	if len(hotspots) != 1 || hotspots[0].Module != "?" || hotspots[0].Function != "[0x999]" || hotspots[0].SelfTime != 0.5 {
+		t.Errorf("top hotspot = %+v, want ?![0x999] with 0.5s self time", hotspots)
+	}
+
+	// The derived profile extends a copy of the table; the loaded profile's stays as it was
+	if n := len(base.Index().Functions); n != baseFunctions {
+		t.Errorf("base has %d functions after deriving, want %d", n, baseFunctions)
+	}
+	if id := base.Index().FunctionAt(0x999); id != -1 {
+		t.Errorf("base FunctionAt(0x999) = %d, want -1", id)
+	}
+	if id := derived.Index().FunctionAt(0x999); id < baseFunctions {
+		t.Errorf("derived FunctionAt(0x999) = %d, want a new function ID", id)
+	}
+	if got := signatures(FindHotspots(base, 0, SortBySelf)); len(got) != baseFunctions {
+		t.Errorf("base hotspots = %v, want %d functions", got, baseFunctions)
+	}
+
+	// Filters see the added function too
+	focused, err := FilterProfile(derived, FilterOptions{Focus: `^\?!`})
+	if err != nil {
+		t.Fatalf("FilterProfile() error = %v", err)
+	}
+	assertSameTimes(t, "stack", stackTimes(focused), map[string]float64{"2|?![0x999];app!parse;app!main": 0.5})
+}
@@ -0,0 +1,199 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// MergeSource summarizes one profile that went into a merge
+type MergeSource struct {
+	Name       string  `json:"name"` // How the caller refers to the profile, e.g. its file path
+	TotalTime  float64 `json:"total_time"`
+	Callstacks int     `json:"callstacks"`
+	Samples    int     `json:"samples"`
+}
+
+// MergedProfile is the aggregate of several profiles
+type MergedProfile struct {
+	Profile      *sleepy.ProfileData
+	Sources      []MergeSource
+	StackSources [][]int // Indices into Sources of the profiles that contributed to each merged callstack
+}
+
+// MergeProfiles combines several captures of the same scenario into one profile.
+// Addresses differ between runs (ASLR), so symbols are unified by Module!Function plus
+// source file and line, and each unified symbol gets a new synthetic address. Callstacks
+// that resolve to the same frames are merged and their times summed. Threads are unified
+// by name (the n-th thread with a name in each run maps to the same merged thread);
+// unnamed threads keep their ID unless it is already taken.
+// names labels the profiles in Sources and must have one entry per profile.
+func MergeProfiles(names []string, profiles []*sleepy.ProfileData) (*MergedProfile, error) {
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no profiles to merge")
+	}
+	if len(names) != len(profiles) {
+		return nil, fmt.Errorf("got %d names for %d profiles", len(names), len(profiles))
+	}
+
+	merged := &MergedProfile{}
+	var symbols []sleepy.Symbol
+	var callstacks []sleepy.Callstack
+	var threads []sleepy.Thread
+	stats := sleepy.Stats{}
+
+	symbolAddrs := make(map[string]uint64)
+	symbolFor := func(frame sleepy.ResolvedFrame) uint64 {
+		key := fmt.Sprintf("%s!%s\x00%s\x00%d", frame.Module, frame.Function, frame.SourceFile, frame.LineNumber)
+		if addr, exists := symbolAddrs[key]; exists {
+			return addr
+		}
+		addr := uint64(len(symbols)+1) << 4
+		symbols = append(symbols, sleepy.Symbol{
+			Address:    fmt.Sprintf("0x%x", addr),
+			ModuleName: frame.Module,
+			ProcName:   frame.Function,
+			FilePath:   frame.SourceFile,
+			LineNumber: frame.LineNumber,
+		})
+		symbolAddrs[key] = addr
+		return addr
+	}
+
+	// Named threads are matched across runs by name and occurrence
+	namedThreads := make(map[string]int) // "name\x00n" → merged thread ID
+	usedThreadIDs := make(map[int]bool)
+	nextThreadID := 1
+	for _, profile := range profiles {
+		for i := range profile.Callstacks {
+			for threadID := range profile.Callstacks[i].ThreadCounts {
+				if threadID >= nextThreadID {
+					nextThreadID = threadID + 1
+				}
+			}
+		}
+		for _, t := range profile.Threads {
+			if t.ID >= nextThreadID {
+				nextThreadID = t.ID + 1
+			}
+		}
+	}
+	claimThreadID := func(preferred int) int {
+		if !usedThreadIDs[preferred] {
+			usedThreadIDs[preferred] = true
+			return preferred
+		}
+		id := nextThreadID
+		nextThreadID++
+		usedThreadIDs[id] = true
+		return id
+	}
+
+	stackIndex := make(map[string]int)
+	totalDuration := 0.0
+	hasDuration := false
+
+	for sourceIdx, profile := range profiles {
+		// Map this run's thread IDs to merged thread IDs
+		threadIDs := map[int]int{sleepy.UnknownThreadID: sleepy.UnknownThreadID}
+		nameCount := make(map[string]int)
+		for _, t := range profile.Threads {
+			if _, exists := threadIDs[t.ID]; exists {
+				continue
+			}
+			if t.Name == "" {
+				threadIDs[t.ID] = claimThreadID(t.ID)
+				threads = append(threads, sleepy.Thread{ID: threadIDs[t.ID]})
+				continue
+			}
+			key := t.Name + "\x00" + strconv.Itoa(nameCount[t.Name])
+			nameCount[t.Name]++
+			id, exists := namedThreads[key]
+			if !exists {
+				id = claimThreadID(t.ID)
+				namedThreads[key] = id
+				threads = append(threads, sleepy.Thread{ID: id, Name: t.Name})
+			}
+			threadIDs[t.ID] = id
+		}
+
+		source := MergeSource{Name: names[sourceIdx], Samples: profile.Stats.NumSamples}
+		for i := range profile.Callstacks {
+			cs := &profile.Callstacks[i]
+			frames := profile.ResolveCallstack(cs)
+
+			addresses := make([]uint64, len(frames))
+			var key strings.Builder
+			for j, frame := range frames {
+				addresses[j] = symbolFor(frame)
+				key.WriteString(strconv.FormatUint(addresses[j], 16))
+				key.WriteByte(' ')
+			}
+
+			idx, exists := stackIndex[key.String()]
+			if !exists {
+				idx = len(callstacks)
+				stackIndex[key.String()] = idx
+				callstacks = append(callstacks, sleepy.Callstack{
+					Addresses:    addresses,
+					ThreadCounts: make(map[int]float64),
+				})
+				merged.StackSources = append(merged.StackSources, nil)
+			}
+
+			for threadID, d := range cs.ThreadCounts {
+				mergedID, known := threadIDs[threadID]
+				if !known {
+					// Time on a thread missing from Threads keeps its ID when free
+					mergedID = claimThreadID(threadID)
+					threadIDs[threadID] = mergedID
+				}
+				callstacks[idx].ThreadCounts[mergedID] += d
+			}
+
+			contributors := merged.StackSources[idx]
+			if len(contributors) == 0 || contributors[len(contributors)-1] != sourceIdx {
+				merged.StackSources[idx] = append(contributors, sourceIdx)
+			}
+
+			source.TotalTime += cs.GetDuration()
+			source.Callstacks++
+		}
+		merged.Sources = append(merged.Sources, source)
+
+		stats.NumSamples += profile.Stats.NumSamples
+		if stats.Filename == "" {
+			stats.Filename = profile.Stats.Filename
+		}
+		if stats.Date == "" {
+			stats.Date = profile.Stats.Date
+		}
+		if d, err := strconv.ParseFloat(profile.Stats.Duration, 64); err == nil {
+			totalDuration += d
+			hasDuration = true
+		}
+	}
+
+	if hasDuration {
+		stats.Duration = strconv.FormatFloat(totalDuration, 'f', -1, 64)
+	}
+
+	sort.Slice(threads, func(i, j int) bool { return threads[i].ID < threads[j].ID })
+
+	merged.Profile = sleepy.NewProfileData(stats, symbols, callstacks, threads)
+	return merged, nil
+}
+
+// CommonStackCount returns how many merged callstacks appear in every source profile
+func (m *MergedProfile) CommonStackCount() int {
+	count := 0
+	for _, contributors := range m.StackSources {
+		if len(contributors) == len(m.Sources) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,101 @@
+package analyzer
+
+import (
+	"fmt"
+	"testing"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// relocated returns a copy of profile with every address moved by offset, as in a run
+// where the modules were loaded elsewhere, and its threads given different IDs
+func relocated(profile *sleepy.ProfileData, offset uint64) *sleepy.ProfileData {
+	symbols := make([]sleepy.Symbol, len(profile.Symbols))
+	for i, sym := range profile.Symbols {
+		var addr uint64
+		fmt.Sscanf(sym.Address, "0x%x", &addr)
+		sym.Address = fmt.Sprintf("0x%x", addr+offset)
+		symbols[i] = sym
+	}
+
+	callstacks := make([]sleepy.Callstack, len(profile.Callstacks))
+	for i, cs := range profile.Callstacks {
+		addresses := make([]uint64, len(cs.Addresses))
+		for j, addr := range cs.Addresses {
+			addresses[j] = addr + offset
+		}
+		counts := make(map[int]float64)
+		for threadID, d := range cs.ThreadCounts {
+			counts[threadID+100] = d
+		}
+		callstacks[i] = sleepy.Callstack{Addresses: addresses, ThreadCounts: counts}
+	}
+
+	threads := make([]sleepy.Thread, len(profile.Threads))
+	for i, thread := range profile.Threads {
+		threads[i] = sleepy.Thread{ID: thread.ID + 100, Name: thread.Name}
+	}
+
+	return sleepy.NewProfileData(profile.Stats, symbols, callstacks, threads)
+}
+
+func TestMergeProfiles(t *testing.T) {
+	merged, err := MergeProfiles([]string{"first", "second"}, []*sleepy.ProfileData{testProfile(), relocated(testProfile(), 0x1000)})
+	if err != nil {
+		t.Fatalf("MergeProfiles() error = %v", err)
+	}
+
+	// Frames and threads are matched by name, so every stack doubles
+	want := make(map[string]float64)
+	for key, d := range stackTimes(testProfile()) {
+		want[key] = 2 * d
+	}
+	assertSameTimes(t, "stack", stackTimes(merged.Profile), want)
+
+	if n := len(merged.Profile.Callstacks); n != 5 {
+		t.Errorf("got %d merged callstacks, want 5", n)
+	}
+	if n := merged.CommonStackCount(); n != 5 {
+		t.Errorf("CommonStackCount() = %d, want 5", n)
+	}
+	for i, source := range merged.Sources {
+		if source.TotalTime != 1 || source.Callstacks != 5 || source.Samples != 8 {
+			t.Errorf("Sources[%d] = %+v, want 1s in 5 callstacks from 8 samples", i, source)
+		}
+	}
+	if merged.Profile.Stats.NumSamples != 16 || merged.Profile.Stats.Duration != "2" {
+		t.Errorf("Stats = %+v, want 16 samples over 2s", merged.Profile.Stats)
+	}
+
+	hotspots := FindHotspots(merged.Profile, 1, SortByInclusive)
+	if len(hotspots) != 1 || hotspots[0].Function != "main" || hotspots[0].InclusiveTime != 2 {
+		t.Errorf("top inclusive hotspot = %+v, want main with 2s", hotspots)
+	}
+}
+
+func TestMergeProfilesPartialOverlap(t *testing.T) {
+	focused, err := FilterProfile(testProfile(), FilterOptions{Focus: "render"})
+	if err != nil {
+		t.Fatalf("FilterProfile() error = %v", err)
+	}
+	merged, err := MergeProfiles([]string{"all", "render"}, []*sleepy.ProfileData{testProfile(), focused})
+	if err != nil {
+		t.Fatalf("MergeProfiles() error = %v", err)
+	}
+
+	if n := merged.CommonStackCount(); n != 2 {
+		t.Errorf("CommonStackCount() = %d, want 2", n)
+	}
+	if got := merged.Sources[1].TotalTime; got != 0.375 {
+		t.Errorf("Sources[1].TotalTime = %v, want 0.375", got)
+	}
+}
+
+func TestMergeProfilesErrors(t *testing.T) {
+	if _, err := MergeProfiles(nil, nil); err == nil {
+		t.Errorf("MergeProfiles(nil) error = nil, want an error")
+	}
+	if _, err := MergeProfiles([]string{"a"}, []*sleepy.ProfileData{testProfile(), testProfile()}); err == nil {
+		t.Errorf("MergeProfiles() with too few names error = nil, want an error")
+	}
+}
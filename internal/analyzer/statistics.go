@@ -1,272 +1,294 @@
-package analyzer
-
-import (
-	"fmt"
-	"math"
-	"sort"
-
-	"verysleepy-mcp/internal/sleepy"
-)
-
-// ProfileStatistics contains comprehensive statistics about the profile
-type ProfileStatistics struct {
-	TotalTime        float64
-	TotalCallstacks  int
-	TotalSymbols     int
-	AverageStackDepth float64
-	MaxStackDepth    int
-	MinStackDepth    int
-	UniqueModules    int
-	UniqueFunctions  int
-}
-
-// ComputeStatistics calculates comprehensive statistics for the profile
-func ComputeStatistics(profile *sleepy.ProfileData) ProfileStatistics {
-	stats := ProfileStatistics{}
-
-	// Total callstacks and symbols
-	stats.TotalCallstacks = len(profile.Callstacks)
-	stats.TotalSymbols = len(profile.Symbols)
-
-	if stats.TotalCallstacks == 0 {
-		return stats
-	}
-
-	// Calculate time and stack depth statistics
-	totalDepth := 0
-	stats.MinStackDepth = math.MaxInt32
-	stats.MaxStackDepth = 0
-
-	moduleSet := make(map[string]bool)
-	functionSet := make(map[string]bool)
-
-	for _, cs := range profile.Callstacks {
-		duration := cs.GetDuration()
-		stats.TotalTime += duration
-
-		depth := len(cs.Addresses)
-		totalDepth += depth
-
-		if depth > stats.MaxStackDepth {
-			stats.MaxStackDepth = depth
-		}
-		if depth < stats.MinStackDepth {
-			stats.MinStackDepth = depth
-		}
-
-		// Track unique modules and functions
-		frames := profile.ResolveCallstack(&cs)
-		for _, frame := range frames {
-			if frame.Module != "" && frame.Module != "?" {
-				moduleSet[frame.Module] = true
-			}
-			funcSig := fmt.Sprintf("%s!%s", frame.Module, frame.Function)
-			functionSet[funcSig] = true
-		}
-	}
-
-	stats.AverageStackDepth = float64(totalDepth) / float64(stats.TotalCallstacks)
-	stats.UniqueModules = len(moduleSet)
-	stats.UniqueFunctions = len(functionSet)
-
-	if stats.MinStackDepth == math.MaxInt32 {
-		stats.MinStackDepth = 0
-	}
-
-	return stats
-}
-
-// FunctionCallFrequency represents how often a function appears
-type FunctionCallFrequency struct {
-	Function string
-	Module   string
-	Count    int
-	Percentage float64
-}
-
-// GetFunctionCallFrequencies returns functions sorted by how often they appear in callstacks
-func GetFunctionCallFrequencies(profile *sleepy.ProfileData) []FunctionCallFrequency {
-	funcCount := make(map[string]*FunctionCallFrequency)
-	totalStacks := len(profile.Callstacks)
-
-	for _, cs := range profile.Callstacks {
-		frames := profile.ResolveCallstack(&cs)
-		seen := make(map[string]bool)
-
-		for _, frame := range frames {
-			funcSig := fmt.Sprintf("%s!%s", frame.Module, frame.Function)
-
-			if seen[funcSig] {
-				continue
-			}
-			seen[funcSig] = true
-
-			if _, exists := funcCount[funcSig]; !exists {
-				funcCount[funcSig] = &FunctionCallFrequency{
-					Function: frame.Function,
-					Module:   frame.Module,
-					Count:    0,
-				}
-			}
-			funcCount[funcSig].Count++
-		}
-	}
-
-	// Convert to slice and calculate percentages
-	frequencies := make([]FunctionCallFrequency, 0, len(funcCount))
-	for _, freq := range funcCount {
-		if totalStacks > 0 {
-			freq.Percentage = (float64(freq.Count) / float64(totalStacks)) * 100.0
-		}
-		frequencies = append(frequencies, *freq)
-	}
-
-	// Sort by count (descending)
-	sort.Slice(frequencies, func(i, j int) bool {
-		return frequencies[i].Count > frequencies[j].Count
-	})
-
-	return frequencies
-}
-
-// CallstackPattern represents a common callstack pattern
-type CallstackPattern struct {
-	Pattern     string   // Human-readable pattern
-	Frames      []string // Function signatures in the pattern
-	Occurrences int
-	TotalTime   float64
-	Percentage  float64
-}
-
-// FindCommonCallstackPatterns identifies frequently occurring callstack patterns
-// depth: number of frames to consider for pattern matching (from bottom)
-func FindCommonCallstackPatterns(profile *sleepy.ProfileData, depth int, topN int) []CallstackPattern {
-	patterns := make(map[string]*CallstackPattern)
-	totalTime := 0.0
-
-	for _, cs := range profile.Callstacks {
-		duration := cs.GetDuration()
-		totalTime += duration
-
-		frames := profile.ResolveCallstack(&cs)
-
-		// Take bottom N frames for pattern
-		patternDepth := depth
-		if patternDepth > len(frames) {
-			patternDepth = len(frames)
-		}
-
-		patternFrames := make([]string, patternDepth)
-		for i := 0; i < patternDepth; i++ {
-			frame := frames[i]
-			patternFrames[i] = fmt.Sprintf("%s!%s", frame.Module, frame.Function)
-		}
-
-		patternKey := fmt.Sprintf("%v", patternFrames)
-
-		if _, exists := patterns[patternKey]; !exists {
-			patterns[patternKey] = &CallstackPattern{
-				Frames:      patternFrames,
-				Occurrences: 0,
-				TotalTime:   0,
-			}
-		}
-
-		p := patterns[patternKey]
-		p.Occurrences++
-		p.TotalTime += duration
-	}
-
-	// Convert to slice and calculate percentages
-	patternList := make([]CallstackPattern, 0, len(patterns))
-	for _, p := range patterns {
-		if totalTime > 0 {
-			p.Percentage = (p.TotalTime / totalTime) * 100.0
-		}
-		// Create human-readable pattern
-		p.Pattern = fmt.Sprintf("%s", p.Frames)
-		patternList = append(patternList, *p)
-	}
-
-	// Sort by total time (descending)
-	sort.Slice(patternList, func(i, j int) bool {
-		return patternList[i].TotalTime > patternList[j].TotalTime
-	})
-
-	if topN > 0 && topN < len(patternList) {
-		return patternList[:topN]
-	}
-	return patternList
-}
-
-// DetectPerformanceIssues performs heuristic analysis to detect potential issues
-type PerformanceIssue struct {
-	Severity    string // "Critical", "High", "Medium", "Low"
-	Category    string // e.g., "Deep Recursion", "Hot Loop", "Expensive Function"
-	Description string
-	Function    string
-	Module      string
-	Impact      float64 // % of total time
-}
-
-// DetectPerformanceIssues identifies potential performance problems
-func DetectPerformanceIssues(profile *sleepy.ProfileData) []PerformanceIssue {
-	issues := []PerformanceIssue{}
-	stats := ComputeStatistics(profile)
-
-	// Detect extremely deep callstacks (potential stack overflow or deep recursion)
-	if stats.MaxStackDepth > 50 {
-		issues = append(issues, PerformanceIssue{
-			Severity:    "High",
-			Category:    "Deep Call Stack",
-			Description: fmt.Sprintf("Maximum stack depth of %d frames detected. This may indicate deep recursion or complex call chains.", stats.MaxStackDepth),
-			Impact:      0,
-		})
-	}
-
-	// Find functions consuming >10% of total time
-	hotspots := FindHotspots(profile, 10)
-	for _, hs := range hotspots {
-		if hs.Percentage > 20.0 {
-			issues = append(issues, PerformanceIssue{
-				Severity:    "Critical",
-				Category:    "CPU Hotspot",
-				Description: fmt.Sprintf("Function consumes %.2f%% of total execution time", hs.Percentage),
-				Function:    hs.Function,
-				Module:      hs.Module,
-				Impact:      hs.Percentage,
-			})
-		} else if hs.Percentage > 10.0 {
-			issues = append(issues, PerformanceIssue{
-				Severity:    "High",
-				Category:    "CPU Hotspot",
-				Description: fmt.Sprintf("Function consumes %.2f%% of total execution time", hs.Percentage),
-				Function:    hs.Function,
-				Module:      hs.Module,
-				Impact:      hs.Percentage,
-			})
-		}
-	}
-
-	// Detect functions with very high sample counts (hot loops)
-	frequencies := GetFunctionCallFrequencies(profile)
-	for _, freq := range frequencies {
-		if freq.Percentage > 80.0 {
-			issues = append(issues, PerformanceIssue{
-				Severity:    "Critical",
-				Category:    "Hot Loop",
-				Description: fmt.Sprintf("Function appears in %.2f%% of all callstacks - likely in a hot loop", freq.Percentage),
-				Function:    freq.Function,
-				Module:      freq.Module,
-				Impact:      freq.Percentage,
-			})
-		}
-	}
-
-	// Sort by impact (descending)
-	sort.Slice(issues, func(i, j int) bool {
-		return issues[i].Impact > issues[j].Impact
-	})
-
-	return issues
-}
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// ProfileStatistics contains comprehensive statistics about the profile
+type ProfileStatistics struct {
+	TotalTime         float64 `json:"total_time"`
+	TotalCallstacks   int     `json:"total_callstacks"`
+	TotalSymbols      int     `json:"total_symbols"`
+	AverageStackDepth float64 `json:"average_stack_depth"`
+	MaxStackDepth     int     `json:"max_stack_depth"`
+	MinStackDepth     int     `json:"min_stack_depth"`
+	UniqueModules     int     `json:"unique_modules"`
+	UniqueFunctions   int     `json:"unique_functions"`
+}
+
+// ComputeStatistics calculates comprehensive statistics for the profile
+func ComputeStatistics(profile *sleepy.ProfileData) ProfileStatistics {
+	stats := ProfileStatistics{}
+
+	// Total callstacks and symbols
+	stats.TotalCallstacks = len(profile.Callstacks)
+	stats.TotalSymbols = len(profile.Symbols)
+
+	if stats.TotalCallstacks == 0 {
+		return stats
+	}
+
+	// Calculate time and stack depth statistics
+	totalDepth := 0
+	stats.MinStackDepth = math.MaxInt32
+	stats.MaxStackDepth = 0
+
+	ix := profile.Index()
+	moduleSeen := make([]bool, len(ix.Modules))
+	functionSeen := make([]bool, len(ix.Functions))
+
+	for csIdx, stack := range ix.Stacks {
+		stats.TotalTime += ix.Durations[csIdx]
+
+		depth := len(stack)
+		totalDepth += depth
+
+		if depth > stats.MaxStackDepth {
+			stats.MaxStackDepth = depth
+		}
+		if depth < stats.MinStackDepth {
+			stats.MinStackDepth = depth
+		}
+
+		// Track unique modules and functions
+		for _, funcID := range stack {
+			functionSeen[funcID] = true
+			moduleSeen[ix.Functions[funcID].ModuleID] = true
+		}
+	}
+
+	stats.AverageStackDepth = float64(totalDepth) / float64(stats.TotalCallstacks)
+	for moduleID, seen := range moduleSeen {
+		if module := ix.Modules[moduleID]; seen && module != "" && module != "?" {
+			stats.UniqueModules++
+		}
+	}
+	for _, seen := range functionSeen {
+		if seen {
+			stats.UniqueFunctions++
+		}
+	}
+
+	if stats.MinStackDepth == math.MaxInt32 {
+		stats.MinStackDepth = 0
+	}
+
+	return stats
+}
+
+// FunctionCallFrequency represents how often a function appears
+type FunctionCallFrequency struct {
+	Function   string  `json:"function"`
+	Module     string  `json:"module"`
+	Count      int     `json:"count"`
+	Percentage float64 `json:"percentage"`
+}
+
+// GetFunctionCallFrequencies returns functions sorted by how often they appear in callstacks
+func GetFunctionCallFrequencies(profile *sleepy.ProfileData) []FunctionCallFrequency {
+	ix := profile.Index()
+	counts := make([]int, len(ix.Functions))
+	lastStack := newStackMarks(len(ix.Functions))
+	totalStacks := len(ix.Stacks)
+
+	for csIdx, stack := range ix.Stacks {
+		for _, funcID := range stack {
+			if lastStack.mark(funcID, csIdx) {
+				counts[funcID]++
+			}
+		}
+	}
+
+	// Convert to slice and calculate percentages
+	frequencies := make([]FunctionCallFrequency, 0, len(counts))
+	for funcID, count := range counts {
+		if count == 0 {
+			continue
+		}
+		freq := FunctionCallFrequency{
+			Function: ix.Functions[funcID].Function,
+			Module:   ix.Functions[funcID].Module,
+			Count:    count,
+		}
+		if totalStacks > 0 {
+			freq.Percentage = (float64(count) / float64(totalStacks)) * 100.0
+		}
+		frequencies = append(frequencies, freq)
+	}
+
+	// Sort by count (descending)
+	sort.Slice(frequencies, func(i, j int) bool {
+		return frequencies[i].Count > frequencies[j].Count
+	})
+
+	return frequencies
+}
+
+// CallstackPattern represents a common callstack pattern
+type CallstackPattern struct {
+	Pattern     string   `json:"pattern"` // Human-readable pattern
+	Frames      []string `json:"frames"`  // Function signatures in the pattern, outermost caller first
+	Occurrences int      `json:"occurrences"`
+	TotalTime   float64  `json:"total_time"`
+	Percentage  float64  `json:"percentage"`
+}
+
+// Pattern anchors accepted by FindCommonCallstackPatterns
+const (
+	AnchorLeaf = "leaf" // Patterns are the innermost frames (recurring leaf clusters)
+	AnchorRoot = "root" // Patterns are the outermost frames (recurring entry paths)
+)
+
+// PatternOptions controls how callstack patterns are extracted and filtered
+type PatternOptions struct {
+	Depth          int     // Number of frames per pattern
+	Anchor         string  // AnchorLeaf or AnchorRoot (default: AnchorLeaf)
+	TopN           int     // Maximum number of patterns to return (0 = all)
+	MinOccurrences int     // Minimum number of callstacks a pattern must appear in
+	MinPercent     float64 // Minimum percentage of total time a pattern must account for
+}
+
+// FindCommonCallstackPatterns identifies frequently occurring callstack patterns
+// Patterns are taken from the leaf or root end of each callstack, depending on opts.Anchor
+func FindCommonCallstackPatterns(profile *sleepy.ProfileData, opts PatternOptions) []CallstackPattern {
+	ix := profile.Index()
+	patterns := make(map[string]*CallstackPattern)
+	totalTime := ix.TotalTime
+
+	for csIdx, stack := range ix.Stacks {
+		duration := ix.Durations[csIdx]
+
+		patternDepth := opts.Depth
+		if patternDepth > len(stack) {
+			patternDepth = len(stack)
+		}
+
+		// Frames are leaf-first; pick the anchored slice and store it outermost caller first
+		start := 0
+		if opts.Anchor == AnchorRoot {
+			start = len(stack) - patternDepth
+		}
+		patternFrames := make([]string, patternDepth)
+		for i := 0; i < patternDepth; i++ {
+			patternFrames[i] = ix.Functions[stack[start+patternDepth-1-i]].Signature
+		}
+
+		// Module and function names never contain NUL, so this key is unambiguous
+		patternKey := strings.Join(patternFrames, "\x00")
+
+		if _, exists := patterns[patternKey]; !exists {
+			patterns[patternKey] = &CallstackPattern{
+				Pattern:     strings.Join(patternFrames, " -> "),
+				Frames:      patternFrames,
+				Occurrences: 0,
+				TotalTime:   0,
+			}
+		}
+
+		p := patterns[patternKey]
+		p.Occurrences++
+		p.TotalTime += duration
+	}
+
+	// Convert to slice, calculate percentages and apply thresholds
+	patternList := make([]CallstackPattern, 0, len(patterns))
+	for _, p := range patterns {
+		if totalTime > 0 {
+			p.Percentage = (p.TotalTime / totalTime) * 100.0
+		}
+		if p.Occurrences < opts.MinOccurrences || p.Percentage < opts.MinPercent {
+			continue
+		}
+		patternList = append(patternList, *p)
+	}
+
+	// Sort by total time (descending)
+	sort.Slice(patternList, func(i, j int) bool {
+		if patternList[i].TotalTime != patternList[j].TotalTime {
+			return patternList[i].TotalTime > patternList[j].TotalTime
+		}
+		return patternList[i].Pattern < patternList[j].Pattern
+	})
+
+	if opts.TopN > 0 && opts.TopN < len(patternList) {
+		return patternList[:opts.TopN]
+	}
+	return patternList
+}
+
+// DetectPerformanceIssues performs heuristic analysis to detect potential issues
+type PerformanceIssue struct {
+	Severity    string  `json:"severity"` // "Critical", "High", "Medium", "Low"
+	Category    string  `json:"category"` // e.g., "Deep Recursion", "Hot Loop", "Expensive Function"
+	Description string  `json:"description"`
+	Function    string  `json:"function"`
+	Module      string  `json:"module"`
+	Impact      float64 `json:"impact"` // % of total time
+}
+
+// DetectPerformanceIssues identifies potential performance problems
+func DetectPerformanceIssues(profile *sleepy.ProfileData) []PerformanceIssue {
+	issues := []PerformanceIssue{}
+	stats := ComputeStatistics(profile)
+
+	// Detect extremely deep callstacks (potential stack overflow or deep recursion)
+	if stats.MaxStackDepth > 50 {
+		issues = append(issues, PerformanceIssue{
+			Severity:    "High",
+			Category:    "Deep Call Stack",
+			Description: fmt.Sprintf("Maximum stack depth of %d frames detected. This may indicate deep recursion or complex call chains.", stats.MaxStackDepth),
+			Impact:      0,
+		})
+	}
+
+	// Find functions consuming >10% of total time
+	hotspots := FindHotspots(profile, 10, SortByInclusive)
+	for _, hs := range hotspots {
+		if hs.InclusivePercentage > 20.0 {
+			issues = append(issues, PerformanceIssue{
+				Severity:    "Critical",
+				Category:    "CPU Hotspot",
+				Description: fmt.Sprintf("Function consumes %.2f%% of total execution time", hs.InclusivePercentage),
+				Function:    hs.Function,
+				Module:      hs.Module,
+				Impact:      hs.InclusivePercentage,
+			})
+		} else if hs.InclusivePercentage > 10.0 {
+			issues = append(issues, PerformanceIssue{
+				Severity:    "High",
+				Category:    "CPU Hotspot",
+				Description: fmt.Sprintf("Function consumes %.2f%% of total execution time", hs.InclusivePercentage),
+				Function:    hs.Function,
+				Module:      hs.Module,
+				Impact:      hs.InclusivePercentage,
+			})
+		}
+	}
+
+	// Detect functions with very high sample counts (hot loops)
+	frequencies := GetFunctionCallFrequencies(profile)
+	for _, freq := range frequencies {
+		if freq.Percentage > 80.0 {
+			issues = append(issues, PerformanceIssue{
+				Severity:    "Critical",
+				Category:    "Hot Loop",
+				Description: fmt.Sprintf("Function appears in %.2f%% of all callstacks - likely in a hot loop", freq.Percentage),
+				Function:    freq.Function,
+				Module:      freq.Module,
+				Impact:      freq.Percentage,
+			})
+		}
+	}
+
+	// Sort by impact (descending)
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].Impact > issues[j].Impact
+	})
+
+	return issues
+}
@@ -0,0 +1,63 @@
+package analyzer
+
+import (
+	"testing"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+func TestComputeStatistics(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile *sleepy.ProfileData
+		want    ProfileStatistics
+	}{
+		{
+			name:    "loaded",
+			profile: testProfile(),
+			want: ProfileStatistics{TotalTime: 1, TotalCallstacks: 5, TotalSymbols: 6,
+				AverageStackDepth: 2.8, MaxStackDepth: 3, MinStackDepth: 2, UniqueModules: 2, UniqueFunctions: 5},
+		},
+		{
+			// The unresolved module "?" is not counted, its function is
+			name:    "unknown address",
+			profile: withUnknownFrame(testProfile()),
+			want: ProfileStatistics{TotalTime: 1.5, TotalCallstacks: 6, TotalSymbols: 6,
+				AverageStackDepth: 17.0 / 6, MaxStackDepth: 3, MinStackDepth: 2, UniqueModules: 2, UniqueFunctions: 6},
+		},
+		{
+			name:    "no callstacks",
+			profile: testProfile().WithCallstacks(nil),
+			want:    ProfileStatistics{TotalSymbols: 6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ComputeStatistics(tt.profile); got != tt.want {
+				t.Errorf("ComputeStatistics() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFunctionCallFrequencies(t *testing.T) {
+	frequencies := GetFunctionCallFrequencies(testProfile())
+
+	// Recursive parse counts once for the callstack it recurses in
+	want := map[string]int{"app!main": 5, "app!parse": 3, "libc!malloc": 2, "app!render": 2, "libc!memcpy": 1}
+	if len(frequencies) != len(want) {
+		t.Fatalf("got %d functions, want %d: %+v", len(frequencies), len(want), frequencies)
+	}
+	for i, f := range frequencies {
+		funcSig := f.Module + "!" + f.Function
+		if f.Count != want[funcSig] {
+			t.Errorf("%s Count = %d, want %d", funcSig, f.Count, want[funcSig])
+		}
+		if pct := float64(want[funcSig]) * 20; f.Percentage != pct {
+			t.Errorf("%s Percentage = %v, want %v", funcSig, f.Percentage, pct)
+		}
+		if i > 0 && f.Count > frequencies[i-1].Count {
+			t.Errorf("frequencies not sorted by count: %+v", frequencies)
+		}
+	}
+}
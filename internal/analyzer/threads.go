@@ -0,0 +1,66 @@
+package analyzer
+
+import (
+	"sort"
+	"strconv"
+
+	"verysleepy-mcp/internal/sleepy"
+)
+
+// ThreadSummary describes how much of the profile a single thread accounts for
+type ThreadSummary struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"` // From Threads.txt; empty if the thread is not listed
+	TotalTime    float64   `json:"total_time"`
+	Percentage   float64   `json:"percentage"`    // Percentage of total profile time
+	SampleCount  int       `json:"sample_count"`  // Number of callstacks containing time from this thread
+	TopFunctions []Hotspot `json:"top_functions"` // Functions with the most self time on this thread
+}
+
+// AnalyzeThreads breaks the profile down per thread, listing each thread's time and
+// its top self-time functions. Threads from Threads.txt without any time are included
+// with zero time. Returns threads sorted by total time (descending).
+func AnalyzeThreads(profile *sleepy.ProfileData, topFunctions int) []ThreadSummary {
+	threadMap := make(map[int]*ThreadSummary)
+	for _, t := range profile.Threads {
+		threadMap[t.ID] = &ThreadSummary{ID: t.ID, Name: t.Name}
+	}
+
+	totalProfileTime := 0.0
+	for _, cs := range profile.Callstacks {
+		for threadID, d := range cs.ThreadCounts {
+			if _, exists := threadMap[threadID]; !exists {
+				threadMap[threadID] = &ThreadSummary{ID: threadID, Name: profile.ThreadName(threadID)}
+			}
+			ts := threadMap[threadID]
+			ts.TotalTime += d
+			ts.SampleCount++
+			totalProfileTime += d
+		}
+	}
+
+	threads := make([]ThreadSummary, 0, len(threadMap))
+	for _, ts := range threadMap {
+		if totalProfileTime > 0 {
+			ts.Percentage = (ts.TotalTime / totalProfileTime) * 100.0
+		}
+
+		if ts.SampleCount > 0 {
+			threadProfile, err := FilterProfile(profile, FilterOptions{Thread: strconv.Itoa(ts.ID)})
+			if err == nil {
+				ts.TopFunctions = FindBottomFunctions(threadProfile, topFunctions)
+			}
+		}
+
+		threads = append(threads, *ts)
+	}
+
+	sort.Slice(threads, func(i, j int) bool {
+		if threads[i].TotalTime != threads[j].TotalTime {
+			return threads[i].TotalTime > threads[j].TotalTime
+		}
+		return threads[i].ID < threads[j].ID
+	})
+
+	return threads
+}
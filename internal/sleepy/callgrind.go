@@ -0,0 +1,612 @@
+package sleepy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// callgrindMaxDepth bounds the callstacks rebuilt from a callgrind call graph
+const callgrindMaxDepth = 256
+
+// callgrindMinShare is the smallest share of the total cost a rebuilt callstack may carry;
+// lighter caller paths are cut short at the frame where they fall below it
+const callgrindMinShare = 1e-6
+
+// callgrindFunc identifies a function in a callgrind file
+type callgrindFunc struct {
+	Object string
+	Name   string
+}
+
+// callgrindCall is an aggregated call from a call site to a function
+type callgrindCall struct {
+	Caller callgrindFunc
+	Site   uint64 // Symbol address of the call site (caller, file, line)
+	Callee callgrindFunc
+	Cost   float64 // Inclusive cost of the call, in seconds
+}
+
+// ReadCallgrindProfile reads a Valgrind callgrind.out file and maps it onto ProfileData.
+// The first event is used as the cost; its units are read as nanoseconds (so instruction
+// counts read as one instruction per nanosecond). Each distinct object+function+file+line
+// gets one synthetic symbol address. Callgrind stores a call graph rather than callstacks,
+// so every function's self cost is spread over its callers in proportion to the inclusive
+// cost of each call, walking up to the roots of the graph.
+func ReadCallgrindProfile(filePath string) (*ProfileData, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open callgrind file: %w", err)
+	}
+	defer f.Close()
+
+	return parseCallgrind(f)
+}
+
+func parseCallgrind(r io.Reader) (*ProfileData, error) {
+	pd := &ProfileData{}
+
+	symbolAddrs := make(map[string]uint64)
+	addSymbol := func(sym Symbol) uint64 {
+		key := fmt.Sprintf("%s\x00%s\x00%s\x00%d", sym.ModuleName, sym.ProcName, sym.FilePath, sym.LineNumber)
+		if addr, exists := symbolAddrs[key]; exists {
+			return addr
+		}
+		addr := uint64(len(pd.Symbols)+1) << 4
+		sym.Address = fmt.Sprintf("0x%x", addr)
+		pd.Symbols = append(pd.Symbols, sym)
+		symbolAddrs[key] = addr
+		return addr
+	}
+
+	// Name compression: "(id) name" defines an ID, "(id)" refers back to it.
+	// Objects, files and functions are numbered separately.
+	objects := make(map[string]string)
+	files := make(map[string]string)
+	functions := make(map[string]string)
+	expand := func(table map[string]string, value string) string {
+		value = strings.TrimSpace(value)
+		if !strings.HasPrefix(value, "(") {
+			return value
+		}
+		end := strings.Index(value, ")")
+		if end < 0 {
+			return value
+		}
+		id, name := value[1:end], strings.TrimSpace(value[end+1:])
+		if name == "" {
+			return table[id]
+		}
+		table[id] = name
+		return name
+	}
+
+	positions := []string{"line"}
+	linePos := 0
+	lastPositions := make([]int64, len(positions))
+
+	var object, fnFile, file string
+	var fn callgrindFunc
+	var calleeObject string
+	var callee *callgrindFunc
+	inCall, inJump := false, false
+	threadID := UnknownThreadID
+
+	selfCosts := make(map[uint64]float64)
+	symbolFuncs := make(map[uint64]callgrindFunc)
+	callIndex := make(map[string]int)
+	var calls []callgrindCall
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if key, value, found := strings.Cut(line, "="); found && isCallgrindSpec(key) {
+			switch key {
+			case "ob":
+				object = expand(objects, value)
+			case "fl":
+				fnFile = expand(files, value)
+				file = fnFile
+			case "fi", "fe":
+				file = expand(files, value)
+			case "fn":
+				fn = callgrindFunc{Object: object, Name: expand(functions, value)}
+				file = fnFile
+			case "cob":
+				calleeObject = expand(objects, value)
+			case "cfi", "cfl":
+				// Functions are identified by object and name; the file only registers its ID
+				expand(files, value)
+			case "cfn":
+				obj := calleeObject
+				if obj == "" {
+					obj = object
+				}
+				callee = &callgrindFunc{Object: obj, Name: expand(functions, value)}
+			case "jump", "jcnd":
+				inJump = true
+			case "calls":
+				if callee == nil {
+					return nil, fmt.Errorf("calls= without cfn= at line %d", lineNumber)
+				}
+				inCall = true
+			}
+			continue
+		}
+
+		if key, value, found := strings.Cut(line, ":"); found && !isCallgrindCostLine(line) {
+			switch strings.TrimSpace(key) {
+			case "positions":
+				positions = strings.Fields(value)
+				linePos = -1
+				for i, p := range positions {
+					if p == "line" {
+						linePos = i
+					}
+				}
+				lastPositions = make([]int64, len(positions))
+			case "cmd":
+				pd.Stats.Filename = strings.TrimSpace(value)
+			case "thread":
+				threadID, _ = strconv.Atoi(strings.TrimSpace(value))
+			case "pid":
+				if threadID == UnknownThreadID {
+					threadID, _ = strconv.Atoi(strings.TrimSpace(value))
+				}
+			}
+			continue
+		}
+
+		if !isCallgrindCostLine(line) {
+			return nil, fmt.Errorf("malformed callgrind line %d: %q", lineNumber, line)
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < len(positions) {
+			return nil, fmt.Errorf("cost line %d has fewer than %d positions: %q", lineNumber, len(positions), line)
+		}
+		for i := range positions {
+			pos, err := parseCallgrindPosition(fields[i], lastPositions[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid position at line %d: %w", lineNumber, err)
+			}
+			lastPositions[i] = pos
+		}
+		if inJump {
+			// The line after jump=/jcnd= is the jump's source position, not a cost,
+			// but later relative positions still count from it
+			inJump = false
+			continue
+		}
+
+		cost := 0.0
+		if len(fields) > len(positions) {
+			v, err := strconv.ParseFloat(fields[len(positions)], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cost %q at line %d: %w", fields[len(positions)], lineNumber, err)
+			}
+			cost = v / 1e9
+		}
+		srcLine := 0
+		if linePos >= 0 {
+			srcLine = int(lastPositions[linePos])
+		}
+
+		addr := addSymbol(Symbol{
+			ModuleName: callgrindModuleName(fn.Object),
+			ProcName:   fn.Name,
+			FilePath:   callgrindFileName(file),
+			LineNumber: srcLine,
+		})
+		symbolFuncs[addr] = fn
+
+		if !inCall {
+			selfCosts[addr] += cost
+			continue
+		}
+
+		// The cost line after calls= is the call site and the call's inclusive cost
+		key := fmt.Sprintf("%x\x00%s\x00%s", addr, callee.Object, callee.Name)
+		if idx, exists := callIndex[key]; exists {
+			calls[idx].Cost += cost
+		} else {
+			callIndex[key] = len(calls)
+			calls = append(calls, callgrindCall{Caller: fn, Site: addr, Callee: *callee, Cost: cost})
+		}
+		inCall = false
+		callee = nil
+		calleeObject = ""
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading callgrind file: %w", err)
+	}
+
+	pd.Callstacks = rebuildCallgrindStacks(selfCosts, symbolFuncs, calls, threadID)
+
+	if threadID != UnknownThreadID {
+		name := "?"
+		if args := strings.Fields(pd.Stats.Filename); len(args) > 0 {
+			name = path.Base(args[0])
+		}
+		pd.Threads = append(pd.Threads, Thread{ID: threadID, Name: name})
+	}
+
+	total := 0.0
+	for i := range pd.Callstacks {
+		total += pd.Callstacks[i].GetDuration()
+	}
+	pd.Stats.Duration = strconv.FormatFloat(total, 'f', -1, 64)
+
+	pd.buildSymbolMap()
+
+	return pd, nil
+}
+
+// rebuildCallgrindStacks turns self costs and aggregated calls into callstacks. A function's
+// cost is split between its callers in proportion to the inclusive cost of their calls; the
+// part of its inclusive cost not covered by any caller stays at the root.
+func rebuildCallgrindStacks(selfCosts map[uint64]float64, symbolFuncs map[uint64]callgrindFunc, calls []callgrindCall, threadID int) []Callstack {
+	incoming := make(map[callgrindFunc][]callgrindCall)
+	incomingCost := make(map[callgrindFunc]float64)
+	inclusive := make(map[callgrindFunc]float64)
+	total := 0.0
+
+	for addr, cost := range selfCosts {
+		inclusive[symbolFuncs[addr]] += cost
+		total += cost
+	}
+	for _, c := range calls {
+		if c.Caller == c.Callee {
+			continue // Direct recursion adds nothing a callstack can show
+		}
+		incoming[c.Callee] = append(incoming[c.Callee], c)
+		incomingCost[c.Callee] += c.Cost
+		inclusive[c.Caller] += c.Cost
+	}
+	for f := range incoming {
+		sort.Slice(incoming[f], func(i, j int) bool { return incoming[f][i].Site < incoming[f][j].Site })
+	}
+
+	minWeight := total * callgrindMinShare
+	stackIndex := make(map[string]int)
+	var callstacks []Callstack
+
+	emit := func(addresses []uint64, weight float64) {
+		if weight <= 0 {
+			return
+		}
+		var key strings.Builder
+		for _, addr := range addresses {
+			key.WriteString(strconv.FormatUint(addr, 16))
+			key.WriteByte(' ')
+		}
+		if idx, exists := stackIndex[key.String()]; exists {
+			callstacks[idx].ThreadCounts[threadID] += weight
+			return
+		}
+		stackIndex[key.String()] = len(callstacks)
+		callstacks = append(callstacks, Callstack{
+			Addresses:    append([]uint64(nil), addresses...),
+			ThreadCounts: map[int]float64{threadID: weight},
+		})
+	}
+
+	var walk func(addresses []uint64, fn callgrindFunc, weight float64, onStack map[callgrindFunc]bool)
+	walk = func(addresses []uint64, fn callgrindFunc, weight float64, onStack map[callgrindFunc]bool) {
+		var callers []callgrindCall
+		callerCost := 0.0
+		for _, c := range incoming[fn] {
+			if !onStack[c.Caller] && c.Cost > 0 {
+				callers = append(callers, c)
+				callerCost += c.Cost
+			}
+		}
+		if len(callers) == 0 || len(addresses) >= callgrindMaxDepth {
+			emit(addresses, weight)
+			return
+		}
+
+		// Share of the function's inclusive cost that was not reached through a call
+		rootShare := 0.0
+		if incl := inclusive[fn]; incl > 0 && incl > incomingCost[fn] {
+			rootShare = (incl - incomingCost[fn]) / incl
+		}
+		emit(addresses, weight*rootShare)
+		weight -= weight * rootShare
+
+		remainder := 0.0
+		onStack[fn] = true
+		for _, c := range callers {
+			w := weight * c.Cost / callerCost
+			if w < minWeight {
+				remainder += w
+				continue
+			}
+			walk(append(addresses, c.Site), c.Caller, w, onStack)
+		}
+		delete(onStack, fn)
+		emit(addresses, remainder)
+	}
+
+	leaves := make([]uint64, 0, len(selfCosts))
+	for addr := range selfCosts {
+		leaves = append(leaves, addr)
+	}
+	sort.Slice(leaves, func(i, j int) bool { return leaves[i] < leaves[j] })
+
+	for _, addr := range leaves {
+		walk([]uint64{addr}, symbolFuncs[addr], selfCosts[addr], make(map[callgrindFunc]bool))
+	}
+
+	return callstacks
+}
+
+// isCallgrindSpec reports whether key is a callgrind position or call specification
+func isCallgrindSpec(key string) bool {
+	switch key {
+	case "ob", "fl", "fi", "fe", "fn", "cob", "cfi", "cfl", "cfn", "calls", "jump", "jcnd":
+		return true
+	}
+	return false
+}
+
+// isCallgrindCostLine reports whether line starts with a position: a number, "+n", "-n" or "*"
+func isCallgrindCostLine(line string) bool {
+	c := line[0]
+	return (c >= '0' && c <= '9') || c == '+' || c == '-' || c == '*'
+}
+
+// parseCallgrindPosition parses an absolute, relative ("+n"/"-n") or repeated ("*") position
+func parseCallgrindPosition(s string, last int64) (int64, error) {
+	switch {
+	case s == "*":
+		return last, nil
+	case strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-"):
+		delta, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return 0, err
+		}
+		return last + delta, nil
+	}
+	pos, err := strconv.ParseUint(s, 0, 64)
+	return int64(pos), err
+}
+
+// callgrindModuleName maps a callgrind object to a module name
+func callgrindModuleName(object string) string {
+	if object == "" || object == "???" {
+		return "?"
+	}
+	return path.Base(object)
+}
+
+// callgrindFileName maps a callgrind source file to a symbol file path
+func callgrindFileName(file string) string {
+	if file == "???" {
+		return ""
+	}
+	return file
+}
+
+// looksLikeCallgrind reports whether text starts like a callgrind file: the
+// "# callgrind format" marker, or an events: header before the first function
+func looksLikeCallgrind(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "# callgrind format"):
+			return true
+		case strings.HasPrefix(line, "events:"):
+			return true
+		case strings.HasPrefix(line, "fn="):
+			return false
+		}
+	}
+	return false
+}
+
+// WriteCallgrind writes the profile in Valgrind's callgrind format for KCachegrind and
+// QCachegrind, with one Time event in nanoseconds. Self time is written per source line
+// from each callstack's leaf symbol; caller→callee costs come from adjacent frames, at
+// the caller frame's line, counting each call site and callee once per callstack.
+// Call counts are the estimated samples of the callstacks making the call.
+func WriteCallgrind(w io.Writer, pd *ProfileData) error {
+	type function struct {
+		Module string
+		Name   string
+		File   string
+	}
+	type call struct {
+		Caller function
+		Callee function
+		Line   int // Call site line in the caller
+	}
+	type functionCosts struct {
+		Lines     map[int]float64
+		Calls     map[int]map[function]float64 // Call site line → callee → inclusive time
+		CallCount map[int]map[function]int64
+	}
+
+	frameFunction := func(frame ResolvedFrame) function {
+		return function{Module: frame.Module, Name: frame.Function, File: frame.SourceFile}
+	}
+
+	costs := make(map[function]*functionCosts)
+	firstLine := make(map[function]int)
+	get := func(f function) *functionCosts {
+		fc, exists := costs[f]
+		if !exists {
+			fc = &functionCosts{
+				Lines:     make(map[int]float64),
+				Calls:     make(map[int]map[function]float64),
+				CallCount: make(map[int]map[function]int64),
+			}
+			costs[f] = fc
+		}
+		return fc
+	}
+
+	samples := pd.EstimateSamples()
+	for i := range pd.Callstacks {
+		cs := &pd.Callstacks[i]
+		frames := pd.ResolveCallstack(cs)
+		if len(frames) == 0 {
+			continue
+		}
+		duration := cs.GetDuration()
+
+		// Calls target the lowest known line of the callee
+		for _, frame := range frames {
+			f := frameFunction(frame)
+			if l, seen := firstLine[f]; !seen || l == 0 || (frame.LineNumber != 0 && frame.LineNumber < l) {
+				firstLine[f] = frame.LineNumber
+			}
+		}
+
+		leaf := frameFunction(frames[0])
+		get(leaf).Lines[frames[0].LineNumber] += duration
+
+		// Frames are leaf-first: frames[j+1] calls frames[j]
+		seen := make(map[call]bool)
+		for j := 0; j+1 < len(frames); j++ {
+			c := call{Caller: frameFunction(frames[j+1]), Callee: frameFunction(frames[j]), Line: frames[j+1].LineNumber}
+			if seen[c] {
+				continue // Recursion: count the time once
+			}
+			seen[c] = true
+
+			fc := get(c.Caller)
+			if fc.Calls[c.Line] == nil {
+				fc.Calls[c.Line] = make(map[function]float64)
+				fc.CallCount[c.Line] = make(map[function]int64)
+			}
+			fc.Calls[c.Line][c.Callee] += duration
+			fc.CallCount[c.Line][c.Callee] += samples[i]
+		}
+	}
+
+	functions := make([]function, 0, len(costs))
+	for f := range costs {
+		functions = append(functions, f)
+	}
+	sortFunctions := func(fs []function) {
+		sort.Slice(fs, func(i, j int) bool {
+			if fs[i].Module != fs[j].Module {
+				return fs[i].Module < fs[j].Module
+			}
+			if fs[i].Name != fs[j].Name {
+				return fs[i].Name < fs[j].Name
+			}
+			return fs[i].File < fs[j].File
+		})
+	}
+	sortFunctions(functions)
+
+	// Name compression: each object, file and function name is written once with an ID
+	compress := func(ids map[string]int) func(name string) string {
+		return func(name string) string {
+			if id, exists := ids[name]; exists {
+				return fmt.Sprintf("(%d)", id)
+			}
+			ids[name] = len(ids) + 1
+			return fmt.Sprintf("(%d) %s", ids[name], name)
+		}
+	}
+	objectName := compress(make(map[string]int))
+	fileName := compress(make(map[string]int))
+	functionName := compress(make(map[string]int))
+
+	nanos := func(seconds float64) int64 { return int64(math.Round(seconds * 1e9)) }
+	orUnknown := func(s string) string {
+		if s == "" {
+			return "???"
+		}
+		return s
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# callgrind format")
+	fmt.Fprintln(bw, "version: 1")
+	fmt.Fprintln(bw, "creator: verysleepy-mcp")
+	if pd.Stats.Filename != "" {
+		fmt.Fprintf(bw, "cmd: %s\n", pd.Stats.Filename)
+	}
+	if len(pd.Threads) == 1 {
+		fmt.Fprintf(bw, "thread: %d\n", pd.Threads[0].ID)
+	}
+	fmt.Fprintln(bw, "positions: line")
+	fmt.Fprintln(bw, "event: Time : CPU time (ns)")
+	fmt.Fprintln(bw, "events: Time")
+
+	totalTime := 0.0
+	for i := range pd.Callstacks {
+		totalTime += pd.Callstacks[i].GetDuration()
+	}
+	fmt.Fprintf(bw, "summary: %d\n", nanos(totalTime))
+
+	for _, f := range functions {
+		fc := costs[f]
+		fmt.Fprintln(bw)
+		fmt.Fprintf(bw, "ob=%s\n", objectName(orUnknown(f.Module)))
+		fmt.Fprintf(bw, "fl=%s\n", fileName(orUnknown(f.File)))
+		fmt.Fprintf(bw, "fn=%s\n", functionName(f.Name))
+
+		lines := make([]int, 0, len(fc.Lines))
+		for l := range fc.Lines {
+			lines = append(lines, l)
+		}
+		sort.Ints(lines)
+		for _, l := range lines {
+			if cost := nanos(fc.Lines[l]); cost > 0 {
+				fmt.Fprintf(bw, "%d %d\n", l, cost)
+			}
+		}
+
+		siteLines := make([]int, 0, len(fc.Calls))
+		for l := range fc.Calls {
+			siteLines = append(siteLines, l)
+		}
+		sort.Ints(siteLines)
+		for _, l := range siteLines {
+			callees := make([]function, 0, len(fc.Calls[l]))
+			for callee := range fc.Calls[l] {
+				callees = append(callees, callee)
+			}
+			sortFunctions(callees)
+
+			for _, callee := range callees {
+				cost := nanos(fc.Calls[l][callee])
+				if cost <= 0 {
+					continue
+				}
+				count := fc.CallCount[l][callee]
+				if count < 1 {
+					count = 1
+				}
+				fmt.Fprintf(bw, "cob=%s\n", objectName(orUnknown(callee.Module)))
+				fmt.Fprintf(bw, "cfi=%s\n", fileName(orUnknown(callee.File)))
+				fmt.Fprintf(bw, "cfn=%s\n", functionName(callee.Name))
+				fmt.Fprintf(bw, "calls=%d %d\n", count, firstLine[callee])
+				fmt.Fprintf(bw, "%d %d\n", l, cost)
+			}
+		}
+	}
+
+	fmt.Fprintf(bw, "\ntotals: %d\n", nanos(totalTime))
+	return bw.Flush()
+}
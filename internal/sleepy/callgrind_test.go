@@ -0,0 +1,163 @@
+package sleepy
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCallgrindRoundTrip(t *testing.T) {
+	profile := testProfile()
+
+	var buf bytes.Buffer
+	if err := WriteCallgrind(&buf, profile); err != nil {
+		t.Fatalf("WriteCallgrind() error = %v", err)
+	}
+	got, err := parseCallgrind(&buf)
+	if err != nil {
+		t.Fatalf("parseCallgrind() error = %v", err)
+	}
+
+	// Callgrind has no per-callstack threads: with several threads the time stays unknown
+	want := profile.WithCallstacks(nil)
+	for i := range profile.Callstacks {
+		cs := profile.Callstacks[i]
+		want.Callstacks = append(want.Callstacks, Callstack{
+			Addresses:    cs.Addresses,
+			ThreadCounts: map[int]float64{UnknownThreadID: cs.GetDuration()},
+		})
+	}
+	assertSameStacks(t, got, want)
+
+	if got.Stats.Filename != profile.Stats.Filename {
+		t.Errorf("Filename = %q, want %q", got.Stats.Filename, profile.Stats.Filename)
+	}
+}
+
+func TestCallgrindRoundTripSingleThread(t *testing.T) {
+	want := NewProfileData(
+		Stats{Filename: "app.exe"},
+		[]Symbol{
+			{Address: "0x10", ModuleName: "app.exe", ProcName: "main", FilePath: "main.c", LineNumber: 3},
+			{Address: "0x20", ModuleName: "app.exe", ProcName: "main", FilePath: "main.c", LineNumber: 5},
+			{Address: "0x30", ModuleName: "app.exe", ProcName: "work", FilePath: "work.c", LineNumber: 10},
+		},
+		[]Callstack{
+			{Addresses: []uint64{0x10}, ThreadCounts: map[int]float64{7: 0.25}},
+			{Addresses: []uint64{0x30, 0x20}, ThreadCounts: map[int]float64{7: 0.5}},
+		},
+		[]Thread{{ID: 7, Name: "app.exe"}},
+	)
+
+	var buf bytes.Buffer
+	if err := WriteCallgrind(&buf, want); err != nil {
+		t.Fatalf("WriteCallgrind() error = %v", err)
+	}
+	got, err := parseCallgrind(&buf)
+	if err != nil {
+		t.Fatalf("parseCallgrind() error = %v", err)
+	}
+	assertSameStacks(t, got, want)
+	if name := got.ThreadName(7); name != "app.exe" {
+		t.Errorf("ThreadName(7) = %q, want %q", name, "app.exe")
+	}
+}
+
+func TestParseCallgrind(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		stacks map[string]float64
+	}{
+		{
+			name: "name compression",
+			input: `events: Ir
+ob=(1) /usr/bin/app
+fl=(1) main.c
+fn=(1) main
+10 100
+cob=(1)
+cfi=(2) work.c
+cfn=(2) work
+calls=1 20
+11 40
+fl=(2)
+fn=(2)
+20 40
+`,
+			stacks: map[string]float64{
+				"0|app!main main.c:10":                    100e-9,
+				"0|app!work work.c:20;app!main main.c:11": 40e-9,
+			},
+		},
+		{
+			name: "relative positions after jump",
+			input: `events: Ir
+positions: line
+fl=(1) main.c
+fn=(1) main
+10 100
+jump=3 15
++2
++1 25
+cfn=(2) work
+calls=1 20
+-3 40
+fl=(2) work.c
+fn=(2)
+20 40
+`,
+			stacks: map[string]float64{
+				"0|?!main main.c:10":                  100e-9,
+				"0|?!main main.c:13":                  25e-9,
+				"0|?!work work.c:20;?!main main.c:10": 40e-9,
+			},
+		},
+		{
+			name: "conditional jump and repeated positions",
+			input: `events: Ir
+fl=main.c
+fn=main
+5 10
+jcnd=2 4 9
++1
+* 7
+`,
+			stacks: map[string]float64{
+				"0|?!main main.c:5": 10e-9,
+				"0|?!main main.c:6": 7e-9,
+			},
+		},
+		{
+			name: "thread and unknown file",
+			input: `events: Ir
+cmd: /usr/bin/app --flag
+thread: 2
+fl=???
+fn=main
+1 1000
+`,
+			stacks: map[string]float64{"2|?!main :1": 1000e-9},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pd, err := parseCallgrind(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("parseCallgrind() error = %v", err)
+			}
+
+			got := stackTimes(pd)
+			if len(got) != len(tt.stacks) {
+				t.Errorf("got %d stacks, want %d: %v", len(got), len(tt.stacks), got)
+			}
+			for key, want := range tt.stacks {
+				if math.Abs(got[key]-want) > 1e-15 {
+					t.Errorf("stack %q: got %v, want %v", key, got[key], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package sleepy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// chromeTracePID is the process ID every exported thread is placed under
+const chromeTracePID = 1
+
+type chromeTrace struct {
+	TraceEvents     []chromeTraceEvent `json:"traceEvents"`
+	DisplayTimeUnit string             `json:"displayTimeUnit"`
+	OtherData       map[string]string  `json:"otherData,omitempty"`
+}
+
+// chromeTraceEvent is a Trace Event Format event: "X" (complete) slices and "M" metadata
+type chromeTraceEvent struct {
+	Name string         `json:"name"`
+	Cat  string         `json:"cat,omitempty"`
+	Ph   string         `json:"ph"`
+	Ts   float64        `json:"ts"`
+	Dur  float64        `json:"dur,omitempty"`
+	PID  int            `json:"pid"`
+	TID  int            `json:"tid"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// traceStack is one callstack's time on a thread, with frames root-first
+type traceStack struct {
+	Frames   []ResolvedFrame
+	Names    []string
+	Duration float64
+}
+
+// WriteChromeTrace writes the profile as Chrome Trace Event Format JSON for chrome://tracing
+// and ui.perfetto.dev. Very Sleepy keeps no timestamps, so each thread's callstacks are laid
+// out back to back as synthetic slices as long as their time, sorted by frame names so that
+// shared callers merge into one slice (like a flame chart). Slice times are not wall clock.
+func WriteChromeTrace(w io.Writer, pd *ProfileData) error {
+	threadStacks := make(map[int][]traceStack)
+	for i := range pd.Callstacks {
+		cs := &pd.Callstacks[i]
+		frames := pd.ResolveCallstack(cs)
+		if len(frames) == 0 {
+			continue
+		}
+
+		// Addresses are leaf-first; slices nest root-first
+		rootFirst := make([]ResolvedFrame, len(frames))
+		names := make([]string, len(frames))
+		for j, frame := range frames {
+			rootFirst[len(frames)-1-j] = frame
+			names[len(frames)-1-j] = traceFrameName(frame)
+		}
+
+		for threadID, d := range cs.ThreadCounts {
+			if d > 0 {
+				threadStacks[threadID] = append(threadStacks[threadID], traceStack{Frames: rootFirst, Names: names, Duration: d})
+			}
+		}
+	}
+
+	trace := chromeTrace{
+		TraceEvents:     []chromeTraceEvent{},
+		DisplayTimeUnit: "ms",
+		OtherData:       map[string]string{"source": "Very Sleepy profile (synthetic timeline)"},
+	}
+
+	processName := pd.Stats.Filename
+	if processName == "" {
+		processName = "Very Sleepy profile"
+	}
+	trace.TraceEvents = append(trace.TraceEvents, chromeTraceEvent{
+		Name: "process_name", Ph: "M", PID: chromeTracePID,
+		Args: map[string]any{"name": processName},
+	})
+
+	threadIDs := make([]int, 0, len(threadStacks))
+	for threadID := range threadStacks {
+		threadIDs = append(threadIDs, threadID)
+	}
+	sort.Ints(threadIDs)
+
+	for _, threadID := range threadIDs {
+		name := pd.ThreadName(threadID)
+		switch {
+		case threadID == UnknownThreadID:
+			name = "Unknown thread"
+		case name == "":
+			name = fmt.Sprintf("Thread %d", threadID)
+		}
+		trace.TraceEvents = append(trace.TraceEvents, chromeTraceEvent{
+			Name: "thread_name", Ph: "M", PID: chromeTracePID, TID: threadID,
+			Args: map[string]any{"name": name},
+		})
+
+		trace.TraceEvents = append(trace.TraceEvents, traceThreadSlices(threadID, threadStacks[threadID])...)
+	}
+
+	if err := json.NewEncoder(w).Encode(trace); err != nil {
+		return fmt.Errorf("failed to write trace JSON: %w", err)
+	}
+	return nil
+}
+
+// traceThreadSlices lays out a thread's callstacks back to back and returns one complete
+// event per frame, merging a frame with the same frame in the next callstack when all
+// its callers match too
+func traceThreadSlices(threadID int, stacks []traceStack) []chromeTraceEvent {
+	sort.Slice(stacks, func(i, j int) bool {
+		a, b := stacks[i].Names, stacks[j].Names
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	type openSlice struct {
+		Frame ResolvedFrame
+		Name  string
+		Start float64
+	}
+
+	var events []chromeTraceEvent
+	var open []openSlice
+	closeTo := func(depth int, end float64) {
+		for len(open) > depth {
+			s := open[len(open)-1]
+			open = open[:len(open)-1]
+			args := map[string]any{"module": s.Frame.Module}
+			if s.Frame.SourceFile != "" {
+				args["file"] = s.Frame.SourceFile
+				args["line"] = s.Frame.LineNumber
+			}
+			events = append(events, chromeTraceEvent{
+				Name: s.Name,
+				Cat:  s.Frame.Module,
+				Ph:   "X",
+				Ts:   s.Start,
+				Dur:  end - s.Start,
+				PID:  chromeTracePID,
+				TID:  threadID,
+				Args: args,
+			})
+		}
+	}
+
+	// Timestamps are in microseconds
+	now := 0.0
+	for _, stack := range stacks {
+		common := 0
+		for common < len(open) && common < len(stack.Names) && open[common].Name == stack.Names[common] {
+			common++
+		}
+		closeTo(common, now)
+		for k := common; k < len(stack.Frames); k++ {
+			open = append(open, openSlice{Frame: stack.Frames[k], Name: stack.Names[k], Start: now})
+		}
+		now += stack.Duration * 1e6
+	}
+	closeTo(0, now)
+
+	// Parents first, so viewers that do not sort nest them correctly
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Ts != events[j].Ts {
+			return events[i].Ts < events[j].Ts
+		}
+		return events[i].Dur > events[j].Dur
+	})
+	return events
+}
+
+// traceFrameName renders a frame as Module!Function
+func traceFrameName(frame ResolvedFrame) string {
+	if frame.Module == "" || frame.Module == "?" {
+		return frame.Function
+	}
+	return frame.Module + "!" + frame.Function
+}
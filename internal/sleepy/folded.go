@@ -0,0 +1,114 @@
+package sleepy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Weights accepted by exporters that let the caller choose the unit of each stack
+const (
+	WeightSeconds = "seconds" // Callstack duration in seconds
+	WeightSamples = "samples" // Estimated number of samples behind each callstack
+)
+
+// WriteFolded writes the profile in Brendan Gregg's collapsed/folded stack format,
+// one "root;caller;leaf weight" line per unique stack, as consumed by flamegraph.pl,
+// inferno and speedscope. weight is WeightSeconds or WeightSamples.
+func WriteFolded(w io.Writer, pd *ProfileData, weight string) error {
+	if weight != WeightSeconds && weight != WeightSamples {
+		return fmt.Errorf("unsupported weight %q (expected %q or %q)", weight, WeightSeconds, WeightSamples)
+	}
+
+	samples := pd.EstimateSamples()
+
+	// Identical resolved stacks (e.g. different addresses in the same function) are merged
+	stackWeights := make(map[string]float64)
+	for i := range pd.Callstacks {
+		cs := &pd.Callstacks[i]
+		frames := pd.ResolveCallstack(cs)
+		if len(frames) == 0 {
+			continue
+		}
+
+		// Addresses are leaf-first; folded stacks are root-first
+		names := make([]string, len(frames))
+		for j, frame := range frames {
+			names[len(frames)-1-j] = foldedFrameName(frame)
+		}
+		stack := strings.Join(names, ";")
+
+		if weight == WeightSamples {
+			stackWeights[stack] += float64(samples[i])
+		} else {
+			stackWeights[stack] += cs.GetDuration()
+		}
+	}
+
+	stacks := make([]string, 0, len(stackWeights))
+	for stack := range stackWeights {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		value := stackWeights[stack]
+		if value <= 0 {
+			continue
+		}
+		if weight == WeightSamples {
+			fmt.Fprintf(bw, "%s %d\n", stack, int64(value))
+		} else {
+			// Round to nanoseconds to hide floating point noise from summing durations
+			fmt.Fprintf(bw, "%s %s\n", stack, strconv.FormatFloat(math.Round(value*1e9)/1e9, 'f', -1, 64))
+		}
+	}
+	return bw.Flush()
+}
+
+// foldedFrameName renders a frame as Module!Function, with the characters the
+// folded format uses as separators replaced
+func foldedFrameName(frame ResolvedFrame) string {
+	name := frame.Function
+	if frame.Module != "" && frame.Module != "?" {
+		name = frame.Module + "!" + frame.Function
+	}
+	return strings.NewReplacer(";", ":", "\n", " ", "\r", " ").Replace(name)
+}
+
+// EstimateSamples returns the approximate number of samples behind each callstack.
+// Very Sleepy stores time per callstack rather than sample counts, so the counts are
+// derived from Stats.NumSamples spread proportionally over callstack durations.
+// Every callstack with time gets at least one sample; without a sample count in the
+// stats each callstack counts as one sample.
+func (pd *ProfileData) EstimateSamples() []int64 {
+	samples := make([]int64, len(pd.Callstacks))
+
+	totalTime := 0.0
+	for i := range pd.Callstacks {
+		totalTime += pd.Callstacks[i].GetDuration()
+	}
+
+	for i := range pd.Callstacks {
+		duration := pd.Callstacks[i].GetDuration()
+		if pd.Stats.NumSamples <= 0 || totalTime <= 0 {
+			samples[i] = 1
+			continue
+		}
+		if duration <= 0 {
+			continue
+		}
+		n := int64(math.Round(duration / totalTime * float64(pd.Stats.NumSamples)))
+		if n < 1 {
+			n = 1
+		}
+		samples[i] = n
+	}
+
+	return samples
+}
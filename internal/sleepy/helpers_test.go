@@ -0,0 +1,81 @@
+package sleepy
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testProfile builds a small profile with a shared root, a frame without source
+// information, one callstack split across two threads and one on a single thread
+func testProfile() *ProfileData {
+	return NewProfileData(
+		Stats{Filename: "app.exe", Duration: "1.25", Date: "2024-01-02T03:04:05Z", NumSamples: 100},
+		[]Symbol{
+			{Address: "0x10", ModuleName: "app.exe", ProcName: "main", FilePath: "main.c", LineNumber: 1},
+			{Address: "0x20", ModuleName: "app.exe", ProcName: "work", FilePath: "work.c", LineNumber: 10},
+			{Address: "0x30", ModuleName: "libc.so", ProcName: "memcpy"},
+			{Address: "0x40", ModuleName: "app.exe", ProcName: "helper", FilePath: "work.c", LineNumber: 20},
+		},
+		[]Callstack{
+			{Addresses: []uint64{0x30, 0x20, 0x10}, ThreadCounts: map[int]float64{101: 0.5}},
+			{Addresses: []uint64{0x20, 0x10}, ThreadCounts: map[int]float64{101: 0.25, 102: 0.125}},
+			{Addresses: []uint64{0x40, 0x10}, ThreadCounts: map[int]float64{102: 0.375}},
+		},
+		[]Thread{{ID: 101, Name: "main"}, {ID: 102, Name: "worker"}},
+	)
+}
+
+// stackTimes maps every resolved "thread|frame;frame;..." stack (leaf first) to its time,
+// so profiles can be compared independently of symbol addresses and callstack order
+func stackTimes(pd *ProfileData) map[string]float64 {
+	times := make(map[string]float64)
+	for i := range pd.Callstacks {
+		cs := &pd.Callstacks[i]
+		var frames []string
+		for _, f := range pd.ResolveCallstack(cs) {
+			frames = append(frames, fmt.Sprintf("%s!%s %s:%d", f.Module, f.Function, f.SourceFile, f.LineNumber))
+		}
+		for threadID, d := range cs.ThreadCounts {
+			times[fmt.Sprintf("%d|%s", threadID, strings.Join(frames, ";"))] += d
+		}
+	}
+	return times
+}
+
+// assertSameStacks fails the test unless both profiles hold the same stacks with the same times
+func assertSameStacks(t *testing.T, got, want *ProfileData) {
+	t.Helper()
+	gotTimes, wantTimes := stackTimes(got), stackTimes(want)
+
+	var keys []string
+	for key := range wantTimes {
+		keys = append(keys, key)
+	}
+	for key := range gotTimes {
+		if _, exists := wantTimes[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if math.Abs(gotTimes[key]-wantTimes[key]) > 1e-9 {
+			t.Errorf("stack %q: got %v, want %v", key, gotTimes[key], wantTimes[key])
+		}
+	}
+}
+
+// writeTempFile writes data to a file in the test's temporary directory and returns its path
+func writeTempFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,168 @@
+package sleepy
+
+import "fmt"
+
+// IndexedFunction is one interned Module!Function of a profile
+type IndexedFunction struct {
+	Module     string
+	Function   string
+	Signature  string // "Module!Function"
+	ModuleID   int    // Index into ProfileIndex.Modules
+	SourceFile string // Source of the function's first symbol
+	LineNumber int
+}
+
+// ProfileIndex is a profile's callstacks resolved once to interned function IDs,
+// so analyses can aggregate by integer ID instead of resolving addresses and
+// building Module!Function strings for every frame
+type ProfileIndex struct {
+	Functions []IndexedFunction // Indexed by function ID
+	Modules   []string          // Indexed by module ID
+	Stacks    [][]int           // Function IDs of each callstack, leaf first; parallel to ProfileData.Callstacks
+	Durations []float64         // Duration of each callstack
+	TotalTime float64
+
+	addrFunctions map[uint64]int
+}
+
+// FunctionAt returns the function ID of an address, or -1 if the address does not occur in the profile
+func (ix *ProfileIndex) FunctionAt(addr uint64) int {
+	if id, exists := ix.addrFunctions[addr]; exists {
+		return id
+	}
+	return -1
+}
+
+// functionTable interns functions and modules and maps addresses to function IDs.
+// It is built once per loaded profile and shared with profiles derived from it.
+type functionTable struct {
+	functions     []IndexedFunction
+	modules       []string
+	functionIDs   map[string]int
+	moduleIDs     map[string]int
+	addrFunctions map[uint64]int
+}
+
+func newFunctionTable() *functionTable {
+	return &functionTable{
+		functionIDs:   make(map[string]int),
+		moduleIDs:     make(map[string]int),
+		addrFunctions: make(map[uint64]int),
+	}
+}
+
+// buildFunctionTable interns every symbol and every unresolved callstack address
+func (pd *ProfileData) buildFunctionTable() {
+	table := newFunctionTable()
+
+	// Symbols are interned in file order so a function's first symbol gives its source
+	for i := range pd.Symbols {
+		addr, ok := parseSymbolAddress(pd.Symbols[i].Address)
+		if !ok {
+			continue
+		}
+		if _, exists := table.addrFunctions[addr]; !exists {
+			table.add(addr, pd.ResolveAddress(addr))
+		}
+	}
+	for i := range pd.Callstacks {
+		for _, addr := range pd.Callstacks[i].Addresses {
+			if _, exists := table.addrFunctions[addr]; !exists {
+				table.add(addr, pd.ResolveAddress(addr))
+			}
+		}
+	}
+
+	pd.functions = table
+}
+
+// add maps addr to the function of frame, interning the function and its module
+func (t *functionTable) add(addr uint64, frame ResolvedFrame) int {
+	sig := fmt.Sprintf("%s!%s", frame.Module, frame.Function)
+	id, exists := t.functionIDs[sig]
+	if !exists {
+		moduleID, exists := t.moduleIDs[frame.Module]
+		if !exists {
+			moduleID = len(t.modules)
+			t.moduleIDs[frame.Module] = moduleID
+			t.modules = append(t.modules, frame.Module)
+		}
+
+		id = len(t.functions)
+		t.functionIDs[sig] = id
+		t.functions = append(t.functions, IndexedFunction{
+			Module:     frame.Module,
+			Function:   frame.Function,
+			Signature:  sig,
+			ModuleID:   moduleID,
+			SourceFile: frame.SourceFile,
+			LineNumber: frame.LineNumber,
+		})
+	}
+	t.addrFunctions[addr] = id
+	return id
+}
+
+// clone copies the table so addresses can be added without touching the shared one
+func (t *functionTable) clone() *functionTable {
+	c := &functionTable{
+		functions:     append([]IndexedFunction(nil), t.functions...),
+		modules:       append([]string(nil), t.modules...),
+		functionIDs:   make(map[string]int, len(t.functionIDs)),
+		moduleIDs:     make(map[string]int, len(t.moduleIDs)),
+		addrFunctions: make(map[uint64]int, len(t.addrFunctions)),
+	}
+	for k, v := range t.functionIDs {
+		c.functionIDs[k] = v
+	}
+	for k, v := range t.moduleIDs {
+		c.moduleIDs[k] = v
+	}
+	for k, v := range t.addrFunctions {
+		c.addrFunctions[k] = v
+	}
+	return c
+}
+
+// Index returns the profile's resolved-frame index, building it on first use.
+// The function table is built at load time and shared with derived profiles;
+// only the per-callstack function IDs are computed here.
+func (pd *ProfileData) Index() *ProfileIndex {
+	pd.indexOnce.Do(func() {
+		if pd.functions == nil {
+			pd.buildFunctionTable()
+		}
+		table := pd.functions
+		shared := true
+
+		ix := &ProfileIndex{
+			Stacks:    make([][]int, len(pd.Callstacks)),
+			Durations: make([]float64, len(pd.Callstacks)),
+		}
+		for i := range pd.Callstacks {
+			cs := &pd.Callstacks[i]
+			ids := make([]int, len(cs.Addresses))
+			for j, addr := range cs.Addresses {
+				id, exists := table.addrFunctions[addr]
+				if !exists {
+					// Callstacks built by hand may hold addresses the loaded profile never had
+					if shared {
+						table = table.clone()
+						shared = false
+					}
+					id = table.add(addr, pd.ResolveAddress(addr))
+				}
+				ids[j] = id
+			}
+			ix.Stacks[i] = ids
+			ix.Durations[i] = cs.GetDuration()
+			ix.TotalTime += ix.Durations[i]
+		}
+
+		ix.Functions = table.functions
+		ix.Modules = table.modules
+		ix.addrFunctions = table.addrFunctions
+		pd.index = ix
+	})
+	return pd.index
+}
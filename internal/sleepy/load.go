@@ -0,0 +1,79 @@
+package sleepy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Profile formats recognized by LoadProfile
+const (
+	FormatSleepy    = "sleepy"    // Very Sleepy .sleepy ZIP archive
+	FormatPprof     = "pprof"     // Go pprof profile.proto, gzipped or raw
+	FormatPerf      = "perf"      // Linux `perf script` text output
+	FormatCallgrind = "callgrind" // Valgrind callgrind.out
+)
+
+// detectHeaderSize is how much of a file DetectFormat is given; text formats
+// may start with long comment blocks before the first sample
+const detectHeaderSize = 64 * 1024
+
+// DetectFormat identifies a profile format from the first bytes of a file
+func DetectFormat(header []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		return FormatSleepy, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return FormatPprof, nil
+	case looksLikeCallgrind(string(header)):
+		return FormatCallgrind, nil
+	case looksLikePerfScript(string(header)):
+		return FormatPerf, nil
+	case len(header) > 0 && header[0] == 0x0a:
+		// Uncompressed profile.proto starts with field 1 (sample_type), length-delimited.
+		// Checked after the text formats, whose files may start with a blank line.
+		return FormatPprof, nil
+	}
+	return "", fmt.Errorf("unrecognized profile format")
+}
+
+// LoadProfile reads a profile in any supported format, detected from the file contents.
+// It returns the parsed profile and the detected format.
+func LoadProfile(filePath string) (*ProfileData, string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open profile: %w", err)
+	}
+	header := make([]byte, detectHeaderSize)
+	n, err := io.ReadFull(f, header)
+	f.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, "", fmt.Errorf("failed to read profile: %w", err)
+	}
+
+	format, err := DetectFormat(header[:n])
+	if err != nil {
+		return nil, "", err
+	}
+
+	var profile *ProfileData
+	switch format {
+	case FormatSleepy:
+		profile, err = ReadSleepyProfile(filePath)
+	case FormatPprof:
+		profile, err = ReadPprofProfile(filePath)
+	case FormatPerf:
+		profile, err = ReadPerfScript(filePath)
+	case FormatCallgrind:
+		profile, err = ReadCallgrindProfile(filePath)
+	}
+	if err != nil {
+		return nil, format, err
+	}
+
+	// Resolve every callstack up front so the first analysis does not pay for it
+	profile.Index()
+
+	return profile, format, nil
+}
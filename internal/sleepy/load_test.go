@@ -0,0 +1,106 @@
+package sleepy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+const testPerfScript = `app 1234/1240 [003] 5012.000000: 250000 cpu-clock:
+	    4005d0 work+0x10 (/usr/bin/app)
+	    400480 main+0x20 (/usr/bin/app)
+`
+
+const testCallgrind = `events: Ir
+fn=main
+1 100
+`
+
+func TestDetectFormat(t *testing.T) {
+	var gzipped bytes.Buffer
+	if err := WritePprofProfile(&gzipped, testProfile()); err != nil {
+		t.Fatal(err)
+	}
+	raw := gunzip(t, gzipped.Bytes())
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"sleepy zip", []byte("PK\x03\x04rest"), FormatSleepy},
+		{"gzipped pprof", gzipped.Bytes(), FormatPprof},
+		{"raw pprof", raw, FormatPprof},
+		{"perf script", []byte(testPerfScript), FormatPerf},
+		{"perf script after blank line", []byte("\n" + testPerfScript), FormatPerf},
+		{"perf script after comments", []byte("# ========\n# captured on: today\n\n" + testPerfScript), FormatPerf},
+		{"callgrind", []byte(testCallgrind), FormatCallgrind},
+		{"callgrind after blank line", []byte("\n" + testCallgrind), FormatCallgrind},
+		{"callgrind marker", []byte("# callgrind format\nversion: 1\n"), FormatCallgrind},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectFormat(tt.header)
+			if err != nil {
+				t.Fatalf("DetectFormat() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DetectFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectFormatUnrecognized(t *testing.T) {
+	for _, header := range []string{"", "hello world\n", "{\"traceEvents\": []}"} {
+		if format, err := DetectFormat([]byte(header)); err == nil {
+			t.Errorf("DetectFormat(%q) = %q, want an error", header, format)
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	var zipped bytes.Buffer
+	if err := WriteSleepyProfile(&zipped, testProfile()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"sleepy", zipped.Bytes(), FormatSleepy},
+		{"perf script after blank line", []byte("\n" + testPerfScript), FormatPerf},
+		{"callgrind after blank line", []byte("\n" + testCallgrind), FormatCallgrind},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pd, format, err := LoadProfile(writeTempFile(t, "profile", tt.data))
+			if err != nil {
+				t.Fatalf("LoadProfile() error = %v", err)
+			}
+			if format != tt.want {
+				t.Errorf("LoadProfile() format = %q, want %q", format, tt.want)
+			}
+			if len(pd.Callstacks) == 0 {
+				t.Errorf("LoadProfile() read no callstacks")
+			}
+		})
+	}
+}
+
+// gunzip returns the decompressed contents of a gzip stream
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
@@ -1,274 +1,387 @@
-package sleepy
-
-import (
-	"archive/zip"
-	"bufio"
-	"fmt"
-	"io"
-	"strconv"
-	"strings"
-)
-
-// ReadSleepyProfile reads a sleepy profile file (ZIP archive) and parses its contents.
-func ReadSleepyProfile(filePath string) (*ProfileData, error) {
-	reader, err := zip.OpenReader(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open zip file: %w", err)
-	}
-	defer reader.Close()
-
-	profileData := &ProfileData{}
-
-	for _, file := range reader.File {
-		rc, err := file.Open()
-		if err != nil {
-			return nil, fmt.Errorf("failed to open file %s in zip: %w", file.Name, err)
-		}
-		defer rc.Close()
-
-		switch file.Name {
-		case "Stats.txt":
-			if err := parseStats(rc, &profileData.Stats); err != nil {
-				return nil, fmt.Errorf("failed to parse Stats.txt: %w", err)
-			}
-		case "Symbols.txt":
-			if err := parseSymbols(rc, &profileData.Symbols); err != nil {
-				return nil, fmt.Errorf("failed to parse Symbols.txt: %w", err)
-			}
-		case "Callstacks.txt":
-			callstacks, err := parseCallstacks(rc)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse Callstacks.txt: %w", err)
-			}
-			profileData.Callstacks = callstacks
-		case "Threads.txt":
-			if err := parseThreads(rc, &profileData.Threads); err != nil {
-				return nil, fmt.Errorf("failed to parse Threads.txt: %w", err)
-			}
-		}
-	}
-
-	// Build symbol map for fast lookup
-	profileData.buildSymbolMap()
-
-	return profileData, nil
-}
-
-// buildSymbolMap creates an internal map for fast symbol lookup by address
-func (pd *ProfileData) buildSymbolMap() {
-	pd.symbolMap = make(map[uint64]*Symbol)
-	for i := range pd.Symbols {
-		sym := &pd.Symbols[i]
-		// Parse address from hex string
-		if strings.HasPrefix(sym.Address, "0x") {
-			if addr, err := strconv.ParseUint(strings.TrimPrefix(sym.Address, "0x"), 16, 64); err == nil {
-				pd.symbolMap[addr] = sym
-			}
-		}
-	}
-}
-
-// ResolveCallstack converts a callstack's addresses to resolved frames with symbol information
-func (pd *ProfileData) ResolveCallstack(callstack *Callstack) []ResolvedFrame {
-	frames := make([]ResolvedFrame, 0, len(callstack.Addresses))
-
-	for _, addr := range callstack.Addresses {
-		frame := ResolvedFrame{
-			Address: addr,
-		}
-
-		// Try to find symbol for this address
-		if sym, found := pd.symbolMap[addr]; found {
-			frame.Module = sym.ModuleName
-			frame.Function = sym.ProcName
-			frame.SourceFile = sym.FilePath
-			frame.LineNumber = sym.LineNumber
-		} else {
-			// Symbol not found, use placeholder
-			frame.Module = "?"
-			frame.Function = fmt.Sprintf("[0x%X]", addr)
-			frame.SourceFile = ""
-			frame.LineNumber = 0
-		}
-
-		frames = append(frames, frame)
-	}
-
-	return frames
-}
-
-func parseStats(r io.Reader, stats *Stats) error {
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.SplitN(line, ": ", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		key := parts[0]
-		value := parts[1]
-
-		switch key {
-		case "Filename":
-			stats.Filename = value
-		case "Duration":
-			stats.Duration = value
-		case "Date":
-			stats.Date = value
-		case "Samples":
-			numSamples, err := strconv.Atoi(value)
-			if err != nil {
-				return fmt.Errorf("invalid Samples value: %w", err)
-			}
-			stats.NumSamples = numSamples
-		}
-	}
-	return scanner.Err()
-}
-
-func parseSymbols(r io.Reader, symbols *[]Symbol) error {
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		var (
-			address    string
-			moduleName string
-			procName   string
-			filePath   string
-			lineNumber int
-			err        error
-		)
-
-		// Custom parsing to handle quoted strings
-		fields := []string{}
-		inQuote := false
-		currentField := strings.Builder{}
-		for _, r := range line {
-			if r == '"' {
-				inQuote = !inQuote
-				continue
-			} else if r == ' ' && !inQuote {
-				fields = append(fields, currentField.String())
-				currentField.Reset()
-			} else {
-				currentField.WriteRune(r)
-			}
-		}
-		fields = append(fields, currentField.String()) // Add the last field
-
-		if len(fields) < 5 {
-			return fmt.Errorf("malformed line in Symbols.txt: %s", line)
-		}
-
-		address = fields[0]
-		moduleName = fields[1]
-		procName = fields[2]
-		filePath = fields[3]
-		lineNumber, err = strconv.Atoi(fields[4])
-		if err != nil {
-			return fmt.Errorf("invalid line number in Symbols.txt: %w (line: %s)", err, line)
-		}
-
-		*symbols = append(*symbols, Symbol{
-			Address:    address,
-			ModuleName: moduleName,
-			ProcName:   procName,
-			FilePath:   filePath,
-			LineNumber: lineNumber,
-		})
-	}
-	return scanner.Err()
-}
-
-func parseCallstacks(r io.Reader) ([]Callstack, error) {
-	var callstacks []Callstack
-	scanner := bufio.NewScanner(r)
-
-	callstackID := 1 // Auto-increment ID for each callstack
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		if line == "" {
-			continue
-		}
-
-		// Format: "Duration Address1 Address2 ..."
-		// Example: "0.001584 0x7ff7ee6f157c 0x7ff7ee6fa3e4 0x7ffffce97374 0x7ffffdd5cc91"
-
-		parts := strings.Fields(line)
-
-		if len(parts) < 1 {
-			return nil, fmt.Errorf("malformed Callstacks.txt line (no data): %q", line)
-		}
-
-		// First part is duration
-		duration, err := strconv.ParseFloat(parts[0], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid duration %q: %w", parts[0], err)
-		}
-
-		// Remaining parts are addresses
-		addresses := make([]uint64, 0, len(parts)-1)
-		for i := 1; i < len(parts); i++ {
-			addrStr := parts[i]
-			if !strings.HasPrefix(addrStr, "0x") {
-				return nil, fmt.Errorf("invalid address format %q (expected 0x prefix)", addrStr)
-			}
-			addr, err := strconv.ParseUint(strings.TrimPrefix(addrStr, "0x"), 16, 64)
-			if err != nil {
-				return nil, fmt.Errorf("invalid address %q: %w", addrStr, err)
-			}
-			addresses = append(addresses, addr)
-		}
-
-		// Create callstack with single thread count (auto-incremented ID, duration as count)
-		threadCounts := map[int]float64{
-			callstackID: duration,
-		}
-
-		callstacks = append(callstacks, Callstack{
-			Addresses:    addresses,
-			ThreadCounts: threadCounts,
-		})
-
-		callstackID++
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading Callstacks.txt: %w", err)
-	}
-
-	return callstacks, nil
-}
-
-func parseThreads(r io.Reader, threads *[]Thread) error {
-	scanner := bufio.NewScanner(r)
-	var currentThread Thread
-	isIDLine := true
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		if isIDLine {
-			threadID, err := strconv.Atoi(line)
-			if err != nil {
-				return fmt.Errorf("invalid thread ID in Threads.txt: %w", err)
-			}
-			currentThread.ID = threadID
-			isIDLine = false
-		} else {
-			currentThread.Name = line
-			*threads = append(*threads, currentThread)
-			currentThread = Thread{} // Reset for next thread
-			isIDLine = true
-		}
-	}
-	return scanner.Err()
-}
+package sleepy
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ReadSleepyProfile reads a sleepy profile file (ZIP archive) and parses its contents.
+func ReadSleepyProfile(filePath string) (*ProfileData, error) {
+	reader, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip file: %w", err)
+	}
+	defer reader.Close()
+
+	profileData := &ProfileData{}
+	var threadless []int
+
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file %s in zip: %w", file.Name, err)
+		}
+		defer rc.Close()
+
+		switch file.Name {
+		case "Stats.txt":
+			if err := parseStats(rc, &profileData.Stats); err != nil {
+				return nil, fmt.Errorf("failed to parse Stats.txt: %w", err)
+			}
+		case "Symbols.txt":
+			if err := parseSymbols(rc, &profileData.Symbols); err != nil {
+				return nil, fmt.Errorf("failed to parse Symbols.txt: %w", err)
+			}
+		case "Callstacks.txt":
+			callstacks, noThreadColumn, err := parseCallstacks(rc)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse Callstacks.txt: %w", err)
+			}
+			profileData.Callstacks = callstacks
+			threadless = noThreadColumn
+		case "Threads.txt":
+			if err := parseThreads(rc, &profileData.Threads); err != nil {
+				return nil, fmt.Errorf("failed to parse Threads.txt: %w", err)
+			}
+		}
+	}
+
+	// Build symbol map for fast lookup
+	profileData.buildSymbolMap()
+
+	// Files inside the archive may come in any order, so attribute thread-less
+	// callstacks only after Threads.txt has been read
+	profileData.attributeUnknownThreads(threadless)
+
+	return profileData, nil
+}
+
+// buildSymbolMap creates an internal map for fast symbol lookup by address,
+// and the function table analyses index callstacks with
+func (pd *ProfileData) buildSymbolMap() {
+	pd.symbolMap = make(map[uint64]*Symbol)
+	for i := range pd.Symbols {
+		sym := &pd.Symbols[i]
+		if addr, ok := parseSymbolAddress(sym.Address); ok {
+			pd.symbolMap[addr] = sym
+		}
+	}
+
+	pd.buildFunctionTable()
+}
+
+// parseSymbolAddress parses a symbol's "0x..." hex address
+func parseSymbolAddress(address string) (uint64, bool) {
+	if !strings.HasPrefix(address, "0x") {
+		return 0, false
+	}
+	addr, err := strconv.ParseUint(strings.TrimPrefix(address, "0x"), 16, 64)
+	return addr, err == nil
+}
+
+// attributeUnknownThreads assigns the callstacks at the given indices, which had no thread
+// column, to the profile's only thread. With several threads the callstacks stay under
+// UnknownThreadID, as do callstacks whose thread column names UnknownThreadID explicitly.
+func (pd *ProfileData) attributeUnknownThreads(threadless []int) {
+	if len(pd.Threads) != 1 || pd.Threads[0].ID == UnknownThreadID {
+		return
+	}
+
+	threadID := pd.Threads[0].ID
+	for _, i := range threadless {
+		counts := pd.Callstacks[i].ThreadCounts
+		counts[threadID] += counts[UnknownThreadID]
+		delete(counts, UnknownThreadID)
+	}
+}
+
+// ResolveCallstack converts a callstack's addresses to resolved frames with symbol information
+func (pd *ProfileData) ResolveCallstack(callstack *Callstack) []ResolvedFrame {
+	frames := make([]ResolvedFrame, 0, len(callstack.Addresses))
+
+	for _, addr := range callstack.Addresses {
+		frames = append(frames, pd.ResolveAddress(addr))
+	}
+
+	return frames
+}
+
+// ResolveAddress converts a single address to a resolved frame with symbol information
+func (pd *ProfileData) ResolveAddress(addr uint64) ResolvedFrame {
+	frame := ResolvedFrame{
+		Address: addr,
+	}
+
+	// Try to find symbol for this address
+	if sym, found := pd.symbolMap[addr]; found {
+		frame.Module = sym.ModuleName
+		frame.Function = sym.ProcName
+		frame.SourceFile = sym.FilePath
+		frame.LineNumber = sym.LineNumber
+	} else {
+		// Symbol not found, use placeholder
+		frame.Module = "?"
+		frame.Function = fmt.Sprintf("[0x%X]", addr)
+		frame.SourceFile = ""
+		frame.LineNumber = 0
+	}
+
+	return frame
+}
+
+// WithCallstacks returns a derived profile that shares this profile's stats, symbols
+// and threads but holds a different set of callstacks (e.g. after filtering).
+// The sample count is scaled to the share of time the new callstacks cover.
+func (pd *ProfileData) WithCallstacks(callstacks []Callstack) *ProfileData {
+	stats := pd.Stats
+
+	oldTime, newTime := 0.0, 0.0
+	for i := range pd.Callstacks {
+		oldTime += pd.Callstacks[i].GetDuration()
+	}
+	for i := range callstacks {
+		newTime += callstacks[i].GetDuration()
+	}
+	if oldTime > 0 {
+		stats.NumSamples = int(math.Round(float64(stats.NumSamples) * newTime / oldTime))
+	}
+
+	return &ProfileData{
+		Stats:      stats,
+		Symbols:    pd.Symbols,
+		Callstacks: callstacks,
+		Threads:    pd.Threads,
+		symbolMap:  pd.symbolMap,
+		functions:  pd.functions,
+	}
+}
+
+// NewProfileData assembles a profile from its parts (e.g. when combining profiles),
+// indexing the symbols by address for lookup
+func NewProfileData(stats Stats, symbols []Symbol, callstacks []Callstack, threads []Thread) *ProfileData {
+	pd := &ProfileData{
+		Stats:      stats,
+		Symbols:    symbols,
+		Callstacks: callstacks,
+		Threads:    threads,
+	}
+	pd.buildSymbolMap()
+	return pd
+}
+
+// ThreadName returns the name of the thread with the given ID from Threads.txt,
+// or an empty string if the thread is not listed
+func (pd *ProfileData) ThreadName(threadID int) string {
+	for _, t := range pd.Threads {
+		if t.ID == threadID {
+			return t.Name
+		}
+	}
+	return ""
+}
+
+func parseStats(r io.Reader, stats *Stats) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := parts[0]
+		value := parts[1]
+
+		switch key {
+		case "Filename":
+			stats.Filename = value
+		case "Duration":
+			stats.Duration = value
+		case "Date":
+			stats.Date = value
+		case "Samples":
+			numSamples, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid Samples value: %w", err)
+			}
+			stats.NumSamples = numSamples
+		}
+	}
+	return scanner.Err()
+}
+
+func parseSymbols(r io.Reader, symbols *[]Symbol) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var (
+			address    string
+			moduleName string
+			procName   string
+			filePath   string
+			lineNumber int
+			err        error
+		)
+
+		// Custom parsing to handle quoted strings
+		fields := []string{}
+		inQuote := false
+		currentField := strings.Builder{}
+		for _, r := range line {
+			if r == '"' {
+				inQuote = !inQuote
+				continue
+			} else if r == ' ' && !inQuote {
+				fields = append(fields, currentField.String())
+				currentField.Reset()
+			} else {
+				currentField.WriteRune(r)
+			}
+		}
+		fields = append(fields, currentField.String()) // Add the last field
+
+		if len(fields) < 5 {
+			return fmt.Errorf("malformed line in Symbols.txt: %s", line)
+		}
+
+		address = fields[0]
+		moduleName = fields[1]
+		procName = fields[2]
+		filePath = fields[3]
+		lineNumber, err = strconv.Atoi(fields[4])
+		if err != nil {
+			return fmt.Errorf("invalid line number in Symbols.txt: %w (line: %s)", err, line)
+		}
+
+		*symbols = append(*symbols, Symbol{
+			Address:    address,
+			ModuleName: moduleName,
+			ProcName:   procName,
+			FilePath:   filePath,
+			LineNumber: lineNumber,
+		})
+	}
+	return scanner.Err()
+}
+
+// parseCallstacks parses Callstacks.txt. It also returns the indices of the callstacks
+// that had no thread column, whose time is recorded under UnknownThreadID.
+func parseCallstacks(r io.Reader) ([]Callstack, []int, error) {
+	var callstacks []Callstack
+	var threadless []int
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024) // Deep stacks make long lines
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
+
+		// Format: "Duration [Thread ...] Address1 Address2 ..."
+		// Example: "0.001584 4528 0x7ff7ee6f157c 0x7ff7ee6fa3e4 0x7ffffce97374 0x7ffffdd5cc91"
+		// Thread columns are either "ThreadID" (the whole duration belongs to that thread)
+		// or "ThreadID:Duration" (per-thread split). Older files have no thread column.
+
+		parts := strings.Fields(line)
+
+		if len(parts) < 1 {
+			return nil, nil, fmt.Errorf("malformed Callstacks.txt line (no data): %q", line)
+		}
+
+		// First part is duration
+		duration, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid duration %q: %w", parts[0], err)
+		}
+
+		// Thread columns come before the first address
+		threadCounts := make(map[int]float64)
+		i := 1
+		for ; i < len(parts) && !strings.HasPrefix(parts[i], "0x"); i++ {
+			idStr, countStr, hasCount := strings.Cut(parts[i], ":")
+
+			threadID, err := strconv.Atoi(idStr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid thread ID %q: %w", parts[i], err)
+			}
+
+			if !hasCount {
+				threadCounts[threadID] += duration
+				continue
+			}
+			count, err := strconv.ParseFloat(countStr, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid thread duration %q: %w", parts[i], err)
+			}
+			threadCounts[threadID] += count
+		}
+
+		if len(threadCounts) == 0 {
+			threadCounts[UnknownThreadID] = duration
+			threadless = append(threadless, len(callstacks))
+		}
+
+		// Remaining parts are addresses
+		addresses := make([]uint64, 0, len(parts)-i)
+		for ; i < len(parts); i++ {
+			addrStr := parts[i]
+			if !strings.HasPrefix(addrStr, "0x") {
+				return nil, nil, fmt.Errorf("invalid address format %q (expected 0x prefix)", addrStr)
+			}
+			addr, err := strconv.ParseUint(strings.TrimPrefix(addrStr, "0x"), 16, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid address %q: %w", addrStr, err)
+			}
+			addresses = append(addresses, addr)
+		}
+
+		callstacks = append(callstacks, Callstack{
+			Addresses:    addresses,
+			ThreadCounts: threadCounts,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading Callstacks.txt: %w", err)
+	}
+
+	return callstacks, threadless, nil
+}
+
+func parseThreads(r io.Reader, threads *[]Thread) error {
+	scanner := bufio.NewScanner(r)
+	var currentThread Thread
+	isIDLine := true
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if isIDLine {
+			threadID, err := strconv.Atoi(line)
+			if err != nil {
+				return fmt.Errorf("invalid thread ID in Threads.txt: %w", err)
+			}
+			currentThread.ID = threadID
+			isIDLine = false
+		} else {
+			currentThread.Name = line
+			*threads = append(*threads, currentThread)
+			currentThread = Thread{} // Reset for next thread
+			isIDLine = true
+		}
+	}
+	return scanner.Err()
+}
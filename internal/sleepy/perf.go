@@ -0,0 +1,252 @@
+package sleepy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// perfHeaderPattern matches a `perf script` sample header such as
+// "myapp 1234/1240 [003] 5012.345678: 250000 cpu-clock:" or "myapp 1240 5012.345678: cycles:".
+// The command name may contain spaces; it is followed by "pid/tid" or just "tid". Event
+// names may be PMU events such as "cpu/event=0x3c,umask=0x0/" or "cpu@cycles@".
+var perfHeaderPattern = regexp.MustCompile(`^(\S.*?)\s+(\d+)(?:/(\d+))?\s+(?:\[\d+\]\s+)?(?:(\d+\.\d+):\s+)?(?:(\d+)\s+)?(\S+?):?\s*$`)
+
+// perfIdleThreadID stands in for tid 0, the idle task ("swapper"), which would otherwise
+// read as UnknownThreadID. Linux thread IDs never exceed PID_MAX_LIMIT (2^22), so it
+// cannot collide with a real thread.
+const perfIdleThreadID = 1 << 22
+
+// perfFramePattern matches a callchain line: "addr symbol+offset (dso)"
+var perfFramePattern = regexp.MustCompile(`^([0-9a-fA-F]+)\s+(.*?)\s*\(([^()]*)\)$`)
+
+// perfSrclinePattern matches the "file:line" line perf prints under a frame with -F +srcline
+var perfSrclinePattern = regexp.MustCompile(`^(\S.*):(\d+)$`)
+
+// perfOffsetPattern matches the "+0x1a" offset perf appends to symbol names
+var perfOffsetPattern = regexp.MustCompile(`\+0x[0-9a-fA-F]+$`)
+
+// ReadPerfScript reads the text output of `perf script` (from `perf record -g`) and maps it
+// onto ProfileData. Each sample's frames become a callstack keyed by thread ID (tid; the
+// idle task's tid 0 becomes perfIdleThreadID), threads are named after their command
+// (comm), and frames with the same DSO, symbol and source line share one synthetic
+// symbol address. Samples of clock events (cpu-clock, task-clock) weigh their period in
+// nanoseconds; other events weigh the average interval between sample timestamps, or
+// 1 ms without timestamps.
+func ReadPerfScript(filePath string) (*ProfileData, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open perf script file: %w", err)
+	}
+	defer f.Close()
+
+	return parsePerfScript(f)
+}
+
+// perfSample is a parsed sample before durations are assigned
+type perfSample struct {
+	ThreadID  int
+	Addresses []uint64
+	Period    float64 // Seconds, for clock events; 0 otherwise
+}
+
+func parsePerfScript(r io.Reader) (*ProfileData, error) {
+	pd := &ProfileData{}
+
+	symbolAddrs := make(map[string]uint64)
+	addSymbol := func(key string, sym Symbol) uint64 {
+		if addr, exists := symbolAddrs[key]; exists {
+			return addr
+		}
+		addr := uint64(len(pd.Symbols)+1) << 4
+		sym.Address = fmt.Sprintf("0x%x", addr)
+		pd.Symbols = append(pd.Symbols, sym)
+		symbolAddrs[key] = addr
+		return addr
+	}
+
+	var samples []perfSample
+	var current *perfSample
+	var lastFrame *Symbol
+	var lastFrameKey string
+	threadSeen := make(map[int]bool)
+	firstTimestamp, lastTimestamp := -1.0, -1.0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" {
+			current = nil
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Sample headers start at column 0; frames are indented
+		if line[0] != ' ' && line[0] != '\t' {
+			m := perfHeaderPattern.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("malformed perf script header at line %d: %q", lineNumber, line)
+			}
+
+			comm := m[1]
+			tid, _ := strconv.Atoi(m[2])
+			if m[3] != "" {
+				tid, _ = strconv.Atoi(m[3])
+			}
+			if tid == UnknownThreadID {
+				tid = perfIdleThreadID
+			}
+
+			if m[4] != "" {
+				ts, _ := strconv.ParseFloat(m[4], 64)
+				if firstTimestamp < 0 || ts < firstTimestamp {
+					firstTimestamp = ts
+				}
+				if ts > lastTimestamp {
+					lastTimestamp = ts
+				}
+			}
+
+			period := 0.0
+			event := m[6]
+			if m[5] != "" && (strings.HasPrefix(event, "cpu-clock") || strings.HasPrefix(event, "task-clock")) {
+				ns, _ := strconv.ParseFloat(m[5], 64)
+				period = ns / 1e9
+			}
+
+			if !threadSeen[tid] {
+				threadSeen[tid] = true
+				pd.Threads = append(pd.Threads, Thread{ID: tid, Name: comm})
+			}
+
+			samples = append(samples, perfSample{ThreadID: tid, Period: period})
+			current = &samples[len(samples)-1]
+			lastFrame = nil
+			continue
+		}
+
+		if current == nil {
+			return nil, fmt.Errorf("frame outside of a sample at line %d: %q", lineNumber, line)
+		}
+
+		m := perfFramePattern.FindStringSubmatch(trimmed)
+		if m == nil {
+			// A source line printed under the previous frame (perf script -F +srcline)
+			if sm := perfSrclinePattern.FindStringSubmatch(trimmed); sm != nil && lastFrame != nil {
+				sym := *lastFrame
+				sym.FilePath = sm[1]
+				sym.LineNumber, _ = strconv.Atoi(sm[2])
+				key := fmt.Sprintf("%s\x00%s\x00%d", lastFrameKey, sym.FilePath, sym.LineNumber)
+				current.Addresses[len(current.Addresses)-1] = addSymbol(key, sym)
+				lastFrame = nil
+				continue
+			}
+			return nil, fmt.Errorf("malformed perf script frame at line %d: %q", lineNumber, line)
+		}
+
+		ip, err := strconv.ParseUint(m[1], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q at line %d: %w", m[1], lineNumber, err)
+		}
+
+		module := path.Base(m[3])
+		if m[3] == "" || m[3] == "unknown" || m[3] == "[unknown]" {
+			module = "?"
+		}
+
+		symbol := perfOffsetPattern.ReplaceAllString(m[2], "")
+		var key string
+		if symbol == "" || symbol == "[unknown]" {
+			symbol = fmt.Sprintf("[0x%X]", ip)
+			key = fmt.Sprintf("%s\x00%x", module, ip)
+		} else {
+			key = module + "\x00" + symbol
+		}
+
+		sym := Symbol{ModuleName: module, ProcName: symbol}
+		current.Addresses = append(current.Addresses, addSymbol(key, sym))
+		lastFrame = &sym
+		lastFrameKey = key
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading perf script output: %w", err)
+	}
+
+	// Non-clock events have no time unit; spread the capture's wall time over the samples
+	interval := 0.001
+	if len(samples) > 1 && lastTimestamp > firstTimestamp {
+		interval = (lastTimestamp - firstTimestamp) / float64(len(samples)-1)
+	}
+
+	// Samples with the same stack are merged into one callstack
+	stackIndex := make(map[string]int)
+	for _, s := range samples {
+		duration := s.Period
+		if duration == 0 {
+			duration = interval
+		}
+
+		var key strings.Builder
+		for _, addr := range s.Addresses {
+			key.WriteString(strconv.FormatUint(addr, 16))
+			key.WriteByte(' ')
+		}
+
+		if idx, exists := stackIndex[key.String()]; exists {
+			pd.Callstacks[idx].ThreadCounts[s.ThreadID] += duration
+			continue
+		}
+		stackIndex[key.String()] = len(pd.Callstacks)
+		pd.Callstacks = append(pd.Callstacks, Callstack{
+			Addresses:    s.Addresses,
+			ThreadCounts: map[int]float64{s.ThreadID: duration},
+		})
+	}
+
+	pd.Stats.NumSamples = len(samples)
+	if lastTimestamp > firstTimestamp {
+		// Round to nanoseconds to hide floating point noise from subtracting timestamps
+		pd.Stats.Duration = strconv.FormatFloat(math.Round((lastTimestamp-firstTimestamp)*1e9)/1e9, 'f', -1, 64)
+	}
+	if len(pd.Threads) > 0 {
+		pd.Stats.Filename = pd.Threads[0].Name
+	}
+
+	pd.buildSymbolMap()
+
+	return pd, nil
+}
+
+// looksLikePerfScript reports whether text starts like `perf script` output:
+// a sample header followed by an indented frame line
+func looksLikePerfScript(text string) bool {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !perfHeaderPattern.MatchString(line) || i+1 >= len(lines) {
+			return false
+		}
+		next := lines[i+1]
+		return (strings.HasPrefix(next, " ") || strings.HasPrefix(next, "\t")) &&
+			perfFramePattern.MatchString(strings.TrimSpace(next))
+	}
+	return false
+}
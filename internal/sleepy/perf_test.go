@@ -0,0 +1,187 @@
+package sleepy
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParsePerfScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		stacks   map[string]float64
+		duration string
+		threads  []Thread
+	}{
+		{
+			name: "clock samples",
+			script: `app 1234/1240 [003] 5012.100000: 250000 cpu-clock:
+	    4005d0 work+0x10 (/usr/bin/app)
+	    400480 main+0x20 (/usr/bin/app)
+
+app 1234/1241 [001] 5012.300000: 250000 cpu-clock:
+	    7f0010 memcpy+0x4 (/usr/lib/libc.so.6)
+	    4005d0 work+0x18 (/usr/bin/app)
+	    400480 main+0x20 (/usr/bin/app)
+`,
+			stacks: map[string]float64{
+				"1240|app!work :0;app!main :0":                     0.00025,
+				"1241|libc.so.6!memcpy :0;app!work :0;app!main :0": 0.00025,
+			},
+			duration: "0.2",
+			threads:  []Thread{{ID: 1240, Name: "app"}, {ID: 1241, Name: "app"}},
+		},
+		{
+			name: "srcline",
+			script: `app 1240 5012.000000: 1000000 task-clock:
+	    4005d0 work+0x10 (/usr/bin/app)
+  work.c:42
+	    400480 main+0x20 (/usr/bin/app)
+  main.c:7
+
+app 1240 5012.001000: 1000000 task-clock:
+	    4005e0 work+0x20 (/usr/bin/app)
+  work.c:43
+	    400480 main+0x20 (/usr/bin/app)
+  main.c:7
+`,
+			stacks: map[string]float64{
+				"1240|app!work work.c:42;app!main main.c:7": 0.001,
+				"1240|app!work work.c:43;app!main main.c:7": 0.001,
+			},
+			duration: "0.001",
+			threads:  []Thread{{ID: 1240, Name: "app"}},
+		},
+		{
+			name: "PMU event",
+			script: `app 1240 5012.000000: 100 cpu/event=0x3c,umask=0x0/:
+	    4005d0 work+0x10 (/usr/bin/app)
+
+app 1240 5012.002000: 100 cpu/event=0x3c,umask=0x0/:
+	    4005d0 work+0x10 (/usr/bin/app)
+`,
+			stacks:   map[string]float64{"1240|app!work :0": 0.004},
+			duration: "0.002",
+			threads:  []Thread{{ID: 1240, Name: "app"}},
+		},
+		{
+			name: "PMU event with @ syntax",
+			script: `my app 1240 cpu@cycles@:
+	    4005d0 [unknown] ([unknown])
+`,
+			stacks:  map[string]float64{"1240|?![0x4005D0] :0": 0.001},
+			threads: []Thread{{ID: 1240, Name: "my app"}},
+		},
+		{
+			name: "idle task",
+			script: `swapper     0 [002] 5012.000000: 250000 cpu-clock:
+	ffffffff81a3c5e1 native_safe_halt+0x11 ([kernel.kallsyms])
+
+app 1240 [001] 5012.000250: 250000 cpu-clock:
+	    4005d0 work+0x10 (/usr/bin/app)
+`,
+			stacks: map[string]float64{
+				"4194304|[kernel.kallsyms]!native_safe_halt :0": 0.00025,
+				"1240|app!work :0": 0.00025,
+			},
+			duration: "0.00025",
+			threads:  []Thread{{ID: perfIdleThreadID, Name: "swapper"}, {ID: 1240, Name: "app"}},
+		},
+		{
+			name: "leading blank lines and comments",
+			script: `
+
+# ========
+# captured on    : Mon Jan  1 00:00:00 2024
+# ========
+
+app 1240 [000] 5012.000000: 500000 cpu-clock:u:
+	    4005d0 work+0x10 (/usr/bin/app)
+`,
+			stacks:  map[string]float64{"1240|app!work :0": 0.0005},
+			threads: []Thread{{ID: 1240, Name: "app"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !looksLikePerfScript(tt.script) {
+				t.Errorf("looksLikePerfScript() = false, want true")
+			}
+
+			pd, err := parsePerfScript(strings.NewReader(tt.script))
+			if err != nil {
+				t.Fatalf("parsePerfScript() error = %v", err)
+			}
+
+			got := stackTimes(pd)
+			if len(got) != len(tt.stacks) {
+				t.Errorf("got %d stacks, want %d: %v", len(got), len(tt.stacks), got)
+			}
+			for key, want := range tt.stacks {
+				if math.Abs(got[key]-want) > 1e-9 {
+					t.Errorf("stack %q: got %v, want %v", key, got[key], want)
+				}
+			}
+
+			if pd.Stats.Duration != tt.duration {
+				t.Errorf("Duration = %q, want %q", pd.Stats.Duration, tt.duration)
+			}
+			if len(pd.Threads) != len(tt.threads) {
+				t.Fatalf("Threads = %v, want %v", pd.Threads, tt.threads)
+			}
+			for i := range tt.threads {
+				if pd.Threads[i] != tt.threads[i] {
+					t.Errorf("Threads[%d] = %v, want %v", i, pd.Threads[i], tt.threads[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParsePerfScriptErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"frame outside of a sample", "\t    4005d0 work+0x10 (/usr/bin/app)\n"},
+		{"malformed frame", "app 1240 cycles:\n\t    not a frame\n"},
+		{"malformed header", "app cycles:\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parsePerfScript(strings.NewReader(tt.script)); err == nil {
+				t.Errorf("parsePerfScript() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestPerfIdleTaskRoundTrip(t *testing.T) {
+	script := `swapper 0 [000] 5012.000000: 1000000 cpu-clock:
+	ffffffff81a3c5e1 native_safe_halt+0x11 ([kernel.kallsyms])
+
+swapper 0 [001] 5012.001000: 1000000 cpu-clock:
+	ffffffff81a3c5e1 native_safe_halt+0x11 ([kernel.kallsyms])
+`
+	want, err := parsePerfScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatalf("parsePerfScript() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSleepyProfile(&buf, want); err != nil {
+		t.Fatalf("WriteSleepyProfile() error = %v", err)
+	}
+	got, err := ReadSleepyProfile(writeTempFile(t, "perf.sleepy", buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadSleepyProfile() error = %v", err)
+	}
+
+	assertSameStacks(t, got, want)
+	if name := got.ThreadName(perfIdleThreadID); name != "swapper" {
+		t.Errorf("ThreadName(%d) = %q, want %q", perfIdleThreadID, name, "swapper")
+	}
+}
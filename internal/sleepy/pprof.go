@@ -0,0 +1,842 @@
+package sleepy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pprofValueType is a profile.proto ValueType with its strings resolved
+type pprofValueType struct {
+	Type string
+	Unit string
+}
+
+type pprofSample struct {
+	LocationIDs []uint64
+	Values      []int64
+	Labels      []pprofLabel
+}
+
+// pprofLabel is a sample label with unresolved string table indices
+type pprofLabel struct {
+	Key int64
+	Str int64
+	Num int64
+}
+
+type pprofLine struct {
+	FunctionID uint64
+	Line       int64
+}
+
+type pprofLocation struct {
+	ID        uint64
+	MappingID uint64
+	Address   uint64
+	Lines     []pprofLine
+}
+
+type pprofFunction struct {
+	ID       uint64
+	Name     int64
+	Filename int64
+}
+
+type pprofMapping struct {
+	ID       uint64
+	Filename int64
+}
+
+// pprofProfile holds the parts of a decoded profile.proto this package uses
+type pprofProfile struct {
+	SampleTypes       []pprofValueType
+	sampleTypeIdx     [][2]int64 // Unresolved SampleTypes, as string table indices
+	Samples           []pprofSample
+	Mappings          []pprofMapping
+	Locations         []pprofLocation
+	Functions         []pprofFunction
+	Strings           []string
+	TimeNanos         int64
+	DurationNanos     int64
+	PeriodType        pprofValueType
+	periodTypeIdx     [2]int64
+	Period            int64
+	DefaultSampleType int64
+}
+
+// ReadPprofProfile reads a Go pprof profile (profile.proto, optionally gzipped) and maps it
+// onto ProfileData: every inlined function in a location becomes its own frame, each distinct
+// Module!Function+file+line gets one synthetic symbol address, and the module is the base name
+// of the location's mapping. Sample values are converted to seconds when the sample type has
+// a time unit; otherwise the raw values are used as weights.
+func ReadPprofProfile(filePath string) (*ProfileData, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pprof file: %w", err)
+	}
+	return parsePprof(data)
+}
+
+func parsePprof(data []byte) (*ProfileData, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		data, err = io.ReadAll(gz)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress pprof data: %w", err)
+		}
+	}
+
+	p, err := decodePprof(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode profile.proto: %w", err)
+	}
+
+	return p.toProfileData()
+}
+
+// decodePprof decodes the top-level Profile message
+func decodePprof(data []byte) (*pprofProfile, error) {
+	p := &pprofProfile{}
+	d := protoDecoder{data: data}
+
+	for {
+		field, wireType, ok, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		switch {
+		case field == 1 && wireType == wireBytes: // sample_type
+			b, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			vt, err := decodePprofValueType(b)
+			if err != nil {
+				return nil, err
+			}
+			p.sampleTypeIdx = append(p.sampleTypeIdx, vt)
+		case field == 2 && wireType == wireBytes: // sample
+			b, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			s, err := decodePprofSample(b)
+			if err != nil {
+				return nil, err
+			}
+			p.Samples = append(p.Samples, s)
+		case field == 3 && wireType == wireBytes: // mapping
+			b, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			m, err := decodePprofMapping(b)
+			if err != nil {
+				return nil, err
+			}
+			p.Mappings = append(p.Mappings, m)
+		case field == 4 && wireType == wireBytes: // location
+			b, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			loc, err := decodePprofLocation(b)
+			if err != nil {
+				return nil, err
+			}
+			p.Locations = append(p.Locations, loc)
+		case field == 5 && wireType == wireBytes: // function
+			b, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			fn, err := decodePprofFunction(b)
+			if err != nil {
+				return nil, err
+			}
+			p.Functions = append(p.Functions, fn)
+		case field == 6 && wireType == wireBytes: // string_table
+			b, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			p.Strings = append(p.Strings, string(b))
+		case field == 9 && wireType == wireVarint: // time_nanos
+			v, err := d.varint()
+			if err != nil {
+				return nil, err
+			}
+			p.TimeNanos = int64(v)
+		case field == 10 && wireType == wireVarint: // duration_nanos
+			v, err := d.varint()
+			if err != nil {
+				return nil, err
+			}
+			p.DurationNanos = int64(v)
+		case field == 11 && wireType == wireBytes: // period_type
+			b, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			if p.periodTypeIdx, err = decodePprofValueType(b); err != nil {
+				return nil, err
+			}
+		case field == 12 && wireType == wireVarint: // period
+			v, err := d.varint()
+			if err != nil {
+				return nil, err
+			}
+			p.Period = int64(v)
+		case field == 14 && wireType == wireVarint: // default_sample_type
+			v, err := d.varint()
+			if err != nil {
+				return nil, err
+			}
+			p.DefaultSampleType = int64(v)
+		default:
+			if err := d.skip(wireType); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(p.Strings) == 0 || p.Strings[0] != "" {
+		return nil, fmt.Errorf("string table must start with an empty string")
+	}
+	for _, idx := range p.sampleTypeIdx {
+		p.SampleTypes = append(p.SampleTypes, pprofValueType{Type: p.str(idx[0]), Unit: p.str(idx[1])})
+	}
+	p.PeriodType = pprofValueType{Type: p.str(p.periodTypeIdx[0]), Unit: p.str(p.periodTypeIdx[1])}
+
+	return p, nil
+}
+
+// str looks up an index in the string table, tolerating out-of-range indices
+func (p *pprofProfile) str(idx int64) string {
+	if idx < 0 || idx >= int64(len(p.Strings)) {
+		return ""
+	}
+	return p.Strings[idx]
+}
+
+func decodePprofValueType(data []byte) ([2]int64, error) {
+	var vt [2]int64
+	d := protoDecoder{data: data}
+	for {
+		field, wireType, ok, err := d.next()
+		if err != nil || !ok {
+			return vt, err
+		}
+		if (field == 1 || field == 2) && wireType == wireVarint {
+			v, err := d.varint()
+			if err != nil {
+				return vt, err
+			}
+			vt[field-1] = int64(v)
+		} else if err := d.skip(wireType); err != nil {
+			return vt, err
+		}
+	}
+}
+
+func decodePprofSample(data []byte) (pprofSample, error) {
+	var s pprofSample
+	var values []uint64
+	d := protoDecoder{data: data}
+	for {
+		field, wireType, ok, err := d.next()
+		if err != nil {
+			return s, err
+		}
+		if !ok {
+			break
+		}
+		switch field {
+		case 1: // location_id
+			if s.LocationIDs, err = d.repeatedVarint(wireType, s.LocationIDs); err != nil {
+				return s, err
+			}
+		case 2: // value
+			if values, err = d.repeatedVarint(wireType, values); err != nil {
+				return s, err
+			}
+		case 3: // label
+			b, err := d.bytes()
+			if err != nil {
+				return s, err
+			}
+			label, err := decodePprofLabel(b)
+			if err != nil {
+				return s, err
+			}
+			s.Labels = append(s.Labels, label)
+		default:
+			if err := d.skip(wireType); err != nil {
+				return s, err
+			}
+		}
+	}
+	for _, v := range values {
+		s.Values = append(s.Values, int64(v))
+	}
+	return s, nil
+}
+
+func decodePprofLabel(data []byte) (pprofLabel, error) {
+	var label pprofLabel
+	d := protoDecoder{data: data}
+	for {
+		field, wireType, ok, err := d.next()
+		if err != nil || !ok {
+			return label, err
+		}
+		if wireType != wireVarint {
+			if err := d.skip(wireType); err != nil {
+				return label, err
+			}
+			continue
+		}
+		v, err := d.varint()
+		if err != nil {
+			return label, err
+		}
+		switch field {
+		case 1:
+			label.Key = int64(v)
+		case 2:
+			label.Str = int64(v)
+		case 3:
+			label.Num = int64(v)
+		}
+	}
+}
+
+func decodePprofMapping(data []byte) (pprofMapping, error) {
+	var m pprofMapping
+	d := protoDecoder{data: data}
+	for {
+		field, wireType, ok, err := d.next()
+		if err != nil || !ok {
+			return m, err
+		}
+		if wireType != wireVarint {
+			if err := d.skip(wireType); err != nil {
+				return m, err
+			}
+			continue
+		}
+		v, err := d.varint()
+		if err != nil {
+			return m, err
+		}
+		switch field {
+		case 1:
+			m.ID = v
+		case 5:
+			m.Filename = int64(v)
+		}
+	}
+}
+
+func decodePprofLocation(data []byte) (pprofLocation, error) {
+	var loc pprofLocation
+	d := protoDecoder{data: data}
+	for {
+		field, wireType, ok, err := d.next()
+		if err != nil || !ok {
+			return loc, err
+		}
+		if field == 4 && wireType == wireBytes {
+			b, err := d.bytes()
+			if err != nil {
+				return loc, err
+			}
+			line, err := decodePprofLine(b)
+			if err != nil {
+				return loc, err
+			}
+			loc.Lines = append(loc.Lines, line)
+			continue
+		}
+		if wireType != wireVarint {
+			if err := d.skip(wireType); err != nil {
+				return loc, err
+			}
+			continue
+		}
+		v, err := d.varint()
+		if err != nil {
+			return loc, err
+		}
+		switch field {
+		case 1:
+			loc.ID = v
+		case 2:
+			loc.MappingID = v
+		case 3:
+			loc.Address = v
+		}
+	}
+}
+
+func decodePprofLine(data []byte) (pprofLine, error) {
+	var line pprofLine
+	d := protoDecoder{data: data}
+	for {
+		field, wireType, ok, err := d.next()
+		if err != nil || !ok {
+			return line, err
+		}
+		if wireType != wireVarint {
+			if err := d.skip(wireType); err != nil {
+				return line, err
+			}
+			continue
+		}
+		v, err := d.varint()
+		if err != nil {
+			return line, err
+		}
+		switch field {
+		case 1:
+			line.FunctionID = v
+		case 2:
+			line.Line = int64(v)
+		}
+	}
+}
+
+func decodePprofFunction(data []byte) (pprofFunction, error) {
+	var fn pprofFunction
+	d := protoDecoder{data: data}
+	for {
+		field, wireType, ok, err := d.next()
+		if err != nil || !ok {
+			return fn, err
+		}
+		if wireType != wireVarint {
+			if err := d.skip(wireType); err != nil {
+				return fn, err
+			}
+			continue
+		}
+		v, err := d.varint()
+		if err != nil {
+			return fn, err
+		}
+		switch field {
+		case 1:
+			fn.ID = v
+		case 2:
+			fn.Name = int64(v)
+		case 4:
+			fn.Filename = int64(v)
+		}
+	}
+}
+
+// valueIndex picks the sample value to import: the default sample type if set,
+// otherwise the first value with a time unit, otherwise the last value
+func (p *pprofProfile) valueIndex() int {
+	if p.DefaultSampleType != 0 {
+		name := p.str(p.DefaultSampleType)
+		for i, st := range p.SampleTypes {
+			if st.Type == name {
+				return i
+			}
+		}
+	}
+	for i, st := range p.SampleTypes {
+		if pprofUnitSeconds(st.Unit) > 0 {
+			return i
+		}
+	}
+	return len(p.SampleTypes) - 1
+}
+
+// pprofUnitSeconds returns how many seconds one unit is, or 0 for non-time units
+func pprofUnitSeconds(unit string) float64 {
+	switch unit {
+	case "nanoseconds", "ns":
+		return 1e-9
+	case "microseconds", "us":
+		return 1e-6
+	case "milliseconds", "ms":
+		return 1e-3
+	case "seconds", "s":
+		return 1
+	}
+	return 0
+}
+
+// toProfileData maps the decoded profile onto the Very Sleepy data model
+func (p *pprofProfile) toProfileData() (*ProfileData, error) {
+	if len(p.SampleTypes) == 0 {
+		return nil, fmt.Errorf("profile has no sample types")
+	}
+	valueIdx := p.valueIndex()
+	sampleType := p.SampleTypes[valueIdx]
+
+	// Seconds per unit of the chosen value; count-type values are scaled by a time period
+	scale := pprofUnitSeconds(sampleType.Unit)
+	if scale == 0 && p.Period > 0 {
+		if periodScale := pprofUnitSeconds(p.PeriodType.Unit); periodScale > 0 {
+			scale = float64(p.Period) * periodScale
+		}
+	}
+	if scale == 0 {
+		scale = 1
+	}
+
+	// Sample counts come from a "samples"/"count" value if there is one
+	countIdx := -1
+	for i, st := range p.SampleTypes {
+		if st.Unit == "count" || st.Type == "samples" {
+			countIdx = i
+			break
+		}
+	}
+
+	mappings := make(map[uint64]pprofMapping, len(p.Mappings))
+	for _, m := range p.Mappings {
+		mappings[m.ID] = m
+	}
+	functions := make(map[uint64]pprofFunction, len(p.Functions))
+	for _, fn := range p.Functions {
+		functions[fn.ID] = fn
+	}
+
+	pd := &ProfileData{}
+
+	// Each location expands to one frame per line, innermost (inlined) first,
+	// matching the leaf-first order of Callstack.Addresses
+	symbolAddrs := make(map[string]uint64)
+	addSymbol := func(key string, sym Symbol) uint64 {
+		if addr, exists := symbolAddrs[key]; exists {
+			return addr
+		}
+		addr := uint64(len(pd.Symbols)+1) << 4
+		sym.Address = fmt.Sprintf("0x%x", addr)
+		pd.Symbols = append(pd.Symbols, sym)
+		symbolAddrs[key] = addr
+		return addr
+	}
+
+	locationFrames := make(map[uint64][]uint64, len(p.Locations))
+	for _, loc := range p.Locations {
+		module := "?"
+		if m, exists := mappings[loc.MappingID]; exists && p.str(m.Filename) != "" {
+			module = path.Base(strings.ReplaceAll(p.str(m.Filename), "\\", "/"))
+		}
+
+		if len(loc.Lines) == 0 {
+			key := fmt.Sprintf("loc\x00%d", loc.ID)
+			locationFrames[loc.ID] = []uint64{addSymbol(key, Symbol{
+				ModuleName: module,
+				ProcName:   fmt.Sprintf("[0x%X]", loc.Address),
+			})}
+			continue
+		}
+
+		frames := make([]uint64, 0, len(loc.Lines))
+		for _, line := range loc.Lines {
+			fn := functions[line.FunctionID]
+			name := p.str(fn.Name)
+			lineModule := module
+			if lineModule == "?" {
+				lineModule = goPackageName(name)
+			}
+			sym := Symbol{
+				ModuleName: lineModule,
+				ProcName:   name,
+				FilePath:   p.str(fn.Filename),
+				LineNumber: int(line.Line),
+			}
+			key := fmt.Sprintf("%s\x00%s\x00%s\x00%d", sym.ModuleName, sym.ProcName, sym.FilePath, sym.LineNumber)
+			frames = append(frames, addSymbol(key, sym))
+		}
+		locationFrames[loc.ID] = frames
+	}
+
+	// Samples with identical stacks are merged into one callstack
+	stackIndex := make(map[string]int)
+	threadNames := make(map[int]bool)
+	numSamples := 0
+	for _, s := range p.Samples {
+		if valueIdx >= len(s.Values) {
+			return nil, fmt.Errorf("sample has %d values, expected %d", len(s.Values), len(p.SampleTypes))
+		}
+		if countIdx >= 0 && countIdx < len(s.Values) {
+			numSamples += int(s.Values[countIdx])
+		} else {
+			numSamples++
+		}
+
+		var addresses []uint64
+		for _, locID := range s.LocationIDs {
+			frames, exists := locationFrames[locID]
+			if !exists {
+				return nil, fmt.Errorf("sample references unknown location %d", locID)
+			}
+			addresses = append(addresses, frames...)
+		}
+
+		var key strings.Builder
+		for _, addr := range addresses {
+			key.WriteString(strconv.FormatUint(addr, 16))
+			key.WriteByte(' ')
+		}
+
+		// Thread labels (as written by WritePprofProfile) keep per-thread attribution
+		threadID := UnknownThreadID
+		threadName := ""
+		for _, label := range s.Labels {
+			switch p.str(label.Key) {
+			case "thread_id":
+				threadID = int(label.Num)
+			case "thread":
+				threadName = p.str(label.Str)
+			}
+		}
+		if threadID != UnknownThreadID && threadName != "" && !threadNames[threadID] {
+			threadNames[threadID] = true
+			pd.Threads = append(pd.Threads, Thread{ID: threadID, Name: threadName})
+		}
+
+		duration := float64(s.Values[valueIdx]) * scale
+		if idx, exists := stackIndex[key.String()]; exists {
+			pd.Callstacks[idx].ThreadCounts[threadID] += duration
+			continue
+		}
+		stackIndex[key.String()] = len(pd.Callstacks)
+		pd.Callstacks = append(pd.Callstacks, Callstack{
+			Addresses:    addresses,
+			ThreadCounts: map[int]float64{threadID: duration},
+		})
+	}
+
+	pd.Stats = Stats{
+		NumSamples: numSamples,
+		Duration:   strconv.FormatFloat(float64(p.DurationNanos)/1e9, 'f', -1, 64),
+	}
+	if len(p.Mappings) > 0 {
+		pd.Stats.Filename = p.str(p.Mappings[0].Filename)
+	}
+	if p.TimeNanos != 0 {
+		pd.Stats.Date = time.Unix(0, p.TimeNanos).UTC().Format(time.RFC3339)
+	}
+
+	pd.buildSymbolMap()
+
+	return pd, nil
+}
+
+// goPackageName derives a module name from a Go function name such as
+// "net/http.(*conn).serve", for profiles without mapping file names
+func goPackageName(funcName string) string {
+	slash := strings.LastIndex(funcName, "/")
+	dot := strings.Index(funcName[slash+1:], ".")
+	if dot < 0 {
+		return "?"
+	}
+	return funcName[:slash+1+dot]
+}
+
+// WritePprofProfile writes the profile as a gzipped pprof profile.proto with
+// samples/count and cpu/nanoseconds sample types. Symbols become Function and
+// Location entries, modules become Mappings, and each callstack becomes one
+// sample per thread, labeled with the thread ID and name.
+func WritePprofProfile(w io.Writer, pd *ProfileData) error {
+	strs := []string{""}
+	strIndex := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		if idx, exists := strIndex[s]; exists {
+			return idx
+		}
+		strIndex[s] = int64(len(strs))
+		strs = append(strs, s)
+		return strIndex[s]
+	}
+
+	var out protoEncoder
+
+	valueType := func(typ, unit string) []byte {
+		var vt protoEncoder
+		vt.int64Field(1, str(typ))
+		vt.int64Field(2, str(unit))
+		return vt.buf
+	}
+	out.bytesField(1, valueType("samples", "count"))
+	out.bytesField(1, valueType("cpu", "nanoseconds"))
+
+	// Mappings, functions and locations are numbered from 1 in order of first use
+	mappingIDs := make(map[string]uint64)
+	functionIDs := make(map[string]uint64)
+	locationIDs := make(map[uint64]uint64)
+	var mappings, functions, locations []protoEncoder
+
+	locationID := func(addr uint64) uint64 {
+		if id, exists := locationIDs[addr]; exists {
+			return id
+		}
+		id := uint64(len(locations) + 1)
+		locationIDs[addr] = id
+
+		var loc protoEncoder
+		loc.uint64Field(1, id)
+		loc.uint64Field(3, addr)
+
+		if sym, found := pd.symbolMap[addr]; found {
+			mappingID, exists := mappingIDs[sym.ModuleName]
+			if !exists {
+				mappingID = uint64(len(mappings) + 1)
+				mappingIDs[sym.ModuleName] = mappingID
+
+				var m protoEncoder
+				m.uint64Field(1, mappingID)
+				m.int64Field(5, str(sym.ModuleName))
+				m.boolField(7, true)                // has_functions
+				m.boolField(8, sym.FilePath != "")  // has_filenames
+				m.boolField(9, sym.LineNumber != 0) // has_line_numbers
+				mappings = append(mappings, m)
+			}
+			loc.uint64Field(2, mappingID)
+
+			funcKey := sym.ModuleName + "\x00" + sym.ProcName + "\x00" + sym.FilePath
+			functionID, exists := functionIDs[funcKey]
+			if !exists {
+				functionID = uint64(len(functions) + 1)
+				functionIDs[funcKey] = functionID
+
+				var fn protoEncoder
+				fn.uint64Field(1, functionID)
+				fn.int64Field(2, str(sym.ProcName))
+				fn.int64Field(3, str(sym.ProcName))
+				fn.int64Field(4, str(sym.FilePath))
+				functions = append(functions, fn)
+			}
+
+			var line protoEncoder
+			line.uint64Field(1, functionID)
+			line.int64Field(2, int64(sym.LineNumber))
+			loc.bytesField(4, line.buf)
+		}
+
+		locations = append(locations, loc)
+		return id
+	}
+
+	samples := pd.EstimateSamples()
+	totalNanos := int64(0)
+	for i := range pd.Callstacks {
+		cs := &pd.Callstacks[i]
+
+		ids := make([]uint64, len(cs.Addresses))
+		for j, addr := range cs.Addresses {
+			ids[j] = locationID(addr)
+		}
+
+		// Split the callstack's samples across its threads in proportion to their time
+		duration := cs.GetDuration()
+		for _, threadID := range sortedThreadIDs(cs.ThreadCounts) {
+			d := cs.ThreadCounts[threadID]
+			nanos := int64(math.Round(d * 1e9))
+			count := samples[i]
+			if duration > 0 {
+				count = int64(math.Round(float64(samples[i]) * d / duration))
+			}
+			if nanos == 0 && count == 0 {
+				continue
+			}
+			totalNanos += nanos
+
+			var sample protoEncoder
+			sample.packedField(1, ids)
+			sample.packedField(2, []uint64{uint64(count), uint64(nanos)})
+			if threadID != UnknownThreadID {
+				var label protoEncoder
+				label.int64Field(1, str("thread_id"))
+				label.int64Field(3, int64(threadID))
+				sample.bytesField(3, label.buf)
+				if name := pd.ThreadName(threadID); name != "" {
+					var nameLabel protoEncoder
+					nameLabel.int64Field(1, str("thread"))
+					nameLabel.int64Field(2, str(name))
+					sample.bytesField(3, nameLabel.buf)
+				}
+			}
+			out.bytesField(2, sample.buf)
+		}
+	}
+
+	for _, m := range mappings {
+		out.bytesField(3, m.buf)
+	}
+	for _, loc := range locations {
+		out.bytesField(4, loc.buf)
+	}
+	for _, fn := range functions {
+		out.bytesField(5, fn.buf)
+	}
+
+	// Intern the remaining strings before writing the string table
+	periodType := valueType("cpu", "nanoseconds")
+	defaultType := str("cpu")
+	var comment int64
+	if pd.Stats.Filename != "" {
+		comment = str("Very Sleepy profile of " + pd.Stats.Filename)
+	}
+
+	for _, s := range strs {
+		out.bytesField(6, []byte(s))
+	}
+
+	if t, err := time.Parse(time.RFC3339, pd.Stats.Date); err == nil {
+		out.int64Field(9, t.UnixNano())
+	}
+	durationNanos := totalNanos
+	if seconds, err := strconv.ParseFloat(pd.Stats.Duration, 64); err == nil && seconds > 0 {
+		durationNanos = int64(seconds * 1e9)
+	}
+	out.int64Field(10, durationNanos)
+	out.bytesField(11, periodType)
+	if pd.Stats.NumSamples > 0 {
+		out.int64Field(12, totalNanos/int64(pd.Stats.NumSamples))
+	}
+	if comment != 0 {
+		out.packedField(13, []uint64{uint64(comment)})
+	}
+	out.int64Field(14, defaultType)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(out.buf); err != nil {
+		return fmt.Errorf("failed to write pprof data: %w", err)
+	}
+	return gz.Close()
+}
+
+// sortedThreadIDs returns the thread IDs of a callstack in ascending order
+func sortedThreadIDs(threadCounts map[int]float64) []int {
+	ids := make([]int, 0, len(threadCounts))
+	for id := range threadCounts {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,66 @@
+package sleepy
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPprofRoundTrip(t *testing.T) {
+	want := testProfile()
+
+	var gzipped bytes.Buffer
+	if err := WritePprofProfile(&gzipped, want); err != nil {
+		t.Fatalf("WritePprofProfile() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"gzipped", gzipped.Bytes()},
+		{"raw", gunzip(t, gzipped.Bytes())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadPprofProfile(writeTempFile(t, "profile.pb", tt.data))
+			if err != nil {
+				t.Fatalf("ReadPprofProfile() error = %v", err)
+			}
+
+			assertSameStacks(t, got, want)
+			if got.Stats.Duration != want.Stats.Duration {
+				t.Errorf("Duration = %q, want %q", got.Stats.Duration, want.Stats.Duration)
+			}
+			if got.Stats.Date != want.Stats.Date {
+				t.Errorf("Date = %q, want %q", got.Stats.Date, want.Stats.Date)
+			}
+			if got.Stats.NumSamples != want.Stats.NumSamples {
+				t.Errorf("NumSamples = %d, want %d", got.Stats.NumSamples, want.Stats.NumSamples)
+			}
+			for _, thread := range want.Threads {
+				if name := got.ThreadName(thread.ID); name != thread.Name {
+					t.Errorf("ThreadName(%d) = %q, want %q", thread.ID, name, thread.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestPprofRoundTripUnknownThread(t *testing.T) {
+	want := NewProfileData(
+		Stats{NumSamples: 10},
+		[]Symbol{{Address: "0x10", ModuleName: "app", ProcName: "main"}},
+		[]Callstack{{Addresses: []uint64{0x10}, ThreadCounts: map[int]float64{UnknownThreadID: 0.01}}},
+		nil,
+	)
+
+	var buf bytes.Buffer
+	if err := WritePprofProfile(&buf, want); err != nil {
+		t.Fatalf("WritePprofProfile() error = %v", err)
+	}
+	got, err := parsePprof(buf.Bytes())
+	if err != nil {
+		t.Fatalf("parsePprof() error = %v", err)
+	}
+	assertSameStacks(t, got, want)
+}
@@ -0,0 +1,160 @@
+package sleepy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Minimal protocol buffer wire format support, just enough for profile.proto.
+// Kept in-house so the core package stays free of external dependencies.
+
+// Protocol buffer wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// protoDecoder reads fields from a single encoded message
+type protoDecoder struct {
+	data []byte
+	pos  int
+}
+
+// next reads the next field header. It returns false at the end of the message.
+func (d *protoDecoder) next() (field int, wireType int, ok bool, err error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, false, nil
+	}
+	key, err := d.varint()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return int(key >> 3), int(key & 7), true, nil
+}
+
+func (d *protoDecoder) varint() (uint64, error) {
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.pos += n
+	return v, nil
+}
+
+func (d *protoDecoder) bytes() ([]byte, error) {
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// skip discards the value of a field with the given wire type
+func (d *protoDecoder) skip(wireType int) error {
+	switch wireType {
+	case wireVarint:
+		_, err := d.varint()
+		return err
+	case wireFixed64:
+		if len(d.data)-d.pos < 8 {
+			return errTruncated
+		}
+		d.pos += 8
+	case wireBytes:
+		_, err := d.bytes()
+		return err
+	case wireFixed32:
+		if len(d.data)-d.pos < 4 {
+			return errTruncated
+		}
+		d.pos += 4
+	default:
+		return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+	}
+	return nil
+}
+
+// repeatedVarint appends a repeated integer field, which may be packed or not
+func (d *protoDecoder) repeatedVarint(wireType int, values []uint64) ([]uint64, error) {
+	if wireType == wireVarint {
+		v, err := d.varint()
+		if err != nil {
+			return values, err
+		}
+		return append(values, v), nil
+	}
+	if wireType != wireBytes {
+		return values, fmt.Errorf("unexpected wire type %d for repeated integer", wireType)
+	}
+
+	packed, err := d.bytes()
+	if err != nil {
+		return values, err
+	}
+	inner := protoDecoder{data: packed}
+	for inner.pos < len(inner.data) {
+		v, err := inner.varint()
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// protoEncoder builds a single encoded message
+type protoEncoder struct {
+	buf []byte
+}
+
+func (e *protoEncoder) key(field, wireType int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wireType))
+}
+
+// uint64Field writes a varint field, omitting zero values as proto3 does
+func (e *protoEncoder) uint64Field(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.key(field, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *protoEncoder) int64Field(field int, v int64) {
+	e.uint64Field(field, uint64(v))
+}
+
+func (e *protoEncoder) boolField(field int, v bool) {
+	if v {
+		e.uint64Field(field, 1)
+	}
+}
+
+// bytesField writes a length-delimited field, always (used for strings and sub-messages)
+func (e *protoEncoder) bytesField(field int, b []byte) {
+	e.key(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// packedField writes a packed repeated integer field
+func (e *protoEncoder) packedField(field int, values []uint64) {
+	if len(values) == 0 {
+		return
+	}
+	var packed []byte
+	for _, v := range values {
+		packed = binary.AppendUvarint(packed, v)
+	}
+	e.bytesField(field, packed)
+}
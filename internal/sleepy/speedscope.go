@@ -0,0 +1,164 @@
+package sleepy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// speedscopeSchema is the JSON schema URL speedscope uses to recognize its file format
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+type speedscopeFile struct {
+	Schema             string              `json:"$schema"`
+	Shared             speedscopeShared    `json:"shared"`
+	Profiles           []speedscopeProfile `json:"profiles"`
+	Name               string              `json:"name,omitempty"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+// speedscopeProfile is a profile of the "sampled" type: stacks of frame indices,
+// root first, each with a weight in the profile's unit
+type speedscopeProfile struct {
+	Type       string    `json:"type"`
+	Name       string    `json:"name"`
+	Unit       string    `json:"unit"`
+	StartValue float64   `json:"startValue"`
+	EndValue   float64   `json:"endValue"`
+	Samples    [][]int   `json:"samples"`
+	Weights    []float64 `json:"weights"`
+}
+
+// WriteSpeedscope writes the profile in speedscope's JSON file format with one "sampled"
+// profile per thread, weighted by callstack time in seconds. Frames are shared between
+// profiles: one per symbol, named Module!Function with its source file and line.
+// Threads with time that are not listed in Threads get a profile named after their ID.
+func WriteSpeedscope(w io.Writer, pd *ProfileData) error {
+	file := speedscopeFile{
+		Schema:   speedscopeSchema,
+		Name:     pd.Stats.Filename,
+		Exporter: "verysleepy-mcp",
+	}
+
+	// Frames for symbols come first, in symbol table order; unresolved addresses are added on use
+	frameIndex := make(map[uint64]int)
+	for i := range pd.Symbols {
+		sym := &pd.Symbols[i]
+		addr, ok := parseSymbolAddress(sym.Address)
+		if !ok {
+			continue
+		}
+		if _, exists := frameIndex[addr]; exists {
+			continue
+		}
+		frameIndex[addr] = len(file.Shared.Frames)
+		file.Shared.Frames = append(file.Shared.Frames, speedscopeFrameFor(pd.ResolveAddress(addr)))
+	}
+	frameFor := func(addr uint64) int {
+		if idx, exists := frameIndex[addr]; exists {
+			return idx
+		}
+		frameIndex[addr] = len(file.Shared.Frames)
+		file.Shared.Frames = append(file.Shared.Frames, speedscopeFrameFor(pd.ResolveAddress(addr)))
+		return frameIndex[addr]
+	}
+
+	// Listed threads keep their Threads order; unlisted ones follow by ID
+	profiles := make(map[int]*speedscopeProfile)
+	var threadOrder []int
+	for _, t := range pd.Threads {
+		if _, exists := profiles[t.ID]; exists {
+			continue
+		}
+		profiles[t.ID] = &speedscopeProfile{Name: speedscopeThreadName(t.ID, t.Name)}
+		threadOrder = append(threadOrder, t.ID)
+	}
+	var unlisted []int
+	for i := range pd.Callstacks {
+		for threadID := range pd.Callstacks[i].ThreadCounts {
+			if _, exists := profiles[threadID]; !exists {
+				profiles[threadID] = &speedscopeProfile{Name: speedscopeThreadName(threadID, "")}
+				unlisted = append(unlisted, threadID)
+			}
+		}
+	}
+	sort.Ints(unlisted)
+	threadOrder = append(threadOrder, unlisted...)
+
+	for i := range pd.Callstacks {
+		cs := &pd.Callstacks[i]
+		if len(cs.Addresses) == 0 {
+			continue
+		}
+
+		// Addresses are leaf-first; speedscope stacks are root-first
+		stack := make([]int, len(cs.Addresses))
+		for j, addr := range cs.Addresses {
+			stack[len(cs.Addresses)-1-j] = frameFor(addr)
+		}
+
+		for _, threadID := range sortedThreadIDs(cs.ThreadCounts) {
+			d := cs.ThreadCounts[threadID]
+			if d <= 0 {
+				continue
+			}
+			p := profiles[threadID]
+			p.Samples = append(p.Samples, stack)
+			p.Weights = append(p.Weights, d)
+			p.EndValue += d
+		}
+	}
+
+	for _, threadID := range threadOrder {
+		p := profiles[threadID]
+		if len(p.Samples) == 0 {
+			continue // Listed threads without time would show up as empty tabs
+		}
+		p.Type = "sampled"
+		p.Unit = "seconds"
+		file.Profiles = append(file.Profiles, *p)
+	}
+	if file.Profiles == nil {
+		file.Profiles = []speedscopeProfile{}
+	}
+	if file.Shared.Frames == nil {
+		file.Shared.Frames = []speedscopeFrame{}
+	}
+
+	if err := json.NewEncoder(w).Encode(file); err != nil {
+		return fmt.Errorf("failed to write speedscope JSON: %w", err)
+	}
+	return nil
+}
+
+// speedscopeFrameFor converts a resolved frame to a speedscope frame
+func speedscopeFrameFor(frame ResolvedFrame) speedscopeFrame {
+	name := frame.Function
+	if frame.Module != "" && frame.Module != "?" {
+		name = frame.Module + "!" + frame.Function
+	}
+	return speedscopeFrame{Name: name, File: frame.SourceFile, Line: frame.LineNumber}
+}
+
+// speedscopeThreadName names a thread's profile, e.g. "Main Thread (4528)"
+func speedscopeThreadName(threadID int, name string) string {
+	switch {
+	case threadID == UnknownThreadID:
+		return "Unknown thread"
+	case name == "":
+		return fmt.Sprintf("Thread %d", threadID)
+	}
+	return fmt.Sprintf("%s (%d)", name, threadID)
+}
@@ -1,56 +1,107 @@
-package sleepy
-
-// Stats represents the data from Stats.txt
-type Stats struct {
-	Filename   string
-	Duration   string
-	Date       string
-	NumSamples int
-}
-
-// Symbol represents a single entry from Symbols.txt
-type Symbol struct {
-	Address    string
-	ModuleName string
-	ProcName   string
-	FilePath   string
-	LineNumber int
-}
-
-// Callstack represents a single call stack entry from Callstacks.txt
-type Callstack struct {
-	Addresses    []uint64
-	ThreadCounts map[int]float64 // Map of ThreadID to Count
-}
-
-// Thread represents a single entry from Threads.txt
-type Thread struct {
-	ID   int
-	Name string
-}
-
-// ProfileData holds all the parsed data from a sleepy profile file
-type ProfileData struct {
-	Stats      Stats
-	Symbols    []Symbol
-	Callstacks []Callstack
-	Threads    []Thread
-	symbolMap  map[uint64]*Symbol // Internal map for fast symbol lookup
-}
-
-// ResolvedFrame represents a single frame in a callstack with resolved symbol information
-type ResolvedFrame struct {
-	Address    uint64
-	Module     string
-	Function   string
-	SourceFile string
-	LineNumber int
-}
-
-// GetDuration returns the duration for a callstack
-func (cs *Callstack) GetDuration() float64 {
-	for _, d := range cs.ThreadCounts {
-		return d // Return first (and usually only) duration
-	}
-	return 0.0
-}
+package sleepy
+
+import "sync"
+
+// Stats represents the data from Stats.txt
+type Stats struct {
+	Filename   string `json:"filename"`
+	Duration   string `json:"duration"`
+	Date       string `json:"date"`
+	NumSamples int    `json:"samples"`
+}
+
+// Symbol represents a single entry from Symbols.txt
+type Symbol struct {
+	Address    string
+	ModuleName string
+	ProcName   string
+	FilePath   string
+	LineNumber int
+}
+
+// Callstack represents a single call stack entry from Callstacks.txt
+type Callstack struct {
+	Addresses    []uint64        // Frame addresses, leaf (innermost) first
+	ThreadCounts map[int]float64 // Map of ThreadID to time (seconds) spent in this callstack
+}
+
+// UnknownThreadID keys ThreadCounts when the profile does not say which thread a callstack came from
+const UnknownThreadID = 0
+
+// Thread represents a single entry from Threads.txt
+type Thread struct {
+	ID   int
+	Name string
+}
+
+// ProfileData holds all the parsed data from a sleepy profile file
+type ProfileData struct {
+	Stats      Stats
+	Symbols    []Symbol
+	Callstacks []Callstack
+	Threads    []Thread
+	symbolMap  map[uint64]*Symbol // Internal map for fast symbol lookup
+	functions  *functionTable     // Interned functions, shared with derived profiles
+	index      *ProfileIndex      // Built on first use by Index
+	indexOnce  sync.Once
+}
+
+// ResolvedFrame represents a single frame in a callstack with resolved symbol information
+type ResolvedFrame struct {
+	Address    uint64 `json:"address"`
+	Module     string `json:"module"`
+	Function   string `json:"function"`
+	SourceFile string `json:"source_file"`
+	LineNumber int    `json:"line_number"`
+}
+
+// GetDuration returns the total duration for a callstack across all threads
+func (cs *Callstack) GetDuration() float64 {
+	total := 0.0
+	for _, d := range cs.ThreadCounts {
+		total += d
+	}
+	return total
+}
+
+// ApproximateSize estimates the memory held by the profile in bytes: its symbols,
+// callstacks and threads, plus the function index once it has been built.
+// Map and slice overheads are rough averages, so use it for budgeting only.
+func (pd *ProfileData) ApproximateSize() int64 {
+	const (
+		stringHeader = 16
+		sliceHeader  = 24
+		mapEntry     = 48 // Key, value and bucket overhead of a small map entry
+	)
+
+	size := int64(0)
+	for i := range pd.Symbols {
+		sym := &pd.Symbols[i]
+		size += 5*stringHeader + 8 + int64(len(sym.Address)+len(sym.ModuleName)+len(sym.ProcName)+len(sym.FilePath))
+	}
+	size += int64(len(pd.symbolMap)) * mapEntry
+
+	for i := range pd.Callstacks {
+		cs := &pd.Callstacks[i]
+		size += sliceHeader + 8 + int64(len(cs.Addresses))*8 + int64(len(cs.ThreadCounts))*mapEntry
+	}
+
+	for _, t := range pd.Threads {
+		size += stringHeader + 8 + int64(len(t.Name))
+	}
+
+	if pd.functions != nil {
+		size += int64(len(pd.functions.addrFunctions)+len(pd.functions.functionIDs)) * mapEntry
+		for i := range pd.functions.functions {
+			fn := &pd.functions.functions[i]
+			size += 4*stringHeader + 16 + int64(len(fn.Signature)+len(fn.SourceFile))
+		}
+	}
+	if pd.index != nil {
+		for _, stack := range pd.index.Stacks {
+			size += sliceHeader + 8 + int64(len(stack))*8
+		}
+	}
+
+	return size
+}
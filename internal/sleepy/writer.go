@@ -0,0 +1,105 @@
+package sleepy
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteSleepyProfile writes the profile as a .sleepy ZIP archive with Stats.txt, Symbols.txt,
+// Callstacks.txt and Threads.txt in the formats ReadSleepyProfile parses, so that reading
+// the result gives back the same profile. Callstacks on a single thread get a plain thread
+// column, including UnknownThreadID; callstacks split across threads get
+// "threadID:duration" columns.
+// Double quotes in symbol names cannot be represented and are dropped; threads without a
+// name are written as "Thread <id>".
+func WriteSleepyProfile(w io.Writer, pd *ProfileData) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name  string
+		write func(w *bufio.Writer)
+	}{
+		{"Stats.txt", func(bw *bufio.Writer) { writeStats(bw, &pd.Stats) }},
+		{"Symbols.txt", func(bw *bufio.Writer) { writeSymbols(bw, pd.Symbols) }},
+		{"Callstacks.txt", func(bw *bufio.Writer) { writeCallstacks(bw, pd.Callstacks) }},
+		{"Threads.txt", func(bw *bufio.Writer) { writeThreads(bw, pd.Threads) }},
+	}
+
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return fmt.Errorf("failed to create %s in zip: %w", file.name, err)
+		}
+		bw := bufio.NewWriter(fw)
+		file.write(bw)
+		if err := bw.Flush(); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish zip file: %w", err)
+	}
+	return nil
+}
+
+func writeStats(w *bufio.Writer, stats *Stats) {
+	fmt.Fprintf(w, "Filename: %s\n", stats.Filename)
+	fmt.Fprintf(w, "Duration: %s\n", stats.Duration)
+	fmt.Fprintf(w, "Date: %s\n", stats.Date)
+	fmt.Fprintf(w, "Samples: %d\n", stats.NumSamples)
+}
+
+func writeSymbols(w *bufio.Writer, symbols []Symbol) {
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+	}
+	for _, sym := range symbols {
+		fmt.Fprintf(w, "%s %s %s %s %d\n",
+			sym.Address, quote(sym.ModuleName), quote(sym.ProcName), quote(sym.FilePath), sym.LineNumber)
+	}
+}
+
+func writeCallstacks(w *bufio.Writer, callstacks []Callstack) {
+	for i := range callstacks {
+		cs := &callstacks[i]
+		duration := cs.GetDuration()
+		w.WriteString(formatSeconds(duration))
+
+		// The unknown thread is written explicitly too: a callstack without a thread
+		// column would be attributed to the profile's only thread when read back
+		threadIDs := sortedThreadIDs(cs.ThreadCounts)
+		if len(threadIDs) == 1 {
+			fmt.Fprintf(w, " %d", threadIDs[0])
+		} else {
+			for _, threadID := range threadIDs {
+				fmt.Fprintf(w, " %d:%s", threadID, formatSeconds(cs.ThreadCounts[threadID]))
+			}
+		}
+
+		for _, addr := range cs.Addresses {
+			fmt.Fprintf(w, " 0x%x", addr)
+		}
+		w.WriteByte('\n')
+	}
+}
+
+func writeThreads(w *bufio.Writer, threads []Thread) {
+	for _, t := range threads {
+		name := t.Name
+		if strings.TrimSpace(name) == "" {
+			// Blank lines are skipped when reading, which would shift the ID/name pairs
+			name = fmt.Sprintf("Thread %d", t.ID)
+		}
+		fmt.Fprintf(w, "%d\n%s\n", t.ID, name)
+	}
+}
+
+// formatSeconds formats a duration with the fewest digits that parse back to the same value
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
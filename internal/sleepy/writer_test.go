@@ -0,0 +1,173 @@
+package sleepy
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSleepyRoundTrip(t *testing.T) {
+	symbols := []Symbol{
+		{Address: "0x10", ModuleName: "app.exe", ProcName: "main", FilePath: `C:\src\main.c`, LineNumber: 1},
+		{Address: "0x20", ModuleName: "app.exe", ProcName: "work", FilePath: "work.c", LineNumber: 10},
+	}
+
+	tests := []struct {
+		name    string
+		profile *ProfileData
+	}{
+		{"multiple threads", testProfile()},
+		{
+			name: "unknown thread beside a single real thread",
+			profile: NewProfileData(Stats{NumSamples: 3}, symbols,
+				[]Callstack{
+					{Addresses: []uint64{0x20, 0x10}, ThreadCounts: map[int]float64{UnknownThreadID: 0.25}},
+					{Addresses: []uint64{0x10}, ThreadCounts: map[int]float64{5: 0.5}},
+				},
+				[]Thread{{ID: 5, Name: "main"}},
+			),
+		},
+		{
+			name: "unknown thread split with a real thread",
+			profile: NewProfileData(Stats{NumSamples: 3}, symbols,
+				[]Callstack{
+					{Addresses: []uint64{0x20, 0x10}, ThreadCounts: map[int]float64{UnknownThreadID: 0.25, 5: 0.125}},
+				},
+				[]Thread{{ID: 5, Name: "main"}},
+			),
+		},
+		{
+			name: "unknown thread only",
+			profile: NewProfileData(Stats{NumSamples: 1}, symbols,
+				[]Callstack{{Addresses: []uint64{0x20, 0x10}, ThreadCounts: map[int]float64{UnknownThreadID: 0.1}}},
+				nil,
+			),
+		},
+		{
+			name: "unnamed thread",
+			profile: NewProfileData(Stats{NumSamples: 1}, symbols,
+				[]Callstack{{Addresses: []uint64{0x10}, ThreadCounts: map[int]float64{9: 0.1}}},
+				[]Thread{{ID: 9}},
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteSleepyProfile(&buf, tt.profile); err != nil {
+				t.Fatalf("WriteSleepyProfile() error = %v", err)
+			}
+			got, err := ReadSleepyProfile(writeTempFile(t, "profile.sleepy", buf.Bytes()))
+			if err != nil {
+				t.Fatalf("ReadSleepyProfile() error = %v", err)
+			}
+
+			assertSameStacks(t, got, tt.profile)
+			if got.Stats != tt.profile.Stats {
+				t.Errorf("Stats = %+v, want %+v", got.Stats, tt.profile.Stats)
+			}
+			if len(got.Symbols) != len(tt.profile.Symbols) {
+				t.Errorf("got %d symbols, want %d", len(got.Symbols), len(tt.profile.Symbols))
+			}
+			if len(got.Threads) != len(tt.profile.Threads) {
+				t.Fatalf("Threads = %v, want %v", got.Threads, tt.profile.Threads)
+			}
+			for i, thread := range tt.profile.Threads {
+				if got.Threads[i].ID != thread.ID {
+					t.Errorf("Threads[%d].ID = %d, want %d", i, got.Threads[i].ID, thread.ID)
+				}
+				if thread.Name != "" && got.Threads[i].Name != thread.Name {
+					t.Errorf("Threads[%d].Name = %q, want %q", i, got.Threads[i].Name, thread.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteCallstacksThreadColumns(t *testing.T) {
+	pd := NewProfileData(Stats{}, nil,
+		[]Callstack{
+			{Addresses: []uint64{0x10}, ThreadCounts: map[int]float64{UnknownThreadID: 0.5}},
+			{Addresses: []uint64{0x10}, ThreadCounts: map[int]float64{7: 0.25}},
+			{Addresses: []uint64{0x10}, ThreadCounts: map[int]float64{7: 0.25, UnknownThreadID: 0.5}},
+		},
+		nil,
+	)
+	want := "0.5 0 0x10\n0.25 7 0x10\n0.75 0:0.5 7:0.25 0x10\n"
+
+	var buf bytes.Buffer
+	if err := WriteSleepyProfile(&buf, pd); err != nil {
+		t.Fatalf("WriteSleepyProfile() error = %v", err)
+	}
+	if got := readZipEntry(t, buf.Bytes(), "Callstacks.txt"); got != want {
+		t.Errorf("Callstacks.txt =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestReadSleepyProfileThreadlessCallstacks(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"Symbols.txt":    "0x10 \"app\" \"main\" \"\" 0\n",
+		"Callstacks.txt": "0.5 0x10\n0.25 0 0x10\n",
+		"Threads.txt":    "5\nmain\n",
+	} {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pd, err := ReadSleepyProfile(writeTempFile(t, "old.sleepy", buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadSleepyProfile() error = %v", err)
+	}
+
+	// Older files without a thread column belong to the only thread; an explicit 0 stays unknown
+	want := []map[int]float64{{5: 0.5}, {UnknownThreadID: 0.25}}
+	for i, counts := range want {
+		got := pd.Callstacks[i].ThreadCounts
+		if len(got) != len(counts) {
+			t.Errorf("Callstacks[%d].ThreadCounts = %v, want %v", i, got, counts)
+			continue
+		}
+		for threadID, d := range counts {
+			if got[threadID] != d {
+				t.Errorf("Callstacks[%d].ThreadCounts = %v, want %v", i, got, counts)
+			}
+		}
+	}
+}
+
+// readZipEntry returns the contents of a file in a ZIP archive
+func readZipEntry(t *testing.T, archive []byte, name string) string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		var sb strings.Builder
+		if _, err := io.Copy(&sb, rc); err != nil {
+			t.Fatal(err)
+		}
+		return sb.String()
+	}
+	t.Fatalf("%s not found in archive", name)
+	return ""
+}